@@ -8,8 +8,6 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
 	"github.com/golang/protobuf/proto"
 	crypto2 "github.com/libp2p/go-libp2p-core/crypto"
-	"io/ioutil"
-	"os"
 
 	"github.com/Evanesco-Labs/WhiteNoise/common/store"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -17,6 +15,8 @@ import (
 
 const DB_DIR = "./db"
 
+const keystorePrefix = "keystore/"
+
 const DefaultKeyType = crypto.DefaultKeyType
 
 type LevelDB struct {
@@ -29,72 +29,41 @@ type Account struct {
 	privKey crypto.PrivateKey
 }
 
-func GetAccount(keyType int) *Account {
+//GetAccount loads the default account from the keystore in DB_DIR, or creates one of keyType if there is none or it
+//is of another type. A wrong passphrase is an error, the account is never replaced then.
+func GetAccount(keyType int, passphrase []byte) (*Account, error) {
 	leveldb, err := OpenLevelDB(DB_DIR)
 	if err != nil {
-		log.Error("in GetAccount open leveldb err:", err.Error())
+		return nil, err
 	}
+	defer leveldb.Close()
 
-	account, err := leveldb.QueryDefaultAccount()
-	if account != nil && err == nil && account.KeyType == keyType {
+	account, err := leveldb.QueryDefaultAccount(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if account != nil && account.KeyType == keyType {
 		log.Info("get default account from leveldb")
-		return account
+		return account, nil
 	}
 
 	r := rand.Reader
 	priv, pub, err := crypto.GenerateKeyPair(keyType, r)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	account = &Account{
 		KeyType: keyType,
 		pubKey:  pub,
 		privKey: priv,
 	}
-	err = leveldb.InsertOrUpdateAccount(account)
+	err = leveldb.InsertOrUpdateAccount(account, passphrase)
 	if err != nil {
 		log.Error("in GetAccount, insert or update accout err:", err.Error())
-		return nil
+		return nil, err
 	}
 	log.Info("no account, create default one successfully.")
-	return account
-}
-
-func GetAccountFromFile(path string) *Account {
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		if err == os.ErrNotExist {
-			log.Error("key file not exit")
-		}
-		return nil
-	}
-	if fileInfo.IsDir() {
-		return nil
-	}
-
-	f, err := os.Open(path)
-	defer f.Close()
-	if err != nil {
-		log.Error("open file err", err)
-		return nil
-	}
-
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil
-	}
-	priv, err := crypto.DecodeEcdsaPriv(string(data))
-	if err != nil {
-		log.Error(err)
-		return nil
-	}
-	privKey := crypto.ECDSAPrivateKey{Priv: priv}
-
-	return &Account{
-		KeyType: crypto.ECDSA,
-		pubKey:  privKey.Public(),
-		privKey: privKey,
-	}
+	return account, nil
 }
 
 func NewOneTimeAccount(keyType int) (*Account, error) {
@@ -126,40 +95,79 @@ func (this *LevelDB) Close() {
 	this.db.Close()
 }
 
-func (this *LevelDB) InsertOrUpdateAccount(acc *Account) error {
-	pbAccount := pb.Account{
-		Type:       int32(acc.KeyType),
-		PrivateKey: acc.privKey.Bytes(),
-		PublicKey:  acc.pubKey.Bytes(),
-	}
-	data, err := proto.Marshal(&pbAccount)
+//keystoreKey is where the encrypted account label is stored, plaintext accounts used to be stored under label itself.
+func keystoreKey(label string) []byte {
+	return []byte(keystorePrefix + label)
+}
+
+//InsertOrUpdateAccount stores acc as the default account, encrypted with passphrase.
+func (this *LevelDB) InsertOrUpdateAccount(acc *Account, passphrase []byte) error {
+	return this.insertAccount("default", acc, passphrase)
+}
+
+func (this *LevelDB) insertAccount(label string, acc *Account, passphrase []byte) error {
+	data, err := EncryptAccount(acc, passphrase)
 	if err != nil {
 		return err
 	}
-	return this.db.Put([]byte("default"), data)
+	if err = this.db.Put(keystoreKey(label), data); err != nil {
+		return err
+	}
+	//drop the plaintext account of older versions
+	return this.db.Delete([]byte(label))
 }
 
-func (this *LevelDB) QueryDefaultAccount() (*Account, error) {
-	return this.QueryAccount("default")
+func (this *LevelDB) QueryDefaultAccount(passphrase []byte) (*Account, error) {
+	return this.QueryAccount("default", passphrase)
 }
 
-func (this *LevelDB) QueryAccount(label string) (*Account, error) {
-	key := []byte(label)
-	value, err := this.db.Get(key)
-	if err != nil {
-		if err != leveldb.ErrNotFound {
-			return nil, err
-		}
+//QueryAccount returns the account stored under label, or nil if there is none. A plaintext account of an older
+//version is encrypted with passphrase on the way.
+func (this *LevelDB) QueryAccount(label string, passphrase []byte) (*Account, error) {
+	value, err := this.db.Get(keystoreKey(label))
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+	if len(value) != 0 {
+		return DecryptAccount(value, passphrase)
+	}
+
+	value, err = this.db.Get([]byte(label))
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
 	}
 	if len(value) == 0 {
 		return nil, nil
 	}
-
 	pbAccount := pb.Account{}
 	err = proto.Unmarshal(value, &pbAccount)
 	if err != nil {
 		return nil, err
 	}
+	account, err := accountFromPB(&pbAccount)
+	if err != nil {
+		return nil, err
+	}
+	if err = this.insertAccount(label, account, passphrase); err != nil {
+		return nil, err
+	}
+	//the deleted plaintext is only gone from disk once its range is compacted
+	if err = this.db.Compact([]byte(label), append([]byte(label), 0)); err != nil {
+		log.Warnf("compact plaintext account %v err: %v", label, err)
+	}
+	log.Infof("encrypted plaintext account %v", label)
+	return account, nil
+}
+
+func (acc *Account) toPB() *pb.Account {
+	return &pb.Account{
+		Type:       int32(acc.KeyType),
+		PrivateKey: acc.privKey.Bytes(),
+		PublicKey:  acc.pubKey.Bytes(),
+	}
+}
+
+func accountFromPB(pbAccount *pb.Account) (*Account, error) {
 	switch int(pbAccount.Type) {
 	case crypto.Ed25519:
 		publicKey, err := crypto.UnMarshallEd25519PublicKey(pbAccount.PublicKey)
@@ -0,0 +1,172 @@
+package account
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//KeystorePEMType is the PEM block type of exported encrypted key files.
+const KeystorePEMType = "WHITENOISE ENCRYPTED KEY"
+
+const (
+	keystoreVersion = 1
+	kdfScrypt       = "scrypt"
+	cipherChacha    = "chacha20-poly1305"
+	saltLength      = 32
+)
+
+//scrypt parameters of new keystores, about 32MB of memory and a tenth of a second per derivation
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	//most memory a keystore may make us spend, 128*N*r bytes
+	scryptMaxMem = 1 << 30
+	//most work a keystore may make us spend, p times the work of the memory above
+	scryptMaxP = 16
+)
+
+var (
+	ErrWrongPassphrase  = errors.New("wrong passphrase or corrupted keystore")
+	ErrInvalidKeystore  = errors.New("invalid keystore")
+	ErrPlaintextKeyFile = errors.New("plaintext key file")
+)
+
+//EncryptAccount seals the account with a key derived from passphrase by scrypt.
+func EncryptAccount(acc *Account, passphrase []byte) ([]byte, error) {
+	plain, err := proto.Marshal(acc.toPB())
+	if err != nil {
+		return nil, err
+	}
+	ks := pb.Keystore{
+		Version: keystoreVersion,
+		Kdf:     kdfScrypt,
+		Salt:    make([]byte, saltLength),
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Cipher:  cipherChacha,
+		Nonce:   make([]byte, chacha20poly1305.NonceSize),
+	}
+	if _, err := rand.Read(ks.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(ks.Nonce); err != nil {
+		return nil, err
+	}
+	aead, err := keystoreAEAD(&ks, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ks.Ciphertext = aead.Seal(nil, ks.Nonce, plain, nil)
+	return proto.Marshal(&ks)
+}
+
+//DecryptAccount opens a keystore made by EncryptAccount.
+func DecryptAccount(data []byte, passphrase []byte) (*Account, error) {
+	var ks pb.Keystore
+	if err := proto.Unmarshal(data, &ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != keystoreVersion || ks.Kdf != kdfScrypt || ks.Cipher != cipherChacha {
+		return nil, fmt.Errorf("%w: version %v kdf %q cipher %q", ErrInvalidKeystore, ks.Version, ks.Kdf, ks.Cipher)
+	}
+	if len(ks.Nonce) != chacha20poly1305.NonceSize || uint64(ks.N)*uint64(ks.R)*128 > scryptMaxMem || ks.P > scryptMaxP {
+		return nil, ErrInvalidKeystore
+	}
+	aead, err := keystoreAEAD(&ks, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, ks.Nonce, ks.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	var pbAccount pb.Account
+	if err := proto.Unmarshal(plain, &pbAccount); err != nil {
+		return nil, err
+	}
+	return accountFromPB(&pbAccount)
+}
+
+func keystoreAEAD(ks *pb.Keystore, passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, ks.Salt, int(ks.N), int(ks.R), int(ks.P), chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeystore, err)
+	}
+	return chacha20poly1305.New(key)
+}
+
+//ExportAccount writes the account to a key file at path, encrypted with passphrase and readable by the owner only.
+func ExportAccount(acc *Account, path string, passphrase []byte) error {
+	data, err := EncryptAccount(acc, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: KeystorePEMType, Bytes: data}))
+}
+
+//GetAccountFromFile loads the account in the key file at path. A plaintext ECDSA key file is encrypted with
+//passphrase in place.
+func GetAccountFromFile(path string, passphrase []byte) (*Account, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block in %v", ErrInvalidKeystore, path)
+	}
+	if block.Type == KeystorePEMType {
+		return DecryptAccount(block.Bytes, passphrase)
+	}
+	//key files used to hold a plaintext ECDSA key
+	privKey, err := crypto.UnMarshallECDSAPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	acc := &Account{
+		KeyType: crypto.ECDSA,
+		pubKey:  privKey.Public(),
+		privKey: privKey,
+	}
+	if err := ExportAccount(acc, path, passphrase); err != nil {
+		return nil, fmt.Errorf("encrypt %w %v: %v", ErrPlaintextKeyFile, path, err)
+	}
+	log.Infof("encrypted plaintext key file %v", path)
+	return acc, nil
+}
+
+//writeFileAtomic replaces the file at path, so an interrupted write never loses the key that was there. The temp
+//file, and so the new file, is only readable by the owner.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,137 @@
+package account
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common/store"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/magiconair/properties/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	pass := []byte("correct horse")
+	for _, keyType := range []int{crypto.Ed25519, crypto.Secpk1, crypto.ECDSA} {
+		acc, err := NewOneTimeAccount(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := EncryptAccount(acc, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecryptAccount(data, pass)
+		if err != nil {
+			t.Fatalf("key type %v: %v", keyType, err)
+		}
+		assert.Equal(t, got.KeyType, keyType)
+		assert.Equal(t, got.GetPrivateKey().Bytes(), acc.GetPrivateKey().Bytes())
+
+		_, err = DecryptAccount(data, []byte("wrong"))
+		assert.Equal(t, err, ErrWrongPassphrase)
+	}
+
+	//a keystore may not make us spend more than scryptMaxMem, nor more than scryptMaxP times the work of it
+	acc, _ := NewOneTimeAccount(crypto.Ed25519)
+	data, _ := EncryptAccount(acc, pass)
+	for _, tamper := range []func(ks *pb.Keystore){
+		func(ks *pb.Keystore) { ks.N = 1 << 30 },
+		func(ks *pb.Keystore) { ks.P = 1 << 20 },
+	} {
+		var ks pb.Keystore
+		proto.Unmarshal(data, &ks)
+		tamper(&ks)
+		costly, _ := proto.Marshal(&ks)
+		_, err := DecryptAccount(costly, pass)
+		assert.Equal(t, err, ErrInvalidKeystore)
+	}
+}
+
+func TestMigrateLevelDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ldb, err := store.NewLevelDBStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewLevelDB(ldb)
+
+	//an account stored in plaintext by an older version
+	acc, _ := NewOneTimeAccount(crypto.Ed25519)
+	data, _ := proto.Marshal(acc.toPB())
+	ldb.Put([]byte("default"), data)
+
+	pass := []byte("correct horse")
+	got, err := db.QueryDefaultAccount(pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.GetPrivateKey().Bytes(), acc.GetPrivateKey().Bytes())
+	if _, err := ldb.Get([]byte("default")); err == nil {
+		t.Fatal("expect plaintext account removed")
+	}
+
+	got, err = db.QueryDefaultAccount(pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.GetPrivateKey().Bytes(), acc.GetPrivateKey().Bytes())
+	_, err = db.QueryDefaultAccount([]byte("wrong"))
+	assert.Equal(t, err, ErrWrongPassphrase)
+
+	//the plaintext was compacted out of the files of the store, leveldb removes the old files once they are released
+	db.Close()
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if bytes.Contains(content, acc.GetPrivateKey().Bytes()) {
+			t.Fatalf("plaintext account left in %v", file.Name())
+		}
+	}
+}
+
+func TestMigrateKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key.pem")
+
+	acc, _ := NewOneTimeAccount(crypto.ECDSA)
+	plain, err := crypto.EncodeEcdsaPriv(acc.GetPrivateKey().(crypto.ECDSAPrivateKey).Priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path, []byte(plain), 0644)
+
+	pass := []byte("correct horse")
+	got, err := GetAccountFromFile(path, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.GetPrivateKey().Bytes(), acc.GetPrivateKey().Bytes())
+
+	data, _ := ioutil.ReadFile(path)
+	block, _ := pem.Decode(data)
+	assert.Equal(t, block.Type, KeystorePEMType)
+	info, _ := os.Stat(path)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+
+	got, err = GetAccountFromFile(path, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.GetPrivateKey().Bytes(), acc.GetPrivateKey().Bytes())
+	_, err = GetAccountFromFile(path, []byte("wrong"))
+	assert.Equal(t, errors.Is(err, ErrWrongPassphrase), true)
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+//ParseBootstrapPeers parses bootstrap multiaddrs, addresses of the same peer are merged into one AddrInfo.
+func ParseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
+	maddrs := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parse bootstrap address %v: %w", addr, err)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return peer.AddrInfosFromP2pAddrs(maddrs...)
+}
+
+//ShuffleBootstrapPeers returns the bootstrap peers in random order, so nodes don't all lean on the first one.
+func ShuffleBootstrapPeers(peers []peer.AddrInfo) []peer.AddrInfo {
+	shuffled := make([]peer.AddrInfo, len(peers))
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i, j := range r.Perm(len(peers)) {
+		shuffled[i] = peers[j]
+	}
+	return shuffled
+}
+
+//ReadBootstrapFile reads bootstrap multiaddrs from a file, one per line. Blank lines and lines starting with # are skipped.
+func ReadBootstrapFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	addrs := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"github.com/magiconair/properties/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBootstrapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bootstrap.txt")
+	content := `# bootstrap nodes
+/ip4/127.0.0.1/tcp/3331/p2p/QmdLEFWxMNZ5dKGKNn8tJHZG2RDnMXrzBkp94heQeUZYCr
+
+  /ip4/10.0.0.1/tcp/3331/p2p/QmdLEFWxMNZ5dKGKNn8tJHZG2RDnMXrzBkp94heQeUZYCr
+/ip4/127.0.0.1/tcp/3332/p2p/QmXkCpR1CtDqPWQ7RrUgSg2aikFPMFbk8oZYo1wRn3wLGH
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := ReadBootstrapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(addrs), 3)
+
+	//addresses of the same node are merged
+	peers, err := ParseBootstrapPeers(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(peers), 2)
+	assert.Equal(t, len(ShuffleBootstrapPeers(peers)), 2)
+
+	_, err = ParseBootstrapPeers([]string{"not a multiaddr"})
+	assert.Equal(t, err != nil, true)
+}
@@ -1,6 +1,9 @@
 package config
 
-import "github.com/libp2p/go-libp2p-core/peer"
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"time"
+)
 
 var WhiteListPeers map[peer.ID]bool
 
@@ -14,11 +17,70 @@ type YmlConfig struct {
 	Whitelist []string
 }
 
+type CoverMode int
+
+const (
+	CoverOff CoverMode = iota
+	CoverConstant
+	CoverPoisson
+)
+
+//CoverConfig sets how dummy cells are generated, Rate is in cells per second.
+type CoverConfig struct {
+	Mode CoverMode
+	Rate float64
+}
+
+func (c CoverConfig) Enabled() bool {
+	return c.Mode != CoverOff && c.Rate > 0
+}
+
+type MixMode int
+
+const (
+	MixOff MixMode = iota
+	MixDelay
+	MixBatch
+)
+
+//MixConfig sets how a relay node mixes the data messages it forwards. MixDelay holds every message for a random
+//delay with mean Delay, MixBatch forwards messages in shuffled batches of BatchSize, or of what arrived within Delay.
+type MixConfig struct {
+	Mode      MixMode
+	Delay     time.Duration
+	BatchSize int
+}
+
+func (c MixConfig) Enabled() bool {
+	return c.Mode != MixOff && c.Delay > 0
+}
+
+//LookupMode sets how the proxy of a caller finds the proxy of the destination.
+type LookupMode int
+
+const (
+	//negotiations are flooded to every MainNet node over gossipsub
+	LookupGossip LookupMode = iota
+	//proxies publish blinded destination records of their clients in the DHT, negotiations are sent to the proxy of
+	//the destination only, gossip is the fallback when no record is found
+	LookupDHT
+)
+
 type NetworkConfig struct {
 	RendezvousString string
 	ListenHost       string
 	ListenPort       int
-	BootStrapPeers   string
+	BootStrapPeers   []string //multiaddrs of bootstrap nodes, tried in random order
 	Mode             ServiceMode
 	WhiteList        bool
+	//leveldb directory the node keeps known peers in across restarts, empty turns it off
+	PeerStorePath string
+	//cover traffic on every relay stream to a neighbour peer
+	LinkCover CoverConfig
+	//default end-to-end cover traffic on circuits this node ends
+	CircuitCover CoverConfig
+	//mixing of forwarded data messages, advertised to other nodes
+	Mix MixConfig
+	//how negotiations of new circuits find the proxy of the destination
+	Lookup LookupMode
 }
@@ -19,6 +19,7 @@ const (
 	SetSessionTimeout       time.Duration = time.Second
 	ExpendSessionTimeout    time.Duration = time.Second * 3
 	RegisterProxyTimeout    time.Duration = time.Second
+	ProxyChallengeTimeout   time.Duration = time.Second * 10
 	NewCircuitTimeout       time.Duration = time.Second * 5
 	DecryptReqTimeout       time.Duration = time.Millisecond * 500
 	ReadHandShakeMsgTimeout time.Duration = time.Second
@@ -31,18 +32,94 @@ const (
 
 const RetryTimes = 3
 
+//Clients rank candidate proxies by ping, and retry failing over to a backup proxy while none is left.
+const (
+	ProxyPingTimeout   = time.Second * 3
+	ProxyFailoverRetry = time.Second * 10
+)
+
+//The entry of a circuit gives up on the destination if the circuit is not set up NegAnswerTimeout after the negotiation
+//is sent, plus ExpendSessionTimeout for every relay hop the exit extends.
+const NegAnswerTimeout = time.Second * 5
+
+//Negotiations signed longer than NegMaxAge ago, or that far ahead of our clock, are rejected as stale.
+const NegMaxAge = time.Minute
+
+//how often a proxy drops clients whose registration lease has lapsed
+const ProxyLeaseCheckInterval = time.Minute
+
+//Relay hops between the joint and the exit node of a circuit.
+const (
+	DefaultRelayHops = 1
+	MaxRelayHops     = 8
+)
+
 const RequestFutureDuration time.Duration = time.Second
 
 const (
 	NewSecureConnCallerTopic string = "topic:NewCaller"
 	NewSecureConnAnswerTopic string = "topic:NewAnswer"
+	NewSecureConnFailedTopic string = "topic:NewFailed"
+	CircuitDeadTopic         string = "topic:CircuitDead"
+	CircuitClosedTopic       string = "topic:CircuitClosed"
+	ProxyChangedTopic        string = "topic:ProxyChanged"
 )
 
 const BootstrapDuration = time.Hour
 const GetMainnetPeersTimeout = time.Second * 5
+const BootstrapConnectTimeout = time.Second * 10
 
 const ReqDHTPeersMaxAmount = 100
 
+//Destination records in the DHT are published under a key that rotates every DestRecordEpoch, and refreshed every
+//DestRecordRefresh. Getting the record gives up after DestGetTimeout, and delivery of a negotiation to the proxy it
+//names after DestNegTimeout. Proxies of at most DestCacheSize destinations are cached, each for at most DestCacheTTL.
+const (
+	DestRecordEpoch    = time.Hour
+	DestRecordRefresh  = time.Minute * 10
+	DestGetTimeout     = time.Second * 2
+	DestNegTimeout     = time.Second * 2
+	DestLookupTimeout  = DestGetTimeout + DestNegTimeout
+	DestPublishTimeout = time.Second * 30
+	DestCacheSize      = 1024
+	DestCacheTTL       = time.Minute * 10
+)
+
+//Negotiations gossiped on the noise topic larger than GossipMaxNegSize are rejected. Each peer may forward
+//GossipPeerRate negotiations per second in bursts of GossipPeerBurst, and ciphertexts among the last
+//GossipSeenCacheSize seen are dropped as replays.
+const (
+	GossipMaxNegSize    = 4096
+	GossipPeerRate      = 20
+	GossipPeerBurst     = 100
+	GossipSeenCacheSize = 1 << 16
+)
+
+//Persistent peerstore, peers not seen for PeerStoreMaxAge or failing PeerStoreMaxFailures times in a row are dropped.
+const (
+	PeerStoreMaxAge      = time.Hour * 24 * 7
+	PeerStoreMaxFailures = 5
+	PeerStoreMaxPeers    = 256
+	//stored peers a node connects to on startup
+	PeerStoreSeedCount = 16
+)
+
 const UnreadableTimeout = time.Minute * 5
 
+//Lifecycle of relay sessions, sessions that are not set up in time or stay idle for too long are closed.
+const (
+	SessionSetupTimeout = time.Second * 30
+	SessionIdleTimeout  = time.Minute * 10
+	SessionReapInterval = time.Second * 30
+)
+
+const (
+	CircuitConnWindow       = 1 << 20
+	CircuitConnWriteTimeout = time.Second * 10
+	//size of a circuit cell on the wire, including the secure session framing
+	CircuitCellSize = 1024
+	//data messages a mixing relay holds at most, later messages are forwarded at once
+	MixMaxQueued = 4096
+)
+
 const NetTimeUntil = "2023-12-11T15:04:05+07:00"
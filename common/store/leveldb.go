@@ -58,6 +58,12 @@ func (self *LevelDBStore) Delete(key []byte) error {
 	return self.db.Delete(key, nil)
 }
 
+//Compact rewrites the keys in [start, limit) on disk, nil start and limit cover the whole store. Deleted and
+//overwritten values stay in the files of leveldb until their range is compacted.
+func (self *LevelDBStore) Compact(start []byte, limit []byte) error {
+	return self.db.CompactRange(util.Range{Start: start, Limit: limit})
+}
+
 // QueryKeysByPrefix. find all keys by prefix
 func (self *LevelDBStore) QueryKeysByPrefix(prefix []byte) ([][]byte, error) {
 	iter := self.db.NewIterator(util.BytesPrefix(prefix), nil)
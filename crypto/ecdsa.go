@@ -44,6 +44,14 @@ func (E ECDSAPrivateKey) ECIESDecrypt(cyphertext []byte) ([]byte, error) {
 	return eciesPriv.Decrypt(cyphertext, nil, nil)
 }
 
+func (E ECDSAPrivateKey) Sign(msg []byte) ([]byte, error) {
+	p2pPriv, _, err := E.GetP2PKeypair()
+	if err != nil {
+		return nil, err
+	}
+	return p2pPriv.Sign(msg)
+}
+
 type ECDSAPublicKey struct {
 	Pub *ecdsa.PublicKey
 }
@@ -75,15 +83,30 @@ func (E ECDSAPublicKey) GetWhiteNoiseID() WhiteNoiseID {
 }
 
 func (E ECDSAPublicKey) PeerID() (peer.ID, error) {
-	p2pPKString, err := x509.MarshalPKIXPublicKey(E.Pub)
+	ecdsaPK, err := E.p2pPublicKey()
 	if err != nil {
 		return "", err
 	}
-	ecdsaPK, err := crypto.UnmarshalECDSAPublicKey(p2pPKString)
+	return peer.IDFromPublicKey(ecdsaPK)
+}
+
+func (E ECDSAPublicKey) Verify(msg []byte, sig []byte) (bool, error) {
+	ecdsaPK, err := E.p2pPublicKey()
 	if err != nil {
-		return "", err
+		return false, err
 	}
-	return peer.IDFromPublicKey(ecdsaPK)
+	return ecdsaPK.Verify(msg, sig)
+}
+
+func (E ECDSAPublicKey) p2pPublicKey() (crypto.PubKey, error) {
+	if E.Pub == nil {
+		return nil, errors.New("pub nil")
+	}
+	p2pPKString, err := x509.MarshalPKIXPublicKey(E.Pub)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalECDSAPublicKey(p2pPKString)
 }
 
 func GenerateECDSAKeyPair(r io.Reader) (PrivateKey, PublicKey, error) {
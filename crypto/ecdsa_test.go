@@ -38,3 +38,7 @@ func TestECIESECDSA(t *testing.T) {
 
 	assert.Equal(t, plaintext, message)
 }
+
+func TestSignECDSA(t *testing.T) {
+	testSign(t, ECDSA)
+}
@@ -54,6 +54,10 @@ func (e Ed25519PrivateKey) ECIESDecrypt(cyphertext []byte) ([]byte, error) {
 	return ecies.Decrypt(suite, priv, cyphertext, suite.Hash)
 }
 
+func (e Ed25519PrivateKey) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(e.priv, msg), nil
+}
+
 type Ed25519PublicKey struct {
 	pub ed25519.PublicKey
 }
@@ -86,6 +90,13 @@ func (e Ed25519PublicKey) PeerID() (peer.ID, error) {
 	return peer.IDFromPublicKey(pk)
 }
 
+func (e Ed25519PublicKey) Verify(msg []byte, sig []byte) (bool, error) {
+	if len(e.pub) != ed25519.PublicKeySize {
+		return false, errors.New("not right size")
+	}
+	return ed25519.Verify(e.pub, msg, sig), nil
+}
+
 func Ed25519PublicKeyFromP2P(key *crypto.Ed25519PublicKey) (Ed25519PublicKey, error) {
 	buf, err := key.Raw()
 	if err != nil {
@@ -25,3 +25,7 @@ func TestECIESEd25519(t *testing.T) {
 
 	assert.Equal(t, plaintext, message)
 }
+
+func TestSignEd25519(t *testing.T) {
+	testSign(t, Ed25519)
+}
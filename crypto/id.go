@@ -33,6 +33,9 @@ func (id WhiteNoiseID) String() string {
 
 func WhiteNoiseIDfromString(s string) (WhiteNoiseID, error) {
 	id := WhiteNoiseID{}
+	if len(s) == 0 {
+		return [34]byte{}, errors.New("empty WhiteNoiseID")
+	}
 	switch s[0:1] {
 	case "0":
 		pkBytes, err := base58.Decode(s[1:])
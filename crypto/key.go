@@ -26,6 +26,8 @@ type PrivateKey interface {
 	Bytes() []byte
 	GetP2PKeypair() (crypto.PrivKey, crypto.PubKey, error)
 	ECIESDecrypt(cyphertext []byte) ([]byte, error)
+	//Sign signs msg the way the libp2p key of the same type does
+	Sign(msg []byte) ([]byte, error)
 }
 
 type PublicKey interface {
@@ -33,6 +35,8 @@ type PublicKey interface {
 	ECIESEncrypt(plaintext []byte, rand io.Reader) ([]byte, error)
 	GetWhiteNoiseID() WhiteNoiseID
 	PeerID() (peer.ID, error)
+	//Verify checks a signature made by Sign of the private key, or by the libp2p key of the same type
+	Verify(msg []byte, sig []byte) (bool, error)
 }
 
 func GenerateKeyPair(keyType int, rand io.Reader) (PrivateKey, PublicKey, error) {
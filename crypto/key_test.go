@@ -123,4 +123,60 @@ func TestWhiteNoiseID(t *testing.T) {
 	}
 
 	assert.Equal(t, plaintext, message)
-}
\ No newline at end of file
+}
+
+//testSign checks signatures of keyType keys, and that they interoperate with the libp2p keys both ways.
+func testSign(t *testing.T, keyType int) {
+	priv, pub, err := GenerateKeyPair(keyType, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, _ := GenerateKeyPair(keyType, rand.Reader)
+	p2pPriv, p2pPub, err := priv.GetP2PKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello signature")
+	sig, err := priv.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2pSig, err := p2pPriv.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, sig...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		pub  PublicKey
+		msg  []byte
+		sig  []byte
+		want bool
+	}{
+		{"valid", pub, msg, sig, true},
+		{"libp2p signature", pub, msg, p2pSig, true},
+		{"decoded WhiteNoiseID", mustPublicKey(t, pub.GetWhiteNoiseID()), msg, sig, true},
+		{"other message", pub, []byte("other"), sig, false},
+		{"other key", other, msg, sig, false},
+		{"tampered", pub, msg, tampered, false},
+		{"empty", pub, msg, nil, false},
+	}
+	for _, test := range tests {
+		ok, _ := test.pub.Verify(test.msg, test.sig)
+		assert.Equal(t, test.want, ok, test.name)
+	}
+
+	ok, err := p2pPub.Verify(msg, sig)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok, "libp2p verify")
+}
+
+func mustPublicKey(t *testing.T, id WhiteNoiseID) PublicKey {
+	pk, err := id.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk
+}
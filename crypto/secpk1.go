@@ -37,6 +37,11 @@ func (s Secp256k1PublicKey) PeerID() (peer.ID, error) {
 	return peer.IDFromPublicKey(p2pPk)
 }
 
+func (s Secp256k1PublicKey) Verify(msg []byte, sig []byte) (bool, error) {
+	p2pPk := (*crypto.Secp256k1PublicKey)((*btcec.PublicKey)(s.Pub))
+	return p2pPk.Verify(msg, sig)
+}
+
 type Secp256k1PrivateKey struct {
 	Priv *ecdsa.PrivateKey
 }
@@ -60,6 +65,10 @@ func (s Secp256k1PrivateKey) ECIESDecrypt(cyphertext []byte) ([]byte, error) {
 	return key.Decrypt(cyphertext, nil, nil)
 }
 
+func (s Secp256k1PrivateKey) Sign(msg []byte) ([]byte, error) {
+	return (*crypto.Secp256k1PrivateKey)(s.Priv).Sign(msg)
+}
+
 func GenerateSecp256k1KeyPair(r io.Reader) (PrivateKey, PublicKey, error) {
 	privk, err := btcec.NewPrivateKey(btcec.S256())
 	if err != nil {
@@ -36,3 +36,7 @@ func TestECIESSecp(t *testing.T) {
 
 	assert.Equal(t, plaintext, message)
 }
+
+func TestSignSecp(t *testing.T) {
+	testSign(t, Secpk1)
+}
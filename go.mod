@@ -17,7 +17,9 @@ require (
 	github.com/libp2p/go-libp2p-kad-dht v0.11.1
 	github.com/libp2p/go-libp2p-noise v0.1.3
 	github.com/libp2p/go-libp2p-pubsub v0.4.1
+	github.com/libp2p/go-libp2p-record v0.1.3
 	github.com/libp2p/go-msgio v0.0.6
+	github.com/libp2p/go-yamux/v2 v2.0.0
 	github.com/magiconair/properties v1.8.0
 	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mr-tron/base58 v1.2.0
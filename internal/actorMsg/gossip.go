@@ -2,9 +2,23 @@ package actorMsg
 
 import "github.com/libp2p/go-libp2p-core/peer"
 
+//ReqGossipJoint hands the negotiation to the proxy of the destination, straight if the DHT names it. GossipOnly skips
+//the DHT, for a negotiation sent straight before that was never answered.
 type ReqGossipJoint struct {
-	DesHash   string
-	NegCypher []byte
+	DesHash    string
+	NegCypher  []byte
+	GossipOnly bool
+}
+
+//ResGossipJoint tells if the negotiation was acked by the proxy the DHT names, rather than gossiped.
+type ResGossipJoint struct {
+	Direct bool
+	Err    error
+}
+
+//ReqPublishDest asks to publish the destination record of a new client in the DHT.
+type ReqPublishDest struct {
+	DesHash string
 }
 
 type ReqDHTPeers struct {
@@ -14,7 +28,3 @@ type ReqDHTPeers struct {
 type ResDHTPeers struct {
 	PeerInfos []peer.AddrInfo
 }
-
-type ResError struct {
-	Err error
-}
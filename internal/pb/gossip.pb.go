@@ -30,10 +30,16 @@ type Negotiate struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Join        string `protobuf:"bytes,1,opt,name=join,proto3" json:"join,omitempty"` //join at this peerid
-	SessionId   string `protobuf:"bytes,2,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	Join        string `protobuf:"bytes,1,opt,name=join,proto3" json:"join,omitempty"`           //join at this peerid
+	SessionId   string `protobuf:"bytes,2,opt,name=sessionId,proto3" json:"sessionId,omitempty"` //set by the caller and only read by the answer
 	Destination string `protobuf:"bytes,3,opt,name=destination,proto3" json:"destination,omitempty"`
-	Sig         []byte `protobuf:"bytes,4,opt,name=sig,proto3" json:"sig,omitempty"`
+	Sig         []byte `protobuf:"bytes,4,opt,name=sig,proto3" json:"sig,omitempty"` //signature of the caller over every field but sessionId and sig, checked and dropped by the answer
+	Hops        int32  `protobuf:"varint,5,opt,name=hops,proto3" json:"hops,omitempty"`
+	Cookie      string `protobuf:"bytes,6,opt,name=cookie,proto3" json:"cookie,omitempty"` //rendezvous cookie, matches both halves of the circuit at the joint
+	MixHops     int32  `protobuf:"varint,7,opt,name=mixHops,proto3" json:"mixHops,omitempty"`
+	Timestamp   int64  `protobuf:"varint,8,opt,name=timestamp,proto3" json:"timestamp,omitempty"` //unix nanoseconds, set by the caller
+	Nonce       []byte `protobuf:"bytes,9,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Caller      string `protobuf:"bytes,10,opt,name=caller,proto3" json:"caller,omitempty"` //WhiteNoiseID of the caller, whose account key made sig, dropped by the answer like sig
 }
 
 func (x *Negotiate) Reset() {
@@ -96,6 +102,48 @@ func (x *Negotiate) GetSig() []byte {
 	return nil
 }
 
+func (x *Negotiate) GetHops() int32 {
+	if x != nil {
+		return x.Hops
+	}
+	return 0
+}
+
+func (x *Negotiate) GetCookie() string {
+	if x != nil {
+		return x.Cookie
+	}
+	return ""
+}
+
+func (x *Negotiate) GetMixHops() int32 {
+	if x != nil {
+		return x.MixHops
+	}
+	return 0
+}
+
+func (x *Negotiate) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Negotiate) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *Negotiate) GetCaller() string {
+	if x != nil {
+		return x.Caller
+	}
+	return ""
+}
+
 type EncryptedNeg struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -151,22 +199,220 @@ func (x *EncryptedNeg) GetCypher() []byte {
 	return nil
 }
 
+// destRecord names the proxy a destination is registered at until expire, it is stored in the DHT sealed in a
+// sealedDestRecord. The delegation of the destination proves the proxy is the one it registered at.
+type DestRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Proxy        string   `protobuf:"bytes,1,opt,name=proxy,proto3" json:"proxy,omitempty"`
+	Addrs        []string `protobuf:"bytes,2,rep,name=addrs,proto3" json:"addrs,omitempty"`
+	Expire       int64    `protobuf:"varint,3,opt,name=expire,proto3" json:"expire,omitempty"`            //unix seconds
+	PubKey       []byte   `protobuf:"bytes,4,opt,name=pubKey,proto3" json:"pubKey,omitempty"`             //public key of the proxy
+	Sig          []byte   `protobuf:"bytes,5,opt,name=sig,proto3" json:"sig,omitempty"`                   //signature of the key and the other fields by the proxy
+	WhiteNoiseID string   `protobuf:"bytes,6,opt,name=whiteNoiseID,proto3" json:"whiteNoiseID,omitempty"` //of the destination, its hash is the destination
+	Delegation   []byte   `protobuf:"bytes,7,opt,name=delegation,proto3" json:"delegation,omitempty"`     //signature of the destination, the proxy and the epoch by the key of whiteNoiseID
+}
+
+func (x *DestRecord) Reset() {
+	*x = DestRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_gossip_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DestRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestRecord) ProtoMessage() {}
+
+func (x *DestRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_gossip_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestRecord.ProtoReflect.Descriptor instead.
+func (*DestRecord) Descriptor() ([]byte, []int) {
+	return file_gossip_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DestRecord) GetProxy() string {
+	if x != nil {
+		return x.Proxy
+	}
+	return ""
+}
+
+func (x *DestRecord) GetAddrs() []string {
+	if x != nil {
+		return x.Addrs
+	}
+	return nil
+}
+
+func (x *DestRecord) GetExpire() int64 {
+	if x != nil {
+		return x.Expire
+	}
+	return 0
+}
+
+func (x *DestRecord) GetPubKey() []byte {
+	if x != nil {
+		return x.PubKey
+	}
+	return nil
+}
+
+func (x *DestRecord) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
+func (x *DestRecord) GetWhiteNoiseID() string {
+	if x != nil {
+		return x.WhiteNoiseID
+	}
+	return ""
+}
+
+func (x *DestRecord) GetDelegation() []byte {
+	if x != nil {
+		return x.Delegation
+	}
+	return nil
+}
+
+// sealedDestRecord is stored in the DHT under the blinded key of a destination. Only who knows the destination can open
+// it, DHT nodes check it is signed by the blinded key and has not expired.
+type SealedDestRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Expire int64  `protobuf:"varint,1,opt,name=expire,proto3" json:"expire,omitempty"` //unix seconds, the expire of the destRecord
+	PubKey []byte `protobuf:"bytes,2,opt,name=pubKey,proto3" json:"pubKey,omitempty"`  //blinded public key, the DHT key is its hash
+	Cypher []byte `protobuf:"bytes,3,opt,name=cypher,proto3" json:"cypher,omitempty"`  //nonce and destRecord sealed with the key derived from the destination and the epoch
+	Sig    []byte `protobuf:"bytes,4,opt,name=sig,proto3" json:"sig,omitempty"`        //signature of the key and the fields above by the blinded key
+}
+
+func (x *SealedDestRecord) Reset() {
+	*x = SealedDestRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_gossip_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SealedDestRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SealedDestRecord) ProtoMessage() {}
+
+func (x *SealedDestRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_gossip_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SealedDestRecord.ProtoReflect.Descriptor instead.
+func (*SealedDestRecord) Descriptor() ([]byte, []int) {
+	return file_gossip_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SealedDestRecord) GetExpire() int64 {
+	if x != nil {
+		return x.Expire
+	}
+	return 0
+}
+
+func (x *SealedDestRecord) GetPubKey() []byte {
+	if x != nil {
+		return x.PubKey
+	}
+	return nil
+}
+
+func (x *SealedDestRecord) GetCypher() []byte {
+	if x != nil {
+		return x.Cypher
+	}
+	return nil
+}
+
+func (x *SealedDestRecord) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
 var File_gossip_proto protoreflect.FileDescriptor
 
 var file_gossip_proto_rawDesc = []byte{
 	0x0a, 0x0c, 0x67, 0x6f, 0x73, 0x73, 0x69, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x02,
-	0x70, 0x62, 0x22, 0x71, 0x0a, 0x09, 0x6e, 0x65, 0x67, 0x6f, 0x74, 0x69, 0x61, 0x74, 0x65, 0x12,
-	0x12, 0x0a, 0x04, 0x6a, 0x6f, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6a,
-	0x6f, 0x69, 0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
-	0x64, 0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74,
-	0x69, 0x6f, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x03, 0x73, 0x69, 0x67, 0x22, 0x38, 0x0a, 0x0c, 0x45, 0x6e, 0x63, 0x72, 0x79, 0x70, 0x74,
-	0x65, 0x64, 0x4e, 0x65, 0x67, 0x12, 0x10, 0x0a, 0x03, 0x64, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x03, 0x64, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65,
-	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65, 0x72, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x70, 0x62, 0x22, 0x83, 0x02, 0x0a, 0x09, 0x6e, 0x65, 0x67, 0x6f, 0x74, 0x69, 0x61, 0x74, 0x65,
+	0x12, 0x12, 0x0a, 0x04, 0x6a, 0x6f, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x6a, 0x6f, 0x69, 0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x49, 0x64, 0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x03, 0x73, 0x69, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x6f, 0x70, 0x73, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x68, 0x6f, 0x70, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f,
+	0x6f, 0x6b, 0x69, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6f, 0x6f, 0x6b,
+	0x69, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x69, 0x78, 0x48, 0x6f, 0x70, 0x73, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x07, 0x6d, 0x69, 0x78, 0x48, 0x6f, 0x70, 0x73, 0x12, 0x1c, 0x0a, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f,
+	0x6e, 0x63, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65,
+	0x12, 0x16, 0x0a, 0x06, 0x63, 0x61, 0x6c, 0x6c, 0x65, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x63, 0x61, 0x6c, 0x6c, 0x65, 0x72, 0x22, 0x38, 0x0a, 0x0c, 0x45, 0x6e, 0x63, 0x72,
+	0x79, 0x70, 0x74, 0x65, 0x64, 0x4e, 0x65, 0x67, 0x12, 0x10, 0x0a, 0x03, 0x64, 0x65, 0x73, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x64, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x79,
+	0x70, 0x68, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x63, 0x79, 0x70, 0x68,
+	0x65, 0x72, 0x22, 0xbe, 0x01, 0x0a, 0x0a, 0x64, 0x65, 0x73, 0x74, 0x52, 0x65, 0x63, 0x6f, 0x72,
+	0x64, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73,
+	0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x12, 0x16, 0x0a,
+	0x06, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x65,
+	0x78, 0x70, 0x69, 0x72, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x75, 0x62, 0x4b, 0x65, 0x79, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x75, 0x62, 0x4b, 0x65, 0x79, 0x12, 0x10, 0x0a,
+	0x03, 0x73, 0x69, 0x67, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x69, 0x67, 0x12,
+	0x22, 0x0a, 0x0c, 0x77, 0x68, 0x69, 0x74, 0x65, 0x4e, 0x6f, 0x69, 0x73, 0x65, 0x49, 0x44, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x77, 0x68, 0x69, 0x74, 0x65, 0x4e, 0x6f, 0x69, 0x73,
+	0x65, 0x49, 0x44, 0x12, 0x1e, 0x0a, 0x0a, 0x64, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x64, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x22, 0x6c, 0x0a, 0x10, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x44, 0x65, 0x73,
+	0x74, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x65, 0x78, 0x70, 0x69, 0x72,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x12,
+	0x16, 0x0a, 0x06, 0x70, 0x75, 0x62, 0x4b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x06, 0x70, 0x75, 0x62, 0x4b, 0x65, 0x79, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65,
+	0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65, 0x72, 0x12,
+	0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x69,
+	0x67, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -181,10 +427,12 @@ func file_gossip_proto_rawDescGZIP() []byte {
 	return file_gossip_proto_rawDescData
 }
 
-var file_gossip_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_gossip_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_gossip_proto_goTypes = []interface{}{
-	(*Negotiate)(nil),    // 0: pb.negotiate
-	(*EncryptedNeg)(nil), // 1: pb.EncryptedNeg
+	(*Negotiate)(nil),        // 0: pb.negotiate
+	(*EncryptedNeg)(nil),     // 1: pb.EncryptedNeg
+	(*DestRecord)(nil),       // 2: pb.destRecord
+	(*SealedDestRecord)(nil), // 3: pb.sealedDestRecord
 }
 var file_gossip_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for method output_type
@@ -224,6 +472,30 @@ func file_gossip_proto_init() {
 				return nil
 			}
 		}
+		file_gossip_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DestRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_gossip_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SealedDestRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -231,7 +503,7 @@ func file_gossip_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_gossip_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
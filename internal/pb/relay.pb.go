@@ -35,6 +35,9 @@ const (
 	Relaytype_Wake         Relaytype = 4
 	Relaytype_Probe        Relaytype = 5
 	Relaytype_Success      Relaytype = 6
+	Relaytype_Credit       Relaytype = 7
+	Relaytype_Ping         Relaytype = 8
+	Relaytype_Pong         Relaytype = 9
 )
 
 // Enum value maps for Relaytype.
@@ -47,6 +50,9 @@ var (
 		4: "Wake",
 		5: "Probe",
 		6: "Success",
+		7: "Credit",
+		8: "Ping",
+		9: "Pong",
 	}
 	Relaytype_value = map[string]int32{
 		"SetSessionId": 0,
@@ -56,6 +62,9 @@ var (
 		"Wake":         4,
 		"Probe":        5,
 		"Success":      6,
+		"Credit":       7,
+		"Ping":         8,
+		"Pong":         9,
 	}
 )
 
@@ -149,13 +158,16 @@ func (x *Relay) GetData() []byte {
 	return nil
 }
 
+// circuitId is only known to the two ends of one hop, each hop of a circuit has its own.
 type SetSessionIdMsg struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionId string `protobuf:"bytes,1,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
-	Role      int32  `protobuf:"varint,2,opt,name=role,proto3" json:"role,omitempty"`
+	CircuitId    string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
+	Role         int32  `protobuf:"varint,2,opt,name=role,proto3" json:"role,omitempty"`
+	Cookie       string `protobuf:"bytes,3,opt,name=cookie,proto3" json:"cookie,omitempty"`             //rendezvous cookie, only sent to the joint by the entry and to the answer by the exit
+	SealedCookie []byte `protobuf:"bytes,4,opt,name=sealedCookie,proto3" json:"sealedCookie,omitempty"` //rendezvous cookie sealed to the joint, sent by the last relay of the exit half
 }
 
 func (x *SetSessionIdMsg) Reset() {
@@ -190,9 +202,9 @@ func (*SetSessionIdMsg) Descriptor() ([]byte, []int) {
 	return file_relay_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *SetSessionIdMsg) GetSessionId() string {
+func (x *SetSessionIdMsg) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
@@ -204,12 +216,26 @@ func (x *SetSessionIdMsg) GetRole() int32 {
 	return 0
 }
 
+func (x *SetSessionIdMsg) GetCookie() string {
+	if x != nil {
+		return x.Cookie
+	}
+	return ""
+}
+
+func (x *SetSessionIdMsg) GetSealedCookie() []byte {
+	if x != nil {
+		return x.SealedCookie
+	}
+	return nil
+}
+
 type RelayMsg struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionId string `protobuf:"bytes,1,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
 	Data      []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
 }
 
@@ -245,9 +271,9 @@ func (*RelayMsg) Descriptor() ([]byte, []int) {
 	return file_relay_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *RelayMsg) GetSessionId() string {
+func (x *RelayMsg) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
@@ -319,7 +345,7 @@ type ProbeSignal struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionId string `protobuf:"bytes,1,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
 	Data      []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
 }
 
@@ -355,9 +381,9 @@ func (*ProbeSignal) Descriptor() ([]byte, []int) {
 	return file_relay_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *ProbeSignal) GetSessionId() string {
+func (x *ProbeSignal) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
@@ -374,7 +400,7 @@ type Disconnect struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionId string `protobuf:"bytes,1,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
 	ErrCode   int32  `protobuf:"varint,2,opt,name=errCode,proto3" json:"errCode,omitempty"`
 }
 
@@ -410,9 +436,9 @@ func (*Disconnect) Descriptor() ([]byte, []int) {
 	return file_relay_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *Disconnect) GetSessionId() string {
+func (x *Disconnect) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
@@ -429,7 +455,7 @@ type CircuitSuccess struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionId string `protobuf:"bytes,1,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
 }
 
 func (x *CircuitSuccess) Reset() {
@@ -464,13 +490,125 @@ func (*CircuitSuccess) Descriptor() ([]byte, []int) {
 	return file_relay_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *CircuitSuccess) GetSessionId() string {
+func (x *CircuitSuccess) GetCircuitId() string {
+	if x != nil {
+		return x.CircuitId
+	}
+	return ""
+}
+
+type CreditMsg struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
+	Size      uint32 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+}
+
+func (x *CreditMsg) Reset() {
+	*x = CreditMsg{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_relay_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CreditMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreditMsg) ProtoMessage() {}
+
+func (x *CreditMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_relay_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreditMsg.ProtoReflect.Descriptor instead.
+func (*CreditMsg) Descriptor() ([]byte, []int) {
+	return file_relay_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CreditMsg) GetCircuitId() string {
+	if x != nil {
+		return x.CircuitId
+	}
+	return ""
+}
+
+func (x *CreditMsg) GetSize() uint32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+// pingMsg is a keepalive sent end to end, the other end of the circuit answers a Ping with a Pong of the same nonce.
+// Relays give the nonce a new random value on every hop and restore it on the Pong.
+type PingMsg struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
+	Nonce     uint64 `protobuf:"varint,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
+}
+
+func (x *PingMsg) Reset() {
+	*x = PingMsg{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_relay_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PingMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingMsg) ProtoMessage() {}
+
+func (x *PingMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_relay_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingMsg.ProtoReflect.Descriptor instead.
+func (*PingMsg) Descriptor() ([]byte, []int) {
+	return file_relay_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *PingMsg) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
 
+func (x *PingMsg) GetNonce() uint64 {
+	if x != nil {
+		return x.Nonce
+	}
+	return 0
+}
+
 var File_relay_proto protoreflect.FileDescriptor
 
 var file_relay_proto_rawDesc = []byte{
@@ -480,36 +618,49 @@ var file_relay_proto_rawDesc = []byte{
 	0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x72, 0x65,
 	0x6c, 0x61, 0x79, 0x74, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a,
 	0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74,
-	0x61, 0x22, 0x43, 0x0a, 0x0f, 0x73, 0x65, 0x74, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
-	0x64, 0x4d, 0x73, 0x67, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
-	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x61, 0x22, 0x7f, 0x0a, 0x0f, 0x73, 0x65, 0x74, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x4d, 0x73, 0x67, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74,
 	0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05,
-	0x52, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x22, 0x3c, 0x0a, 0x08, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x4d,
-	0x73, 0x67, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64,
-	0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04,
-	0x64, 0x61, 0x74, 0x61, 0x22, 0x2a, 0x0a, 0x06, 0x61, 0x63, 0x6b, 0x4d, 0x73, 0x67, 0x12, 0x0e,
-	0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x10,
-	0x0a, 0x03, 0x72, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x72, 0x65, 0x73,
-	0x22, 0x3f, 0x0a, 0x0b, 0x70, 0x72, 0x6f, 0x62, 0x65, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x12,
-	0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x12, 0x0a,
-	0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74,
-	0x61, 0x22, 0x44, 0x0a, 0x0a, 0x64, 0x69, 0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12,
-	0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x18, 0x0a,
-	0x07, 0x65, 0x72, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07,
-	0x65, 0x72, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x22, 0x2e, 0x0a, 0x0e, 0x63, 0x69, 0x72, 0x63, 0x75,
-	0x69, 0x74, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73,
-	0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65,
-	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x2a, 0x62, 0x0a, 0x09, 0x72, 0x65, 0x6c, 0x61, 0x79,
-	0x74, 0x79, 0x70, 0x65, 0x12, 0x10, 0x0a, 0x0c, 0x53, 0x65, 0x74, 0x53, 0x65, 0x73, 0x73, 0x69,
-	0x6f, 0x6e, 0x49, 0x64, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x41, 0x63, 0x6b, 0x10, 0x01, 0x12,
-	0x08, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x10, 0x02, 0x12, 0x0e, 0x0a, 0x0a, 0x44, 0x69, 0x73,
-	0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x57, 0x61, 0x6b,
-	0x65, 0x10, 0x04, 0x12, 0x09, 0x0a, 0x05, 0x50, 0x72, 0x6f, 0x62, 0x65, 0x10, 0x05, 0x12, 0x0b,
-	0x0a, 0x07, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x10, 0x06, 0x62, 0x06, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x33,
+	0x52, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x12, 0x22,
+	0x0a, 0x0c, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x43, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x43, 0x6f, 0x6f, 0x6b,
+	0x69, 0x65, 0x22, 0x3c, 0x0a, 0x08, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x4d, 0x73, 0x67, 0x12, 0x1c,
+	0x0a, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04,
+	0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61,
+	0x22, 0x2a, 0x0a, 0x06, 0x61, 0x63, 0x6b, 0x4d, 0x73, 0x67, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x72, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x72, 0x65, 0x73, 0x22, 0x3f, 0x0a, 0x0b,
+	0x70, 0x72, 0x6f, 0x62, 0x65, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x63,
+	0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74,
+	0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x44, 0x0a,
+	0x0a, 0x64, 0x69, 0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x63,
+	0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x72, 0x72,
+	0x43, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x65, 0x72, 0x72, 0x43,
+	0x6f, 0x64, 0x65, 0x22, 0x2e, 0x0a, 0x0e, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x53, 0x75,
+	0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74,
+	0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69,
+	0x74, 0x49, 0x64, 0x22, 0x3d, 0x0a, 0x09, 0x63, 0x72, 0x65, 0x64, 0x69, 0x74, 0x4d, 0x73, 0x67,
+	0x12, 0x1c, 0x0a, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x12, 0x12,
+	0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x73, 0x69,
+	0x7a, 0x65, 0x22, 0x3d, 0x0a, 0x07, 0x70, 0x69, 0x6e, 0x67, 0x4d, 0x73, 0x67, 0x12, 0x1c, 0x0a,
+	0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x6e,
+	0x6f, 0x6e, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63,
+	0x65, 0x2a, 0x82, 0x01, 0x0a, 0x09, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x74, 0x79, 0x70, 0x65, 0x12,
+	0x10, 0x0a, 0x0c, 0x53, 0x65, 0x74, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x10,
+	0x00, 0x12, 0x07, 0x0a, 0x03, 0x41, 0x63, 0x6b, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x44, 0x61,
+	0x74, 0x61, 0x10, 0x02, 0x12, 0x0e, 0x0a, 0x0a, 0x44, 0x69, 0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65,
+	0x63, 0x74, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x57, 0x61, 0x6b, 0x65, 0x10, 0x04, 0x12, 0x09,
+	0x0a, 0x05, 0x50, 0x72, 0x6f, 0x62, 0x65, 0x10, 0x05, 0x12, 0x0b, 0x0a, 0x07, 0x53, 0x75, 0x63,
+	0x63, 0x65, 0x73, 0x73, 0x10, 0x06, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x72, 0x65, 0x64, 0x69, 0x74,
+	0x10, 0x07, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x69, 0x6e, 0x67, 0x10, 0x08, 0x12, 0x08, 0x0a, 0x04,
+	0x50, 0x6f, 0x6e, 0x67, 0x10, 0x09, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -525,7 +676,7 @@ func file_relay_proto_rawDescGZIP() []byte {
 }
 
 var file_relay_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_relay_proto_goTypes = []interface{}{
 	(Relaytype)(0),          // 0: pb.relaytype
 	(*Relay)(nil),           // 1: pb.Relay
@@ -535,6 +686,8 @@ var file_relay_proto_goTypes = []interface{}{
 	(*ProbeSignal)(nil),     // 5: pb.probeSignal
 	(*Disconnect)(nil),      // 6: pb.disconnect
 	(*CircuitSuccess)(nil),  // 7: pb.circuitSuccess
+	(*CreditMsg)(nil),       // 8: pb.creditMsg
+	(*PingMsg)(nil),         // 9: pb.pingMsg
 }
 var file_relay_proto_depIdxs = []int32{
 	0, // 0: pb.Relay.type:type_name -> pb.relaytype
@@ -635,6 +788,30 @@ func file_relay_proto_init() {
 				return nil
 			}
 		}
+		file_relay_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CreditMsg); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_relay_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PingMsg); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -642,7 +819,7 @@ func file_relay_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_relay_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   7,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
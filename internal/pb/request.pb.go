@@ -35,6 +35,8 @@ const (
 	Reqtype_NegPlainText   Reqtype = 4
 	Reqtype_UnRegisterType Reqtype = 5
 	Reqtype_MainNetPeers   Reqtype = 6
+	Reqtype_RenewProxy     Reqtype = 7
+	Reqtype_ProxyChallenge Reqtype = 8
 )
 
 // Enum value maps for Reqtype.
@@ -47,6 +49,8 @@ var (
 		4: "NegPlainText",
 		5: "UnRegisterType",
 		6: "MainNetPeers",
+		7: "RenewProxy",
+		8: "ProxyChallenge",
 	}
 	Reqtype_value = map[string]int32{
 		"GetOnlineNodes": 0,
@@ -56,6 +60,8 @@ var (
 		"NegPlainText":   4,
 		"UnRegisterType": 5,
 		"MainNetPeers":   6,
+		"RenewProxy":     7,
+		"ProxyChallenge": 8,
 	}
 )
 
@@ -164,7 +170,9 @@ type NewCircuit struct {
 
 	From      string `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
 	To        string `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
-	SessionId string `protobuf:"bytes,3,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	CircuitId string `protobuf:"bytes,3,opt,name=circuitId,proto3" json:"circuitId,omitempty"` //circuit id of the hop between caller and proxy
+	Hops      int32  `protobuf:"varint,4,opt,name=hops,proto3" json:"hops,omitempty"`          //relay hops between joint and exit
+	MixHops   int32  `protobuf:"varint,5,opt,name=mixHops,proto3" json:"mixHops,omitempty"`    //how many of the relay hops must be mixing nodes
 }
 
 func (x *NewCircuit) Reset() {
@@ -213,20 +221,36 @@ func (x *NewCircuit) GetTo() string {
 	return ""
 }
 
-func (x *NewCircuit) GetSessionId() string {
+func (x *NewCircuit) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
 
+func (x *NewCircuit) GetHops() int32 {
+	if x != nil {
+		return x.Hops
+	}
+	return 0
+}
+
+func (x *NewCircuit) GetMixHops() int32 {
+	if x != nil {
+		return x.MixHops
+	}
+	return 0
+}
+
 type NewProxy struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Time         string `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
-	WhiteNoiseID string `protobuf:"bytes,2,opt,name=whiteNoiseID,proto3" json:"whiteNoiseID,omitempty"`
+	Time         string            `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"` //lease the client asks for
+	WhiteNoiseID string            `protobuf:"bytes,2,opt,name=whiteNoiseID,proto3" json:"whiteNoiseID,omitempty"`
+	Sig          []byte            `protobuf:"bytes,3,opt,name=sig,proto3" json:"sig,omitempty"` //signature of the proxy challenge by the key of whiteNoiseID
+	Delegations  []*DestDelegation `protobuf:"bytes,4,rep,name=delegations,proto3" json:"delegations,omitempty"`
 }
 
 func (x *NewProxy) Reset() {
@@ -275,6 +299,227 @@ func (x *NewProxy) GetWhiteNoiseID() string {
 	return ""
 }
 
+func (x *NewProxy) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
+func (x *NewProxy) GetDelegations() []*DestDelegation {
+	if x != nil {
+		return x.Delegations
+	}
+	return nil
+}
+
+// destDelegation lets the proxy publish the destination record of the client for one epoch
+type DestDelegation struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Epoch int64  `protobuf:"varint,1,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Sig   []byte `protobuf:"bytes,2,opt,name=sig,proto3" json:"sig,omitempty"` //signature of the destination, the proxy and the epoch by the key of the client
+}
+
+func (x *DestDelegation) Reset() {
+	*x = DestDelegation{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_request_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DestDelegation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestDelegation) ProtoMessage() {}
+
+func (x *DestDelegation) ProtoReflect() protoreflect.Message {
+	mi := &file_request_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestDelegation.ProtoReflect.Descriptor instead.
+func (*DestDelegation) Descriptor() ([]byte, []int) {
+	return file_request_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DestDelegation) GetEpoch() int64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *DestDelegation) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
+// proxyChallenge is sent empty by a client before newProxy, the proxy answers with a nonce to sign
+type ProxyChallenge struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Nonce []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+}
+
+func (x *ProxyChallenge) Reset() {
+	*x = ProxyChallenge{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_request_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ProxyChallenge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProxyChallenge) ProtoMessage() {}
+
+func (x *ProxyChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_request_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProxyChallenge.ProtoReflect.Descriptor instead.
+func (*ProxyChallenge) Descriptor() ([]byte, []int) {
+	return file_request_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ProxyChallenge) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+type RenewProxy struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time        string            `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`               //lease the client asks for, counted from now
+	Delegations []*DestDelegation `protobuf:"bytes,2,rep,name=delegations,proto3" json:"delegations,omitempty"` //for the epochs the renewed lease reaches into
+}
+
+func (x *RenewProxy) Reset() {
+	*x = RenewProxy{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_request_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RenewProxy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewProxy) ProtoMessage() {}
+
+func (x *RenewProxy) ProtoReflect() protoreflect.Message {
+	mi := &file_request_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewProxy.ProtoReflect.Descriptor instead.
+func (*RenewProxy) Descriptor() ([]byte, []int) {
+	return file_request_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RenewProxy) GetTime() string {
+	if x != nil {
+		return x.Time
+	}
+	return ""
+}
+
+func (x *RenewProxy) GetDelegations() []*DestDelegation {
+	if x != nil {
+		return x.Delegations
+	}
+	return nil
+}
+
+// lease is the answer of the proxy to newProxy and renewProxy
+type Lease struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time string `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"` //how long the registration lasts from now
+}
+
+func (x *Lease) Reset() {
+	*x = Lease{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_request_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Lease) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Lease) ProtoMessage() {}
+
+func (x *Lease) ProtoReflect() protoreflect.Message {
+	mi := &file_request_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Lease.ProtoReflect.Descriptor instead.
+func (*Lease) Descriptor() ([]byte, []int) {
+	return file_request_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Lease) GetTime() string {
+	if x != nil {
+		return x.Time
+	}
+	return ""
+}
+
 type Decrypt struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -287,7 +532,7 @@ type Decrypt struct {
 func (x *Decrypt) Reset() {
 	*x = Decrypt{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_request_proto_msgTypes[3]
+		mi := &file_request_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -300,7 +545,7 @@ func (x *Decrypt) String() string {
 func (*Decrypt) ProtoMessage() {}
 
 func (x *Decrypt) ProtoReflect() protoreflect.Message {
-	mi := &file_request_proto_msgTypes[3]
+	mi := &file_request_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -313,7 +558,7 @@ func (x *Decrypt) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Decrypt.ProtoReflect.Descriptor instead.
 func (*Decrypt) Descriptor() ([]byte, []int) {
-	return file_request_proto_rawDescGZIP(), []int{3}
+	return file_request_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Decrypt) GetDestination() string {
@@ -335,13 +580,13 @@ type UnRegister struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionID []string `protobuf:"bytes,1,rep,name=sessionID,proto3" json:"sessionID,omitempty"`
+	CircuitId []string `protobuf:"bytes,1,rep,name=circuitId,proto3" json:"circuitId,omitempty"`
 }
 
 func (x *UnRegister) Reset() {
 	*x = UnRegister{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_request_proto_msgTypes[4]
+		mi := &file_request_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -354,7 +599,7 @@ func (x *UnRegister) String() string {
 func (*UnRegister) ProtoMessage() {}
 
 func (x *UnRegister) ProtoReflect() protoreflect.Message {
-	mi := &file_request_proto_msgTypes[4]
+	mi := &file_request_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -367,12 +612,12 @@ func (x *UnRegister) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UnRegister.ProtoReflect.Descriptor instead.
 func (*UnRegister) Descriptor() ([]byte, []int) {
-	return file_request_proto_rawDescGZIP(), []int{4}
+	return file_request_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *UnRegister) GetSessionID() []string {
+func (x *UnRegister) GetCircuitId() []string {
 	if x != nil {
-		return x.SessionID
+		return x.CircuitId
 	}
 	return nil
 }
@@ -382,14 +627,14 @@ type NegPlaintext struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionId string `protobuf:"bytes,1,opt,name=sessionId,proto3" json:"sessionId,omitempty"`
+	CircuitId string `protobuf:"bytes,1,opt,name=circuitId,proto3" json:"circuitId,omitempty"`
 	Neg       []byte `protobuf:"bytes,2,opt,name=neg,proto3" json:"neg,omitempty"`
 }
 
 func (x *NegPlaintext) Reset() {
 	*x = NegPlaintext{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_request_proto_msgTypes[5]
+		mi := &file_request_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -402,7 +647,7 @@ func (x *NegPlaintext) String() string {
 func (*NegPlaintext) ProtoMessage() {}
 
 func (x *NegPlaintext) ProtoReflect() protoreflect.Message {
-	mi := &file_request_proto_msgTypes[5]
+	mi := &file_request_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -415,12 +660,12 @@ func (x *NegPlaintext) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NegPlaintext.ProtoReflect.Descriptor instead.
 func (*NegPlaintext) Descriptor() ([]byte, []int) {
-	return file_request_proto_rawDescGZIP(), []int{5}
+	return file_request_proto_rawDescGZIP(), []int{9}
 }
 
-func (x *NegPlaintext) GetSessionId() string {
+func (x *NegPlaintext) GetCircuitId() string {
 	if x != nil {
-		return x.SessionId
+		return x.CircuitId
 	}
 	return ""
 }
@@ -443,7 +688,7 @@ type MainNetPeers struct {
 func (x *MainNetPeers) Reset() {
 	*x = MainNetPeers{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_request_proto_msgTypes[6]
+		mi := &file_request_proto_msgTypes[10]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -456,7 +701,7 @@ func (x *MainNetPeers) String() string {
 func (*MainNetPeers) ProtoMessage() {}
 
 func (x *MainNetPeers) ProtoReflect() protoreflect.Message {
-	mi := &file_request_proto_msgTypes[6]
+	mi := &file_request_proto_msgTypes[10]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -469,7 +714,7 @@ func (x *MainNetPeers) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MainNetPeers.ProtoReflect.Descriptor instead.
 func (*MainNetPeers) Descriptor() ([]byte, []int) {
-	return file_request_proto_rawDescGZIP(), []int{6}
+	return file_request_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *MainNetPeers) GetMax() int32 {
@@ -490,7 +735,7 @@ type PeersList struct {
 func (x *PeersList) Reset() {
 	*x = PeersList{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_request_proto_msgTypes[7]
+		mi := &file_request_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -503,7 +748,7 @@ func (x *PeersList) String() string {
 func (*PeersList) ProtoMessage() {}
 
 func (x *PeersList) ProtoReflect() protoreflect.Message {
-	mi := &file_request_proto_msgTypes[7]
+	mi := &file_request_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -516,7 +761,7 @@ func (x *PeersList) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PeersList.ProtoReflect.Descriptor instead.
 func (*PeersList) Descriptor() ([]byte, []int) {
-	return file_request_proto_rawDescGZIP(), []int{7}
+	return file_request_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *PeersList) GetPeers() []*NodeInfo {
@@ -538,7 +783,7 @@ type NodeInfo struct {
 func (x *NodeInfo) Reset() {
 	*x = NodeInfo{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_request_proto_msgTypes[8]
+		mi := &file_request_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -551,7 +796,7 @@ func (x *NodeInfo) String() string {
 func (*NodeInfo) ProtoMessage() {}
 
 func (x *NodeInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_request_proto_msgTypes[8]
+	mi := &file_request_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -564,7 +809,7 @@ func (x *NodeInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NodeInfo.ProtoReflect.Descriptor instead.
 func (*NodeInfo) Descriptor() ([]byte, []int) {
-	return file_request_proto_rawDescGZIP(), []int{8}
+	return file_request_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *NodeInfo) GetId() string {
@@ -592,44 +837,67 @@ var file_request_proto_rawDesc = []byte{
 	0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x72,
 	0x65, 0x71, 0x74, 0x79, 0x70, 0x65, 0x52, 0x07, 0x72, 0x65, 0x71, 0x74, 0x79, 0x70, 0x65, 0x12,
 	0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64,
-	0x61, 0x74, 0x61, 0x22, 0x4e, 0x0a, 0x0a, 0x6e, 0x65, 0x77, 0x43, 0x69, 0x72, 0x63, 0x75, 0x69,
+	0x61, 0x74, 0x61, 0x22, 0x7c, 0x0a, 0x0a, 0x6e, 0x65, 0x77, 0x43, 0x69, 0x72, 0x63, 0x75, 0x69,
 	0x74, 0x12, 0x12, 0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
 	0x04, 0x66, 0x72, 0x6f, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x02, 0x74, 0x6f, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
-	0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f,
-	0x6e, 0x49, 0x64, 0x22, 0x42, 0x0a, 0x08, 0x6e, 0x65, 0x77, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x12,
-	0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74,
-	0x69, 0x6d, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x77, 0x68, 0x69, 0x74, 0x65, 0x4e, 0x6f, 0x69, 0x73,
-	0x65, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x77, 0x68, 0x69, 0x74, 0x65,
-	0x4e, 0x6f, 0x69, 0x73, 0x65, 0x49, 0x44, 0x22, 0x43, 0x0a, 0x07, 0x64, 0x65, 0x63, 0x72, 0x79,
-	0x70, 0x74, 0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f,
-	0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65, 0x72, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65, 0x72, 0x22, 0x2a, 0x0a, 0x0a,
-	0x75, 0x6e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65,
-	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x73,
-	0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x22, 0x3e, 0x0a, 0x0c, 0x6e, 0x65, 0x67, 0x50,
-	0x6c, 0x61, 0x69, 0x6e, 0x74, 0x65, 0x78, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x73, 0x73,
-	0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73,
-	0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6e, 0x65, 0x67, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x0c, 0x52, 0x03, 0x6e, 0x65, 0x67, 0x22, 0x20, 0x0a, 0x0c, 0x6d, 0x61, 0x69, 0x6e,
-	0x4e, 0x65, 0x74, 0x50, 0x65, 0x65, 0x72, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x6d, 0x61, 0x78, 0x22, 0x2f, 0x0a, 0x09, 0x70, 0x65,
-	0x65, 0x72, 0x73, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x22, 0x0a, 0x05, 0x70, 0x65, 0x65, 0x72, 0x73,
-	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x62, 0x2e, 0x6e, 0x6f, 0x64, 0x65,
-	0x49, 0x6e, 0x66, 0x6f, 0x52, 0x05, 0x70, 0x65, 0x65, 0x72, 0x73, 0x22, 0x2e, 0x0a, 0x08, 0x6e,
-	0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x72, 0x18,
-	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x2a, 0x86, 0x01, 0x0a, 0x07,
-	0x72, 0x65, 0x71, 0x74, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x4f, 0x6e,
-	0x6c, 0x69, 0x6e, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x73, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x4e,
-	0x65, 0x77, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4e, 0x65, 0x77,
-	0x43, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x44, 0x65, 0x63,
-	0x72, 0x79, 0x70, 0x74, 0x47, 0x6f, 0x73, 0x73, 0x69, 0x70, 0x10, 0x03, 0x12, 0x10, 0x0a, 0x0c,
-	0x4e, 0x65, 0x67, 0x50, 0x6c, 0x61, 0x69, 0x6e, 0x54, 0x65, 0x78, 0x74, 0x10, 0x04, 0x12, 0x12,
-	0x0a, 0x0e, 0x55, 0x6e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x54, 0x79, 0x70, 0x65,
-	0x10, 0x05, 0x12, 0x10, 0x0a, 0x0c, 0x4d, 0x61, 0x69, 0x6e, 0x4e, 0x65, 0x74, 0x50, 0x65, 0x65,
-	0x72, 0x73, 0x10, 0x06, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x09, 0x52, 0x02, 0x74, 0x6f, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74,
+	0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69,
+	0x74, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x6f, 0x70, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x04, 0x68, 0x6f, 0x70, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x69, 0x78, 0x48, 0x6f,
+	0x70, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x6d, 0x69, 0x78, 0x48, 0x6f, 0x70,
+	0x73, 0x22, 0x8a, 0x01, 0x0a, 0x08, 0x6e, 0x65, 0x77, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x12, 0x12,
+	0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x69,
+	0x6d, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x77, 0x68, 0x69, 0x74, 0x65, 0x4e, 0x6f, 0x69, 0x73, 0x65,
+	0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x77, 0x68, 0x69, 0x74, 0x65, 0x4e,
+	0x6f, 0x69, 0x73, 0x65, 0x49, 0x44, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x69, 0x67, 0x12, 0x34, 0x0a, 0x0b, 0x64, 0x65, 0x6c, 0x65,
+	0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e,
+	0x70, 0x62, 0x2e, 0x64, 0x65, 0x73, 0x74, 0x44, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x52, 0x0b, 0x64, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0x38,
+	0x0a, 0x0e, 0x64, 0x65, 0x73, 0x74, 0x44, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x14, 0x0a, 0x05, 0x65, 0x70, 0x6f, 0x63, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x05, 0x65, 0x70, 0x6f, 0x63, 0x68, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x69, 0x67, 0x22, 0x26, 0x0a, 0x0e, 0x70, 0x72, 0x6f, 0x78,
+	0x79, 0x43, 0x68, 0x61, 0x6c, 0x6c, 0x65, 0x6e, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f,
+	0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65,
+	0x22, 0x56, 0x0a, 0x0a, 0x72, 0x65, 0x6e, 0x65, 0x77, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x12, 0x12,
+	0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x69,
+	0x6d, 0x65, 0x12, 0x34, 0x0a, 0x0b, 0x64, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x62, 0x2e, 0x64, 0x65, 0x73,
+	0x74, 0x44, 0x65, 0x6c, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0b, 0x64, 0x65, 0x6c,
+	0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0x1b, 0x0a, 0x05, 0x6c, 0x65, 0x61, 0x73,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x74, 0x69, 0x6d, 0x65, 0x22, 0x43, 0x0a, 0x07, 0x64, 0x65, 0x63, 0x72, 0x79, 0x70, 0x74,
+	0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x06, 0x63, 0x79, 0x70, 0x68, 0x65, 0x72, 0x22, 0x2a, 0x0a, 0x0a, 0x75, 0x6e,
+	0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x69, 0x72, 0x63,
+	0x75, 0x69, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x63, 0x69, 0x72,
+	0x63, 0x75, 0x69, 0x74, 0x49, 0x64, 0x22, 0x3e, 0x0a, 0x0c, 0x6e, 0x65, 0x67, 0x50, 0x6c, 0x61,
+	0x69, 0x6e, 0x74, 0x65, 0x78, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69,
+	0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x69, 0x72, 0x63, 0x75,
+	0x69, 0x74, 0x49, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6e, 0x65, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x03, 0x6e, 0x65, 0x67, 0x22, 0x20, 0x0a, 0x0c, 0x6d, 0x61, 0x69, 0x6e, 0x4e, 0x65,
+	0x74, 0x50, 0x65, 0x65, 0x72, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x03, 0x6d, 0x61, 0x78, 0x22, 0x2f, 0x0a, 0x09, 0x70, 0x65, 0x65, 0x72,
+	0x73, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x22, 0x0a, 0x05, 0x70, 0x65, 0x65, 0x72, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x62, 0x2e, 0x6e, 0x6f, 0x64, 0x65, 0x49, 0x6e,
+	0x66, 0x6f, 0x52, 0x05, 0x70, 0x65, 0x65, 0x72, 0x73, 0x22, 0x2e, 0x0a, 0x08, 0x6e, 0x6f, 0x64,
+	0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x2a, 0xaa, 0x01, 0x0a, 0x07, 0x72, 0x65,
+	0x71, 0x74, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x4f, 0x6e, 0x6c, 0x69,
+	0x6e, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x73, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x4e, 0x65, 0x77,
+	0x50, 0x72, 0x6f, 0x78, 0x79, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4e, 0x65, 0x77, 0x43, 0x69,
+	0x72, 0x63, 0x75, 0x69, 0x74, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x44, 0x65, 0x63, 0x72, 0x79,
+	0x70, 0x74, 0x47, 0x6f, 0x73, 0x73, 0x69, 0x70, 0x10, 0x03, 0x12, 0x10, 0x0a, 0x0c, 0x4e, 0x65,
+	0x67, 0x50, 0x6c, 0x61, 0x69, 0x6e, 0x54, 0x65, 0x78, 0x74, 0x10, 0x04, 0x12, 0x12, 0x0a, 0x0e,
+	0x55, 0x6e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x54, 0x79, 0x70, 0x65, 0x10, 0x05,
+	0x12, 0x10, 0x0a, 0x0c, 0x4d, 0x61, 0x69, 0x6e, 0x4e, 0x65, 0x74, 0x50, 0x65, 0x65, 0x72, 0x73,
+	0x10, 0x06, 0x12, 0x0e, 0x0a, 0x0a, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x50, 0x72, 0x6f, 0x78, 0x79,
+	0x10, 0x07, 0x12, 0x12, 0x0a, 0x0e, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x43, 0x68, 0x61, 0x6c, 0x6c,
+	0x65, 0x6e, 0x67, 0x65, 0x10, 0x08, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -645,27 +913,33 @@ func file_request_proto_rawDescGZIP() []byte {
 }
 
 var file_request_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_request_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_request_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_request_proto_goTypes = []interface{}{
-	(Reqtype)(0),         // 0: pb.reqtype
-	(*Request)(nil),      // 1: pb.request
-	(*NewCircuit)(nil),   // 2: pb.newCircuit
-	(*NewProxy)(nil),     // 3: pb.newProxy
-	(*Decrypt)(nil),      // 4: pb.decrypt
-	(*UnRegister)(nil),   // 5: pb.unRegister
-	(*NegPlaintext)(nil), // 6: pb.negPlaintext
-	(*MainNetPeers)(nil), // 7: pb.mainNetPeers
-	(*PeersList)(nil),    // 8: pb.peersList
-	(*NodeInfo)(nil),     // 9: pb.nodeInfo
+	(Reqtype)(0),           // 0: pb.reqtype
+	(*Request)(nil),        // 1: pb.request
+	(*NewCircuit)(nil),     // 2: pb.newCircuit
+	(*NewProxy)(nil),       // 3: pb.newProxy
+	(*DestDelegation)(nil), // 4: pb.destDelegation
+	(*ProxyChallenge)(nil), // 5: pb.proxyChallenge
+	(*RenewProxy)(nil),     // 6: pb.renewProxy
+	(*Lease)(nil),          // 7: pb.lease
+	(*Decrypt)(nil),        // 8: pb.decrypt
+	(*UnRegister)(nil),     // 9: pb.unRegister
+	(*NegPlaintext)(nil),   // 10: pb.negPlaintext
+	(*MainNetPeers)(nil),   // 11: pb.mainNetPeers
+	(*PeersList)(nil),      // 12: pb.peersList
+	(*NodeInfo)(nil),       // 13: pb.nodeInfo
 }
 var file_request_proto_depIdxs = []int32{
-	0, // 0: pb.request.reqtype:type_name -> pb.reqtype
-	9, // 1: pb.peersList.peers:type_name -> pb.nodeInfo
-	2, // [2:2] is the sub-list for method output_type
-	2, // [2:2] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	0,  // 0: pb.request.reqtype:type_name -> pb.reqtype
+	4,  // 1: pb.newProxy.delegations:type_name -> pb.destDelegation
+	4,  // 2: pb.renewProxy.delegations:type_name -> pb.destDelegation
+	13, // 3: pb.peersList.peers:type_name -> pb.nodeInfo
+	4,  // [4:4] is the sub-list for method output_type
+	4,  // [4:4] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_request_proto_init() }
@@ -711,7 +985,7 @@ func file_request_proto_init() {
 			}
 		}
 		file_request_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Decrypt); i {
+			switch v := v.(*DestDelegation); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -723,7 +997,7 @@ func file_request_proto_init() {
 			}
 		}
 		file_request_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*UnRegister); i {
+			switch v := v.(*ProxyChallenge); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -735,7 +1009,7 @@ func file_request_proto_init() {
 			}
 		}
 		file_request_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*NegPlaintext); i {
+			switch v := v.(*RenewProxy); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -747,7 +1021,7 @@ func file_request_proto_init() {
 			}
 		}
 		file_request_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MainNetPeers); i {
+			switch v := v.(*Lease); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -759,7 +1033,7 @@ func file_request_proto_init() {
 			}
 		}
 		file_request_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*PeersList); i {
+			switch v := v.(*Decrypt); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -771,6 +1045,54 @@ func file_request_proto_init() {
 			}
 		}
 		file_request_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UnRegister); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_request_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NegPlaintext); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_request_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MainNetPeers); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_request_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeersList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_request_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*NodeInfo); i {
 			case 0:
 				return &v.state
@@ -789,7 +1111,7 @@ func file_request_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_request_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   9,
+			NumMessages:   13,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Evanesco-Labs/WhiteNoise/cmd/chat"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/common/account"
 	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
@@ -12,6 +15,7 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/sdk"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"math/rand"
@@ -21,6 +25,9 @@ import (
 	"time"
 )
 
+//PassphraseEnv is the environment variable the passphrase of the account keystore is read from.
+const PassphraseEnv = "WHITENOISE_PASSPHRASE"
+
 var node *network.Node
 var wnSDK *sdk.WhiteNoiseClient
 var (
@@ -29,11 +36,25 @@ var (
 		Value: 3331,
 	}
 
-	BootStrapFlag = cli.StringFlag{
+	BootStrapFlag = cli.StringSliceFlag{
 		Name:  "bootstrap, b",
-		Usage: "PeerId of the node to bootstrap from.",
+		Usage: "MultiAddr of a node to bootstrap from, repeat it for more nodes.",
+	}
+	BootStrapFileFlag = cli.StringFlag{
+		Name:  "bootstrap-file",
+		Usage: "Load MultiAddrs of nodes to bootstrap from at this path, one per line",
 		Value: "",
 	}
+	PeerStoreFlag = cli.StringFlag{
+		Name:  "peerstore",
+		Usage: "Keep known peers in the leveldb at this path to restart without a bootstrap, empty turns it off",
+		Value: "./peerstore",
+	}
+	ChatPeerStoreFlag = cli.StringFlag{
+		Name:  "peerstore",
+		Usage: "Keep proxies in the leveldb at this path to restart without a bootstrap, empty turns it off",
+		Value: "./peerstore-chat",
+	}
 	NodeFlag = cli.StringFlag{
 		Name:  "node, n",
 		Usage: "PeerId of the node to connect to.",
@@ -79,6 +100,75 @@ var (
 		Usage: "Set key type",
 		Value: "ed25519",
 	}
+
+	HopsFlag = cli.IntFlag{
+		Name:  "hops",
+		Usage: "Relay hops between the joint and the exit node of the circuit",
+		Value: common.DefaultRelayHops,
+	}
+
+	CellsFlag = cli.BoolFlag{
+		Name:  "cells",
+		Usage: "Send circuit data in fixed size cells",
+	}
+
+	CoverFlag = cli.Float64Flag{
+		Name:  "cover",
+		Usage: "Dummy cells per second as cover traffic, on relay links for a node and on circuits for chat, 0 is off",
+	}
+
+	PoissonFlag = cli.BoolFlag{
+		Name:  "poisson",
+		Usage: "Send cover traffic at Poisson distributed intervals instead of a constant rate",
+	}
+
+	MixFlag = cli.StringFlag{
+		Name:  "mix",
+		Usage: "Mix relayed data, \"delay\" holds each message for a random delay, \"batch\" forwards shuffled batches",
+		Value: "",
+	}
+
+	MixDelayFlag = cli.DurationFlag{
+		Name:  "mix-delay",
+		Usage: "Mean delay of a mixed message, or the longest wait for a batch",
+		Value: 100 * time.Millisecond,
+	}
+
+	MixBatchFlag = cli.IntFlag{
+		Name:  "mix-batch",
+		Usage: "Messages in a mixed batch",
+		Value: 8,
+	}
+
+	MixHopsFlag = cli.IntFlag{
+		Name:  "mixhops",
+		Usage: "Relay hops of the circuit that must be mixing nodes",
+		Value: 0,
+	}
+
+	LookupFlag = cli.StringFlag{
+		Name:  "lookup",
+		Usage: "How a proxy finds the proxy of a destination, \"gossip\" floods the negotiation, \"dht\" looks it up in the DHT",
+		Value: "gossip",
+	}
+
+	PassphraseFileFlag = cli.StringFlag{
+		Name:  "passphrase-file",
+		Usage: "Read the passphrase of the account keystore from this file, else it is read from $" + PassphraseEnv + " or prompted for",
+		Value: "",
+	}
+
+	OutFlag = cli.StringFlag{
+		Name:  "out",
+		Usage: "Write the encrypted key file to this path",
+		Value: "./whitenoise.key",
+	}
+
+	ProxiesFlag = cli.IntFlag{
+		Name:  "proxies",
+		Usage: "Register to this many of the nearest proxies and fail over to backups, 0 registers to one random proxy",
+		Value: 0,
+	}
 )
 
 func main() {
@@ -99,12 +189,21 @@ func initApp() *cli.App {
 			Flags: []cli.Flag{
 				PortFlag,
 				BootStrapFlag,
+				BootStrapFileFlag,
+				PeerStoreFlag,
 				ModeFlag,
 				LogLevelFlag,
 				BootFlag,
 				WhiteListFlag,
 				AccountFromFileFlag,
 				KeyFlag,
+				PassphraseFileFlag,
+				CoverFlag,
+				PoissonFlag,
+				MixFlag,
+				MixDelayFlag,
+				MixBatchFlag,
+				LookupFlag,
 			},
 		},
 
@@ -114,11 +213,33 @@ func initApp() *cli.App {
 			Action: StartChat,
 			Flags: []cli.Flag{
 				BootStrapFlag,
+				BootStrapFileFlag,
+				ChatPeerStoreFlag,
 				NodeFlag,
 				LogLevelFlag,
 				NickFlag,
 				AccountFromFileFlag,
 				KeyFlag,
+				PassphraseFileFlag,
+				HopsFlag,
+				CellsFlag,
+				CoverFlag,
+				PoissonFlag,
+				MixHopsFlag,
+				ProxiesFlag,
+			},
+		},
+
+		{
+			Name:   "export",
+			Usage:  "Export the account to an encrypted key file",
+			Action: Export,
+			Flags: []cli.Flag{
+				AccountFromFileFlag,
+				KeyFlag,
+				PassphraseFileFlag,
+				OutFlag,
+				LogLevelFlag,
 			},
 		},
 	}
@@ -127,25 +248,14 @@ func initApp() *cli.App {
 
 func Start(ctx *cli.Context) {
 	port := ctx.Int("port")
-	bootstrap := ctx.String("bootstrap")
+	bootstrap := bootstrapPeers(ctx)
 	clientMode := ctx.Bool("client")
 	bootMode := ctx.Bool("boot")
 	whitelist := ctx.Bool("whitelist")
 	pemPath := ctx.String("account")
 	keyTypeStr := ctx.String("keytype")
 
-	//decode keytype
-	keyType := 0
-	switch keyTypeStr {
-	case "ed25519":
-		keyType = crypto.Ed25519
-	case "secp256k1":
-		keyType = crypto.Secpk1
-	case "ecdsa":
-		keyType = crypto.ECDSA
-	default:
-		keyType = crypto.Ed25519
-	}
+	keyType := parseKeyType(keyTypeStr)
 
 	logLevel := ctx.Int("log")
 	log.InitLog(logLevel, os.Stdout, log.PATH)
@@ -155,8 +265,12 @@ func Start(ctx *cli.Context) {
 		ListenHost:       "127.0.0.1",
 		ListenPort:       port,
 		BootStrapPeers:   bootstrap,
+		PeerStorePath:    ctx.String("peerstore"),
 		Mode:             config.ServerMode,
 		WhiteList:        whitelist,
+		LinkCover:        coverConfig(ctx),
+		Mix:              mixConfig(ctx),
+		Lookup:           lookupMode(ctx),
 	}
 
 	if clientMode {
@@ -172,19 +286,7 @@ func Start(ctx *cli.Context) {
 		InitWhiteList()
 	}
 
-	acc := account.GetAccountFromFile(pemPath)
-	if acc == nil || acc.KeyType != keyType {
-		switch keyType {
-		case crypto.ECDSA:
-			acc = account.GetAccount(crypto.ECDSA)
-		case crypto.Ed25519:
-			acc = account.GetAccount(crypto.Ed25519)
-		case crypto.Secpk1:
-			acc = account.GetAccount(crypto.Secpk1)
-		default:
-			panic("key type not support")
-		}
-	}
+	acc, _ := loadAccount(pemPath, keyType, ctx)
 
 	var err error
 	node, err = network.NewNode(con, &cfg, acc)
@@ -198,41 +300,24 @@ func Start(ctx *cli.Context) {
 func StartChat(ctx *cli.Context) {
 	logLevel := ctx.Int("log")
 	log.InitLog(logLevel, os.Stdout, log.PATH)
-	bootstrap := ctx.String("bootstrap")
+	bootstrap := bootstrapPeers(ctx)
 	n := ctx.String("node")
 	con := context.Background()
 	nick := ctx.String("nick")
 	pemPath := ctx.String("account")
 	keyTypeStr := ctx.String("keytype")
+	hops := ctx.Int("hops")
+	cells := ctx.Bool("cells")
+	mixHops := ctx.Int("mixhops")
+	proxies := ctx.Int("proxies")
 
-	//decode keytype
-	keyType := 0
-	switch keyTypeStr {
-	case "ed25519":
-		keyType = crypto.Ed25519
-	case "secp256k1":
-		keyType = crypto.Secpk1
-	case "ecdsa":
-		keyType = crypto.ECDSA
-	default:
-		keyType = crypto.Ed25519
-	}
+	keyType := parseKeyType(keyTypeStr)
 
 	sdk.BootStrapPeers = bootstrap
+	sdk.PeerStorePath = ctx.String("peerstore")
+	sdk.CircuitCover = coverConfig(ctx)
 
-	acc := account.GetAccountFromFile(pemPath)
-	if acc == nil || acc.KeyType != keyType {
-		switch keyType {
-		case crypto.ECDSA:
-			acc = account.GetAccount(crypto.ECDSA)
-		case crypto.Ed25519:
-			acc = account.GetAccount(crypto.Ed25519)
-		case crypto.Secpk1:
-			acc = account.GetAccount(crypto.Secpk1)
-		default:
-			panic("key type not support")
-		}
-	}
+	acc, _ := loadAccount(pemPath, keyType, ctx)
 
 	var err error
 	wnSDK, err = sdk.NewClient(con, acc)
@@ -248,16 +333,28 @@ func StartChat(ctx *cli.Context) {
 		panic("No peers exist")
 	}
 
-	index := rand.New(rand.NewSource(time.Now().UnixNano())).Int() % len(peers)
-	entry := peers[index]
-	log.Info("entry:", entry.String(), ",index:", index)
-	err = wnSDK.Register(entry)
+	if proxies > 0 {
+		err = wnSDK.RegisterProxies(peers, proxies)
+		log.Info("proxies:", wnSDK.Proxies())
+	} else {
+		index := rand.New(rand.NewSource(time.Now().UnixNano())).Int() % len(peers)
+		entry := peers[index]
+		log.Info("entry:", entry.String(), ",index:", index)
+		err = wnSDK.Register(entry)
+	}
 	if err != nil {
 		panic(err)
 	}
 	time.Sleep(time.Millisecond * 100)
 	if n != "" {
-		_, sessionID, err := wnSDK.Dial(n)
+		opts := []sdk.DialOption{sdk.WithRelayHops(hops)}
+		if cells {
+			opts = append(opts, sdk.WithCells())
+		}
+		if mixHops > 0 {
+			opts = append(opts, sdk.WithMixHops(mixHops))
+		}
+		_, sessionID, err := wnSDK.Dial(n, opts...)
 		if err != nil {
 			panic(err)
 		}
@@ -270,6 +367,134 @@ func StartChat(ctx *cli.Context) {
 	}
 }
 
+func bootstrapPeers(ctx *cli.Context) []string {
+	peers := ctx.StringSlice("bootstrap")
+	path := ctx.String("bootstrap-file")
+	if path == "" {
+		return peers
+	}
+	filePeers, err := config.ReadBootstrapFile(path)
+	if err != nil {
+		panic(err)
+	}
+	return append(peers, filePeers...)
+}
+
+func coverConfig(ctx *cli.Context) config.CoverConfig {
+	rate := ctx.Float64("cover")
+	if rate <= 0 {
+		return config.CoverConfig{}
+	}
+	if ctx.Bool("poisson") {
+		return config.CoverConfig{Mode: config.CoverPoisson, Rate: rate}
+	}
+	return config.CoverConfig{Mode: config.CoverConstant, Rate: rate}
+}
+
+func mixConfig(ctx *cli.Context) config.MixConfig {
+	cfg := config.MixConfig{
+		Delay:     ctx.Duration("mix-delay"),
+		BatchSize: ctx.Int("mix-batch"),
+	}
+	switch ctx.String("mix") {
+	case "":
+		cfg.Mode = config.MixOff
+	case "delay":
+		cfg.Mode = config.MixDelay
+	case "batch":
+		cfg.Mode = config.MixBatch
+	default:
+		panic("mix mode not support")
+	}
+	return cfg
+}
+
+func lookupMode(ctx *cli.Context) config.LookupMode {
+	switch ctx.String("lookup") {
+	case "", "gossip":
+		return config.LookupGossip
+	case "dht":
+		return config.LookupDHT
+	default:
+		panic("lookup mode not support")
+	}
+}
+
+func parseKeyType(keyTypeStr string) int {
+	switch keyTypeStr {
+	case "ed25519":
+		return crypto.Ed25519
+	case "secp256k1":
+		return crypto.Secpk1
+	case "ecdsa":
+		return crypto.ECDSA
+	default:
+		return crypto.Ed25519
+	}
+}
+
+//passphrase reads the passphrase of the account keystore from --passphrase-file, $WHITENOISE_PASSPHRASE or the terminal.
+func passphrase(ctx *cli.Context) ([]byte, error) {
+	var pass []byte
+	if path := ctx.String("passphrase-file"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pass = bytes.TrimRight(data, "\r\n")
+	} else if env, ok := os.LookupEnv(PassphraseEnv); ok {
+		pass = []byte(env)
+	} else if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Passphrase of the account keystore: ")
+		data, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		pass = data
+	} else {
+		return nil, errors.New("no passphrase, set --passphrase-file or $" + PassphraseEnv)
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return pass, nil
+}
+
+//loadAccount loads the account from the key file at path, or from the keystore in leveldb if there is no key file
+//or its key is not of keyType.
+func loadAccount(path string, keyType int, ctx *cli.Context) (*account.Account, []byte) {
+	pass, err := passphrase(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if path != "" {
+		acc, err := account.GetAccountFromFile(path, pass)
+		if err != nil {
+			panic(err)
+		}
+		if acc.KeyType == keyType {
+			return acc, pass
+		}
+		log.Warnf("key file %v is not of key type %v, use the account in leveldb", path, ctx.String("keytype"))
+	}
+	acc, err := account.GetAccount(keyType, pass)
+	if err != nil {
+		panic(err)
+	}
+	return acc, pass
+}
+
+func Export(ctx *cli.Context) {
+	log.InitLog(ctx.Int("log"), os.Stdout, log.PATH)
+	acc, pass := loadAccount(ctx.String("account"), parseKeyType(ctx.String("keytype")), ctx)
+	out := ctx.String("out")
+	if err := account.ExportAccount(acc, out, pass); err != nil {
+		panic(err)
+	}
+	fmt.Printf("exported account %v to %v\n", acc.GetPublicKey().GetWhiteNoiseID().String(), out)
+}
+
 func InitWhiteList() {
 	config.WhiteListPeers = make(map[peer.ID]bool)
 	var ymlConfig = config.YmlConfig{Whitelist: make([]string, 0)}
@@ -2,14 +2,25 @@ package gossip
 
 import (
 	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/internal/actorMsg"
 )
 
 func (service *DHTService) Receive(ctx actor.Context) {
 	switch msg := ctx.Message().(type) {
 	case actorMsg.ReqGossipJoint:
-		err := service.GossipJoint(msg.DesHash, msg.NegCypher)
-		ctx.Respond(actorMsg.ResError{Err: err})
+		//looking up the destination in the DHT takes a while, don't hold up the actor
+		sender := ctx.Sender()
+		go func() {
+			direct, err := service.GossipJoint(msg.DesHash, msg.NegCypher, msg.GossipOnly)
+			if sender != nil {
+				service.actorCtx.Send(sender, actorMsg.ResGossipJoint{Direct: direct, Err: err})
+			}
+		}()
+	case actorMsg.ReqPublishDest:
+		if service.Lookup == config.LookupDHT {
+			go service.publishDest(msg.DesHash)
+		}
 	case actorMsg.ReqDHTPeers:
 		peerInfos := service.GetDHTPeers(msg.Max)
 		ctx.Respond(actorMsg.ResDHTPeers{PeerInfos: peerInfos})
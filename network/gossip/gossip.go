@@ -2,11 +2,14 @@ package gossip
 
 import (
 	"google.golang.org/protobuf/proto"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
 	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
 )
 
-func (service *DHTService) GossipJoint(desHash string, negCypher []byte) error {
+//GossipJoint sends the negotiation straight to the proxy the DHT names for the destination, or gossips it if there is
+//none or gossipOnly is set. It reports if the negotiation was sent straight.
+func (service *DHTService) GossipJoint(desHash string, negCypher []byte, gossipOnly bool) (bool, error) {
 	encNeg := pb.EncryptedNeg{
 		Des:    desHash,
 		Cypher: negCypher,
@@ -14,12 +17,23 @@ func (service *DHTService) GossipJoint(desHash string, negCypher []byte) error {
 
 	data, err := proto.Marshal(&encNeg)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if service.Lookup == config.LookupDHT {
+		if gossipOnly {
+			//the proxy we sent to acked but no answer came, don't trust it again
+			service.destCache.remove(desHash)
+		} else if err = service.sendToDest(desHash, data); err == nil {
+			log.Debug("sent neg cypher to destination proxy")
+			return true, nil
+		} else {
+			log.Debugf("Send neg cypher to destination proxy err %v, gossip instead", err)
+		}
 	}
 	log.Debug("gossip neg cypher")
 	err = service.NoisePublish(data)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return false, nil
 }
@@ -0,0 +1,590 @@
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	crypto2 "github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/proxy"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/mr-tron/base58"
+	"github.com/multiformats/go-multiaddr"
+	"golang.org/x/crypto/chacha20poly1305"
+	"google.golang.org/protobuf/proto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//DestNamespace is the DHT namespace destination records are stored under.
+const DestNamespace = "whitenoise"
+
+//NegProtocol carries a negotiation from the proxy of the caller straight to the proxy of the destination.
+const NegProtocol string = "/whitenoise/neg"
+
+var (
+	ErrDestNotFound      = errors.New("no destination record")
+	ErrDestRejected      = errors.New("negotiation rejected by destination proxy")
+	ErrInvalidDestRecord = errors.New("invalid destination record")
+)
+
+//most destinations a proxy publishes records of at the same time
+const destPublishWorkers = 8
+
+type destEntry struct {
+	proxy peer.ID
+	until time.Time
+}
+
+//destCache remembers the proxies of at most DestCacheSize destinations, each until its record expires but at most for
+//DestCacheTTL. When it is full the entry that expires first makes room.
+type destCache struct {
+	mut     sync.Mutex
+	entries map[string]destEntry
+}
+
+func newDestCache() *destCache {
+	return &destCache{entries: make(map[string]destEntry)}
+}
+
+func (c *destCache) get(desHash string) (peer.ID, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	entry, ok := c.entries[desHash]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(entry.until) {
+		delete(c.entries, desHash)
+		return "", false
+	}
+	return entry.proxy, true
+}
+
+func (c *destCache) put(desHash string, proxy peer.ID, until time.Time) {
+	now := time.Now()
+	if max := now.Add(common.DestCacheTTL); until.After(max) {
+		until = max
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if _, ok := c.entries[desHash]; !ok && len(c.entries) >= common.DestCacheSize {
+		var first string
+		for des, entry := range c.entries {
+			if !now.Before(entry.until) {
+				delete(c.entries, des)
+			} else if first == "" || entry.until.Before(c.entries[first].until) {
+				first = des
+			}
+		}
+		if len(c.entries) >= common.DestCacheSize {
+			delete(c.entries, first)
+		}
+	}
+	c.entries[desHash] = destEntry{proxy: proxy, until: until}
+}
+
+func (c *destCache) remove(desHash string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	delete(c.entries, desHash)
+}
+
+func destEpoch(t time.Time) int64 {
+	return proxy.DestEpoch(t)
+}
+
+func epochStart(epoch int64) time.Time {
+	return time.Unix(epoch*int64(common.DestRecordEpoch/time.Second), 0)
+}
+
+//destKeys are derived from the WhiteNoiseID hash of a destination and an epoch, so only who knows the destination can
+//find, open or sign its records, and records of different epochs cannot be linked to each other.
+type destKeys struct {
+	key  string         //blinded DHT key, the hash of the public key of sign
+	sign crypto.PrivKey //blinded key that signs the sealed records
+	seal []byte         //key the records are sealed with
+}
+
+func newDestKeys(desHash string, epoch int64) destKeys {
+	seed := sha256.Sum256([]byte(fmt.Sprintf("%s/sign/%s/%d", DestNamespace, desHash, epoch)))
+	seal := sha256.Sum256([]byte(fmt.Sprintf("%s/seal/%s/%d", DestNamespace, desHash, epoch)))
+	//a seed of the right size never fails
+	sign, _, _ := crypto.GenerateEd25519Key(bytes.NewReader(seed[:]))
+	pubKey, _ := crypto.MarshalPublicKey(sign.GetPublic())
+	return destKeys{key: blindedKey(pubKey), sign: sign, seal: seal[:]}
+}
+
+func blindedKey(pubKey []byte) string {
+	h := sha256.Sum256(pubKey)
+	return "/" + DestNamespace + "/" + base58.Encode(h[:])
+}
+
+//DestKey is the blinded DHT key of the destination whose WhiteNoiseID hash is desHash, in epoch.
+func DestKey(desHash string, epoch int64) string {
+	return newDestKeys(desHash, epoch).key
+}
+
+//signedFields is what a record signature covers, every field is length prefixed.
+func signedFields(fields ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, field := range fields {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+//destRecordPayload is what the proxy signs.
+func destRecordPayload(key string, rec *pb.DestRecord) []byte {
+	fields := [][]byte{[]byte(key), []byte(rec.Proxy), []byte(strconv.FormatInt(rec.Expire, 10)), []byte(rec.WhiteNoiseID), rec.Delegation}
+	for _, addr := range rec.Addrs {
+		fields = append(fields, []byte(addr))
+	}
+	return signedFields(fields...)
+}
+
+//sealedRecordPayload is what the blinded key signs.
+func sealedRecordPayload(key string, rec *pb.SealedDestRecord) []byte {
+	return signedFields([]byte(key), []byte(strconv.FormatInt(rec.Expire, 10)), rec.PubKey, rec.Cypher)
+}
+
+//NewDestRecord returns the record of the destination whiteNoiseID in epoch that names the proxy with private key priv,
+//reachable at addrs until expire. delegation is the signature of the destination that lets the proxy publish it. The
+//record is signed by the proxy and sealed, so the DHT learns neither the destination, the proxy nor its addresses.
+func NewDestRecord(whiteNoiseID crypto2.WhiteNoiseID, delegation []byte, epoch int64, priv crypto.PrivKey, addrs []multiaddr.Multiaddr, expire time.Time) ([]byte, error) {
+	keys := newDestKeys(whiteNoiseID.Hash(), epoch)
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := crypto.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	rec := pb.DestRecord{
+		Proxy:        id.String(),
+		Expire:       expire.Unix(),
+		PubKey:       pubKey,
+		WhiteNoiseID: whiteNoiseID.String(),
+		Delegation:   delegation,
+	}
+	for _, addr := range addrs {
+		rec.Addrs = append(rec.Addrs, addr.String())
+	}
+	rec.Sig, err = priv.Sign(destRecordPayload(keys.key, &rec))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := proto.Marshal(&rec)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(keys.seal)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := pb.SealedDestRecord{
+		Expire: rec.Expire,
+		Cypher: aead.Seal(nonce, nonce, plain, []byte(keys.key)),
+	}
+	sealed.PubKey, err = crypto.MarshalPublicKey(keys.sign.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	sealed.Sig, err = keys.sign.Sign(sealedRecordPayload(keys.key, &sealed))
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&sealed)
+}
+
+//checkExpire checks a record expires in the future, but not after the epoch following the next one.
+func checkExpire(unix int64) error {
+	now := time.Now()
+	expire := time.Unix(unix, 0)
+	if !expire.After(now) || expire.Sub(now) > 2*common.DestRecordEpoch {
+		return fmt.Errorf("%w: expire at %v", ErrInvalidDestRecord, expire)
+	}
+	return nil
+}
+
+//ParseSealedDestRecord checks the record under key is signed by the blinded key the key is derived from and has not
+//expired. It is all DHT nodes can check, they cannot open the record.
+func ParseSealedDestRecord(key string, value []byte) (*pb.SealedDestRecord, error) {
+	var sealed pb.SealedDestRecord
+	if err := proto.Unmarshal(value, &sealed); err != nil {
+		return nil, err
+	}
+	if err := checkExpire(sealed.Expire); err != nil {
+		return nil, err
+	}
+	if blindedKey(sealed.PubKey) != key {
+		return nil, fmt.Errorf("%w: not signed by the key of %v", ErrInvalidDestRecord, key)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(sealed.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := pubKey.Verify(sealedRecordPayload(key, &sealed), sealed.Sig)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidDestRecord)
+	}
+	return &sealed, nil
+}
+
+//OpenDestRecord opens the record of desHash in epoch, and checks it is signed by the proxy it names, the destination
+//delegated the epoch to that proxy and it has not expired. Anyone who saw desHash can seal a record, only the
+//destination can delegate.
+func OpenDestRecord(desHash string, epoch int64, value []byte) (*pb.DestRecord, peer.ID, error) {
+	keys := newDestKeys(desHash, epoch)
+	sealed, err := ParseSealedDestRecord(keys.key, value)
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := chacha20poly1305.New(keys.seal)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(sealed.Cypher) < aead.NonceSize() {
+		return nil, "", fmt.Errorf("%w: short cypher", ErrInvalidDestRecord)
+	}
+	nonce, cypher := sealed.Cypher[:aead.NonceSize()], sealed.Cypher[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, cypher, []byte(keys.key))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidDestRecord, err)
+	}
+	var rec pb.DestRecord
+	if err := proto.Unmarshal(plain, &rec); err != nil {
+		return nil, "", err
+	}
+	if rec.Expire != sealed.Expire {
+		return nil, "", fmt.Errorf("%w: expire does not match the sealed record", ErrInvalidDestRecord)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(rec.PubKey)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if id.String() != rec.Proxy {
+		return nil, "", fmt.Errorf("%w: key of %v does not match proxy %v", ErrInvalidDestRecord, id, rec.Proxy)
+	}
+	ok, err := pubKey.Verify(destRecordPayload(keys.key, &rec), rec.Sig)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", fmt.Errorf("%w: bad signature", ErrInvalidDestRecord)
+	}
+	whiteNoiseID, err := crypto2.WhiteNoiseIDfromString(rec.WhiteNoiseID)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidDestRecord, err)
+	}
+	if whiteNoiseID.Hash() != desHash {
+		return nil, "", fmt.Errorf("%w: WhiteNoiseID is not of the destination", ErrInvalidDestRecord)
+	}
+	if err := proxy.VerifyDelegation(whiteNoiseID, id, epoch, rec.Delegation); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidDestRecord, err)
+	}
+	return &rec, id, nil
+}
+
+type destLookup struct {
+	desHash string
+	epoch   int64
+	refs    int
+}
+
+//destLookups are the destinations this node looks up or publishes records of right now, by their DHT key. Only under
+//these keys the records can be opened, DHT nodes that merely store records cannot tell who delegated them.
+type destLookups struct {
+	mut  sync.Mutex
+	keys map[string]*destLookup
+}
+
+func newDestLookups() *destLookups {
+	return &destLookups{keys: make(map[string]*destLookup)}
+}
+
+//add returns the DHT key of desHash in epoch, it is known until done is called with it as often as add.
+func (l *destLookups) add(desHash string, epoch int64) string {
+	key := DestKey(desHash, epoch)
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	lookup, ok := l.keys[key]
+	if !ok {
+		lookup = &destLookup{desHash: desHash, epoch: epoch}
+		l.keys[key] = lookup
+	}
+	lookup.refs++
+	return key
+}
+
+func (l *destLookups) done(key string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if lookup, ok := l.keys[key]; ok {
+		lookup.refs--
+		if lookup.refs <= 0 {
+			delete(l.keys, key)
+		}
+	}
+}
+
+func (l *destLookups) get(key string) (string, int64, bool) {
+	if l == nil {
+		return "", 0, false
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	lookup, ok := l.keys[key]
+	if !ok {
+		return "", 0, false
+	}
+	return lookup.desHash, lookup.epoch, true
+}
+
+//DestValidator validates destination records stored in the DHT. Of several valid records the ones the destination
+//delegated win if this node knows the destination, and of those the one that expires last.
+type DestValidator struct {
+	lookups *destLookups
+}
+
+//NewDestValidator returns the validator of destination records for the DHT of one node.
+func NewDestValidator() DestValidator {
+	return DestValidator{lookups: newDestLookups()}
+}
+
+func (DestValidator) Validate(key string, value []byte) error {
+	_, err := ParseSealedDestRecord(key, value)
+	return err
+}
+
+func (v DestValidator) Select(key string, values [][]byte) (int, error) {
+	desHash, epoch, known := v.lookups.get(key)
+	best := -1
+	var bestExpire int64
+	bestDelegated := false
+	for i, value := range values {
+		rec, err := ParseSealedDestRecord(key, value)
+		if err != nil {
+			continue
+		}
+		delegated := false
+		if known {
+			_, _, err := OpenDestRecord(desHash, epoch, value)
+			delegated = err == nil
+		}
+		if best < 0 || delegated && !bestDelegated || delegated == bestDelegated && rec.Expire > bestExpire {
+			best = i
+			bestExpire = rec.Expire
+			bestDelegated = delegated
+		}
+	}
+	if best < 0 {
+		return 0, ErrInvalidDestRecord
+	}
+	return best, nil
+}
+
+//destLookupsOf returns the destinations the validator of destination records of dht opens records of.
+func destLookupsOf(dht *kaddht.IpfsDHT) *destLookups {
+	if dht != nil {
+		if validators, ok := dht.Validator.(record.NamespacedValidator); ok {
+			if v, ok := validators[DestNamespace].(DestValidator); ok && v.lookups != nil {
+				return v.lookups
+			}
+		}
+	}
+	return newDestLookups()
+}
+
+//publishDest stores the record of our client desHash for the current epoch, and for the next one if it starts before
+//the next refresh, so callers find the client across the rotation.
+func (service *DHTService) publishDest(desHash string) {
+	now := time.Now()
+	epoch := destEpoch(now)
+	service.putDest(desHash, epoch)
+	if epochStart(epoch+1).Sub(now) < 2*common.DestRecordRefresh {
+		service.putDest(desHash, epoch+1)
+	}
+}
+
+func (service *DHTService) putDest(desHash string, epoch int64) {
+	clientInfo, ok := service.getClient(desHash)
+	if !ok {
+		return
+	}
+	delegation, ok := clientInfo.Delegation(epoch)
+	if !ok {
+		log.Debugf("Client %v delegated no destination record of epoch %v", clientInfo.PeerID, epoch)
+		return
+	}
+	value, err := NewDestRecord(clientInfo.WhiteNoiseID, delegation, epoch, service.host.Peerstore().PrivKey(service.host.ID()), service.host.Addrs(), epochStart(epoch+1))
+	if err != nil {
+		log.Errorf("New destination record err %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(service.ctx, common.DestPublishTimeout)
+	defer cancel()
+	key := service.destLookups.add(desHash, epoch)
+	defer service.destLookups.done(key)
+	if err := service.dht.PutValue(ctx, key, value); err != nil {
+		log.Warnf("Publish destination record err %v", err)
+	}
+}
+
+//publishDests refreshes the destination records of all our clients until the service stops.
+func (service *DHTService) publishDests(ctx context.Context) {
+	ticker := time.NewTicker(common.DestRecordRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fut := service.actorCtx.RequestFuture(service.proxyPid, proxy.ReqClients{}, common.RequestFutureDuration)
+		res, err := fut.Result()
+		if err != nil {
+			log.Warnf("Get clients err %v", err)
+			continue
+		}
+		workers := make(chan struct{}, destPublishWorkers)
+		for _, desHash := range res.(proxy.ResClients).Destinations {
+			workers <- struct{}{}
+			go func(desHash string) {
+				defer func() { <-workers }()
+				service.publishDest(desHash)
+			}(desHash)
+		}
+	}
+}
+
+//findDest looks up the proxy of the destination in the DHT, results are cached until their record expires.
+func (service *DHTService) findDest(desHash string) (peer.ID, error) {
+	if proxyId, ok := service.destCache.get(desHash); ok {
+		return proxyId, nil
+	}
+	epoch := destEpoch(time.Now())
+	ctx, cancel := context.WithTimeout(service.ctx, common.DestGetTimeout)
+	defer cancel()
+	//records the destination did not delegate lose against those it did
+	key := service.destLookups.add(desHash, epoch)
+	defer service.destLookups.done(key)
+	value, err := service.dht.GetValue(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDestNotFound, err)
+	}
+	rec, proxyId, err := OpenDestRecord(desHash, epoch, value)
+	if err != nil {
+		return "", err
+	}
+	until := time.Unix(rec.Expire, 0)
+	for _, a := range rec.Addrs {
+		addr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		service.host.Peerstore().AddAddr(proxyId, addr, time.Until(until))
+	}
+	service.destCache.put(desHash, proxyId, until)
+	return proxyId, nil
+}
+
+//sendToDest finds the proxy of the destination and hands it the negotiation, it fails if the proxy does not serve
+//the destination any more.
+func (service *DHTService) sendToDest(desHash string, data []byte) error {
+	proxyId, err := service.findDest(desHash)
+	if err != nil {
+		return err
+	}
+	if proxyId == service.host.ID() {
+		return fmt.Errorf("%w: record points to ourselves", ErrDestRejected)
+	}
+	ctx, cancel := context.WithTimeout(service.ctx, common.DestNegTimeout)
+	defer cancel()
+	err = service.sendNeg(ctx, proxyId, data)
+	if err != nil {
+		service.destCache.remove(desHash)
+	}
+	return err
+}
+
+func (service *DHTService) sendNeg(ctx context.Context, proxyId peer.ID, data []byte) error {
+	stream, err := service.host.NewStream(ctx, proxyId, protocol.ID(NegProtocol))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	s := session.NewStream(stream, service.ctx)
+	if err := s.RW.WriteMsg(data); err != nil {
+		return err
+	}
+	replyData, err := s.RW.ReadMsg()
+	if err != nil {
+		return err
+	}
+	var reply pb.Ack
+	if err := proto.Unmarshal(replyData, &reply); err != nil {
+		return err
+	}
+	if !reply.Result {
+		return fmt.Errorf("%w: %s", ErrDestRejected, string(reply.Data))
+	}
+	return nil
+}
+
+//NegStreamHandler takes a negotiation sent straight to this node as the proxy of its destination, and answers if the
+//destination is a client here.
+func (service *DHTService) NegStreamHandler(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(common.DestNegTimeout))
+	s := session.NewStream(stream, service.ctx)
+	data, err := s.RW.ReadMsg()
+	if err != nil {
+		return
+	}
+	reply := pb.Ack{}
+	neg, err := service.validator.validateDirect(s.RemotePeer, data)
+	if err != nil {
+		reply.Data = []byte(err.Error())
+	} else if clientInfo, ok := service.getClient(neg.Des); !ok {
+		reply.Data = []byte("not a client here")
+	} else if !service.validator.seen.add(neg.Cypher) {
+		//already handled, by gossip or an earlier attempt
+		reply.Result = true
+	} else {
+		reply.Result = true
+		log.Debugf("Handling negotiation for my client %v from %v", clientInfo.PeerID, s.RemotePeer)
+		go service.handleGossipMsg(clientInfo, neg)
+	}
+	replyData, _ := proto.Marshal(&reply)
+	s.RW.WriteMsg(replyData)
+}
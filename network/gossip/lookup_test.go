@@ -0,0 +1,172 @@
+package gossip
+
+import (
+	"bytes"
+	cr "crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	crypto2 "github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/proxy"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/magiconair/properties/assert"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestDestKey(t *testing.T) {
+	epoch := destEpoch(time.Now())
+	assert.Equal(t, DestKey("des", epoch), DestKey("des", epoch))
+	assert.Equal(t, DestKey("des", epoch) != DestKey("des", epoch+1), true)
+	assert.Equal(t, DestKey("des", epoch) != DestKey("other", epoch), true)
+	assert.Equal(t, epochStart(epoch).After(time.Now()), false)
+	assert.Equal(t, epochStart(epoch+1).After(time.Now()), true)
+}
+
+//newTestDest returns the key of a destination and its delegation to the proxy id in epoch.
+func newTestDest(t *testing.T, id peer.ID, epoch int64) (crypto2.PrivateKey, crypto2.WhiteNoiseID, []byte) {
+	priv, pub, err := crypto2.GenerateKeyPair(crypto2.Ed25519, cr.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	des := pub.GetWhiteNoiseID()
+	delegation, err := priv.Sign(proxy.DelegationPayload(des.Hash(), id, epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, des, delegation
+}
+
+func TestDestRecord(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(cr.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(priv)
+	addr, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/3331")
+	epoch := destEpoch(time.Now())
+	_, des, delegation := newTestDest(t, id, epoch)
+	desHash := des.Hash()
+	key := DestKey(desHash, epoch)
+	validator := DestValidator{}
+
+	value, err := NewDestRecord(des, delegation, epoch, priv, []multiaddr.Multiaddr{addr}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, proxyId, err := OpenDestRecord(desHash, epoch, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, proxyId, id)
+	assert.Equal(t, rec.Addrs, []string{addr.String()})
+	assert.Equal(t, validator.Validate(key, value), nil)
+
+	//the DHT sees neither the destination, the proxy nor its addresses
+	assert.Equal(t, bytes.Contains(value, []byte(des.String())), false)
+	assert.Equal(t, bytes.Contains(value, []byte(id)), false)
+	assert.Equal(t, bytes.Contains(value, []byte(addr.String())), false)
+	assert.Equal(t, bytes.Contains(value, addr.Bytes()), false)
+
+	//a record is only valid under the key it was signed for, and only opens for its destination and epoch
+	other := DestKey("other", epoch)
+	assert.Equal(t, errors.Is(validator.Validate(other, value), ErrInvalidDestRecord), true)
+	_, _, err = OpenDestRecord("other", epoch, value)
+	assert.Equal(t, errors.Is(err, ErrInvalidDestRecord), true)
+	_, _, err = OpenDestRecord(desHash, epoch+1, value)
+	assert.Equal(t, errors.Is(err, ErrInvalidDestRecord), true)
+
+	//a tampered record is rejected by the DHT
+	var sealed pb.SealedDestRecord
+	proto.Unmarshal(value, &sealed)
+	sealed.Cypher[len(sealed.Cypher)-1] ^= 1
+	tampered, _ := proto.Marshal(&sealed)
+	assert.Equal(t, errors.Is(validator.Validate(key, tampered), ErrInvalidDestRecord), true)
+
+	expired, _ := NewDestRecord(des, delegation, epoch, priv, nil, time.Now().Add(-time.Minute))
+	assert.Equal(t, errors.Is(validator.Validate(key, expired), ErrInvalidDestRecord), true)
+	tooLong, _ := NewDestRecord(des, delegation, epoch, priv, nil, time.Now().Add(3*common.DestRecordEpoch))
+	assert.Equal(t, errors.Is(validator.Validate(key, tooLong), ErrInvalidDestRecord), true)
+
+	later, _ := NewDestRecord(des, delegation, epoch, priv, nil, time.Now().Add(time.Hour))
+	i, err := validator.Select(key, [][]byte{expired, value, later})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, i, 2)
+	_, err = validator.Select(key, [][]byte{expired})
+	assert.Equal(t, err, ErrInvalidDestRecord)
+}
+
+func TestDestDelegation(t *testing.T) {
+	priv, _, _ := crypto.GenerateEd25519Key(cr.Reader)
+	id, _ := peer.IDFromPrivateKey(priv)
+	epoch := destEpoch(time.Now())
+	destPriv, des, delegation := newTestDest(t, id, epoch)
+	desHash := des.Hash()
+	value, err := NewDestRecord(des, delegation, epoch, priv, nil, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//anyone who saw the destination hash can seal a record naming itself, but cannot show a delegation
+	attackerPriv, _, _ := crypto.GenerateEd25519Key(cr.Reader)
+	attacker, _ := peer.IDFromPrivateKey(attackerPriv)
+	hijacked := map[string][]byte{}
+	hijacked["copied delegation"], _ = NewDestRecord(des, delegation, epoch, attackerPriv, nil, time.Now().Add(time.Hour))
+	hijacked["no delegation"], _ = NewDestRecord(des, nil, epoch, attackerPriv, nil, time.Now().Add(time.Hour))
+	otherEpoch, _ := destPriv.Sign(proxy.DelegationPayload(desHash, attacker, epoch+1))
+	hijacked["delegation of another epoch"], _ = NewDestRecord(des, otherEpoch, epoch, attackerPriv, nil, time.Now().Add(time.Hour))
+	for name, value := range hijacked {
+		_, _, err := OpenDestRecord(desHash, epoch, value)
+		if !errors.Is(err, ErrInvalidDestRecord) {
+			t.Fatalf("%v: expect %v, got %v", name, ErrInvalidDestRecord, err)
+		}
+	}
+
+	//a node that looks the destination up prefers the delegated record, even if it expires first
+	key := DestKey(desHash, epoch)
+	values := [][]byte{hijacked["copied delegation"], value}
+	i, err := DestValidator{}.Select(key, values)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, i, 0)
+	validator := NewDestValidator()
+	key = validator.lookups.add(desHash, epoch)
+	i, err = validator.Select(key, values)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, i, 1)
+	validator.lookups.done(key)
+	_, _, known := validator.lookups.get(key)
+	assert.Equal(t, known, false)
+}
+
+func TestDestCache(t *testing.T) {
+	c := newDestCache()
+	c.put("des", "proxy", time.Now().Add(time.Hour))
+	proxyId, ok := c.get("des")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, proxyId, peer.ID("proxy"))
+	//entries are kept for at most DestCacheTTL even if the record lasts longer
+	assert.Equal(t, c.entries["des"].until.After(time.Now().Add(common.DestCacheTTL)), false)
+
+	c.put("expired", "proxy", time.Now().Add(-time.Second))
+	_, ok = c.get("expired")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, len(c.entries), 1)
+
+	//a full cache drops the entry that expires first
+	for i := 1; i < common.DestCacheSize; i++ {
+		c.put(fmt.Sprint(i), "proxy", time.Now().Add(time.Minute+time.Duration(i)*time.Millisecond))
+	}
+	assert.Equal(t, len(c.entries), common.DestCacheSize)
+	c.put("new", "proxy", time.Now().Add(time.Minute))
+	assert.Equal(t, len(c.entries), common.DestCacheSize)
+	_, ok = c.get("new")
+	assert.Equal(t, ok, true)
+	_, ok = c.get("1")
+	assert.Equal(t, ok, false)
+	_, ok = c.get("des")
+	assert.Equal(t, ok, true)
+}
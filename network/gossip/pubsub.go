@@ -6,18 +6,23 @@ import (
 	"github.com/AsynkronIT/protoactor-go/actor"
 	core "github.com/libp2p/go-libp2p-core"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	pubsub_pb "github.com/libp2p/go-libp2p-pubsub/pb"
 	"github.com/mr-tron/base58"
 	"google.golang.org/protobuf/proto"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
 	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/network/peerdb"
 	"github.com/Evanesco-Labs/WhiteNoise/protocol/command"
 	"github.com/Evanesco-Labs/WhiteNoise/protocol/proxy"
 	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
@@ -31,6 +36,7 @@ type DHTService struct {
 	ps         *pubsub.PubSub
 	noiseTopic *pubsub.Topic
 	noiseSub   *pubsub.Subscription
+	validator  *negValidator
 	dht        *kaddht.IpfsDHT
 	proxyPid   *actor.PID
 	relayPid   *actor.PID
@@ -38,6 +44,11 @@ type DHTService struct {
 	gossipPid  *actor.PID
 	host       host.Host
 	RetryTimes int
+	PeerDB     *peerdb.PeerDB //known peers the node rejoins through when no bootstrap peer is reachable
+	Lookup     config.LookupMode
+	destCache  *destCache
+	//destinations the DHT validator opens records of while they are looked up or published
+	destLookups *destLookups
 }
 
 func (service *DHTService) Dht() *kaddht.IpfsDHT {
@@ -45,7 +56,13 @@ func (service *DHTService) Dht() *kaddht.IpfsDHT {
 }
 
 func NewDHTService(ctx context.Context, actCtx *actor.RootContext, cfg *config.NetworkConfig, host core.Host, dht *kaddht.IpfsDHT) (*DHTService, error) {
-	ps, err := pubsub.NewGossipSub(ctx, host, pubsub.WithNoAuthor(), pubsub.WithMessageIdFn(MessageID))
+	validator := newNegValidator(host.ID())
+	ps, err := pubsub.NewGossipSub(ctx, host, pubsub.WithNoAuthor(), pubsub.WithMessageIdFn(MessageID), peerScoreOptions(validator))
+	if err != nil {
+		log.Error("NewPubsubService err: ", err)
+		return nil, err
+	}
+	err = ps.RegisterTopicValidator(NoiseTopic, validator.Validate, pubsub.WithValidatorInline(true))
 	if err != nil {
 		log.Error("NewPubsubService err: ", err)
 		return nil, err
@@ -63,21 +80,25 @@ func NewDHTService(ctx context.Context, actCtx *actor.RootContext, cfg *config.N
 	}
 
 	pubsubService := &DHTService{
-		actorCtx:   actCtx,
-		ctx:        ctx,
-		ps:         ps,
-		noiseTopic: noiseTopic,
-		noiseSub:   noiseSub,
-		dht:        dht,
-		host:       host,
-		RetryTimes: common.RetryTimes,
+		actorCtx:    actCtx,
+		ctx:         ctx,
+		ps:          ps,
+		noiseTopic:  noiseTopic,
+		noiseSub:    noiseSub,
+		validator:   validator,
+		dht:         dht,
+		host:        host,
+		RetryTimes:  common.RetryTimes,
+		Lookup:      cfg.Lookup,
+		destCache:   newDestCache(),
+		destLookups: destLookupsOf(dht),
 	}
 
 	return pubsubService, nil
 }
 
 func (service *DHTService) Start(cfg *config.NetworkConfig) {
-	err := service.InitDHT([]string{cfg.BootStrapPeers})
+	err := service.InitDHT(cfg.BootStrapPeers)
 	if err != nil {
 		panic(err)
 	}
@@ -86,6 +107,10 @@ func (service *DHTService) Start(cfg *config.NetworkConfig) {
 	})
 	if cfg.Mode == config.ServerMode {
 		go service.handleNoiseMsg(service.ctx)
+		if service.Lookup == config.LookupDHT {
+			service.host.SetStreamHandler(protocol.ID(NegProtocol), service.NegStreamHandler)
+			go service.publishDests(service.ctx)
+		}
 	}
 	service.gossipPid = service.actorCtx.Spawn(props)
 }
@@ -100,9 +125,31 @@ func (service *DHTService) SetPid(proxyPid, relayPid, cmdPid *actor.PID) {
 	service.cmdPid = cmdPid
 }
 
+//InitDHT connects to the bootstrap peers in random order until one is reachable, and to the peers it knew before the
+//restart, then bootstraps the DHT from them. If none is reachable the DHT keeps trying the bootstrap list on its own.
 func (service *DHTService) InitDHT(bootStrapPeers []string) error {
+	bootpeers, err := config.ParseBootstrapPeers(bootStrapPeers)
+	if err != nil {
+		return err
+	}
+	connected := false
+	for _, info := range config.ShuffleBootstrapPeers(bootpeers) {
+		if err := service.connect(info); err != nil {
+			log.Warnf("connect to bootstrap peer %v err %v", info.ID, err)
+			continue
+		}
+		connected = true
+		break
+	}
+	if service.PeerDB != nil && service.seedKnownPeers() {
+		connected = true
+	}
+	if !connected && len(bootpeers) != 0 {
+		log.Error("no bootstrap or known peer reachable")
+	}
+
 	c := context.Background()
-	err := service.dht.Bootstrap(c)
+	err = service.dht.Bootstrap(c)
 	if err != nil {
 		return err
 	}
@@ -110,6 +157,48 @@ func (service *DHTService) InitDHT(bootStrapPeers []string) error {
 	return nil
 }
 
+//connect dials info and keeps it in the peerstore if it was reachable.
+func (service *DHTService) connect(info peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(service.ctx, common.BootstrapConnectTimeout)
+	defer cancel()
+	err := service.host.Connect(ctx, info)
+	if err != nil || service.PeerDB == nil {
+		return err
+	}
+	for _, conn := range service.host.Network().ConnsToPeer(info.ID) {
+		if conn.Stat().Direction == network.DirOutbound {
+			service.PeerDB.Seen(info.ID, conn.RemoteMultiaddr())
+		}
+	}
+	return nil
+}
+
+//seedKnownPeers connects to the most reliable stored peers at once, the DHT adds them to its routing table. It
+//reports if any of them was reachable.
+func (service *DHTService) seedKnownPeers() bool {
+	seeds := service.PeerDB.Seeds(common.PeerStoreSeedCount)
+	var wg sync.WaitGroup
+	var reached int32
+	for _, info := range seeds {
+		if info.ID == service.host.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			if err := service.connect(info); err != nil {
+				log.Debugf("connect to known peer %v err %v", info.ID, err)
+				service.PeerDB.Failed(info.ID)
+				return
+			}
+			atomic.AddInt32(&reached, 1)
+		}(info)
+	}
+	wg.Wait()
+	log.Infof("reached %v of %v known peers", reached, len(seeds))
+	return reached > 0
+}
+
 func (service *DHTService) NoisePublish(data []byte) error {
 	return service.noiseTopic.Publish(service.ctx, data)
 }
@@ -146,17 +235,24 @@ func (service *DHTService) handleNoiseMsg(ctx context.Context) {
 			log.Errorf("Unmarshall gossip error: %v", err)
 			continue
 		}
-		fut := service.actorCtx.RequestFuture(service.proxyPid, proxy.ReqGetClient{Destination: neg.Des}, common.RequestFutureDuration)
-		res, err := fut.Result()
-		clientInfo := res.(proxy.ResGetClient).Info
-		ok := res.(proxy.ResGetClient).Ok
-		if ok {
+		if clientInfo, ok := service.getClient(neg.Des); ok {
 			log.Debugf("Handling gossip for my client %v", clientInfo.PeerID.String())
 			go service.handleGossipMsg(clientInfo, &neg)
 		}
 	}
 }
 
+//getClient asks the proxy if the destination is one of its clients.
+func (service *DHTService) getClient(desHash string) (proxy.ClientInfo, bool) {
+	fut := service.actorCtx.RequestFuture(service.proxyPid, proxy.ReqGetClient{Destination: desHash}, common.RequestFutureDuration)
+	res, err := fut.Result()
+	if err != nil {
+		log.Error(err)
+		return proxy.ClientInfo{}, false
+	}
+	return res.(proxy.ResGetClient).Info, res.(proxy.ResGetClient).Ok
+}
+
 func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *pb.EncryptedNeg) {
 	fut := service.actorCtx.RequestFuture(service.proxyPid, proxy.ReqDecrypt{
 		CipherText: negEnc.Cypher,
@@ -172,13 +268,43 @@ func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *
 		log.Error(neg.Err)
 		return
 	}
+	if neg.Hops == 0 {
+		neg.Hops = common.DefaultRelayHops
+	}
+	if neg.Hops < 0 || neg.Hops > common.MaxRelayHops || neg.MixHops < 0 || neg.MixHops > neg.Hops {
+		log.Errorf("Invalid relay hops %v, %v mixing", neg.Hops, neg.MixHops)
+		return
+	}
+
+	joinNode, err := peer.Decode(neg.Join)
+	if err != nil {
+		log.Errorf("Decode joint node err %v", err)
+		return
+	}
+	//a joint without relay hops to the exit side has nothing to extend
+	jointExit := joinNode == service.host.ID() && neg.Hops <= common.DefaultRelayHops && neg.MixHops == 0
+
+	//local session for this circuit, the same as the joint session if this node is also the joint node and exit.
+	//An exit that is the joint but needs relay hops gets a session of its own, the last relay joins it back to the
+	//joint session by the cookie like with any other joint.
+	sessionId := relay.NewSessionKey()
+	if joinNode != service.host.ID() || jointExit {
+		fut = service.actorCtx.RequestFuture(service.relayPid, relay.ReqRendezvous{Cookie: neg.Cookie}, common.RequestFutureDuration)
+		res, err = fut.Result()
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		sessionId = res.(relay.ResRendezvous).SessionId
+	}
 
 	//new session to answer role
 	fut = service.actorCtx.RequestFuture(service.relayPid, relay.ReqNewSessiontoPeer{
 		PeerID:    clientInfo.PeerID,
-		SessionID: neg.SessionId,
+		SessionID: sessionId,
 		MyRole:    common.ExitRole,
 		OtherRole: common.AnswerRole,
+		Cookie:    neg.Cookie,
 	}, common.RequestFutureDuration)
 
 	res, err = fut.Result()
@@ -189,19 +315,18 @@ func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *
 	resErr := res.(relay.ResError).Err
 	if resErr != nil {
 		log.Errorf("New session to destination err:%v", resErr)
-		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: neg.SessionId})
+		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
 		return
 	}
 
-	joinNode, err := peer.Decode(neg.Join)
 	var relayId core.PeerID
 
-	if joinNode == service.host.ID() {
+	if jointExit {
 		log.Info("act as both joint and exit")
 		log.Debug("send circuit success signal")
-		msg := relay.NewCircuitSuccess(neg.SessionId)
+		msg := relay.NewCircuitSuccess()
 		fut = service.actorCtx.RequestFuture(service.relayPid, relay.ReqSendRelay{
-			SessionId: neg.SessionId,
+			SessionId: sessionId,
 			Data:      msg,
 		}, common.RequestFutureDuration)
 		res, err = fut.Result()
@@ -227,6 +352,10 @@ func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *
 			startIndex++
 			index := startIndex % len(peers)
 			id := peers[index].ID
+			//the mixing hops are the first relays from the exit
+			if neg.MixHops > 0 && !relay.SupportsMix(service.host, id) {
+				continue
+			}
 			if _, ok := invalid[id.String()]; !ok && id != joinNode && id != service.host.ID() {
 				relayId = id
 				break
@@ -235,7 +364,7 @@ func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *
 		//try set new session to relay
 		fut = service.actorCtx.RequestFuture(service.relayPid, relay.ReqNewSessiontoPeer{
 			PeerID:    relayId,
-			SessionID: neg.SessionId,
+			SessionID: sessionId,
 			MyRole:    common.ExitRole,
 			OtherRole: common.RelayRole,
 		}, common.RequestFutureDuration)
@@ -256,16 +385,31 @@ func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *
 
 	if !tryRelaySuccess {
 		log.Errorf("No valid node for relay role err %v", err)
-		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: neg.SessionId})
+		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
 		return
 	}
 	log.Debugf("Chose relay node %v", relayId)
-	//expend relay node to joint node
+	if neg.MixHops > 0 {
+		neg.MixHops--
+	}
+	//only the joint may read the cookie, the relays get it sealed and derive their probes from the exit probe key
+	sealedCookie, err := relay.SealCookie(service.host.Peerstore().PubKey(joinNode), neg.Cookie)
+	if err != nil {
+		log.Errorf("Seal cookie err %v", err)
+		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
+		return
+	}
+	probeKey := relay.ExitProbeKey(neg.Cookie)
+	//expend relay node to joint node, the relay nodes extend the rest of the hops one by one
 	fut = service.actorCtx.RequestFuture(service.cmdPid, command.ReqExpendSession{
-		Relay:     relayId,
-		Joint:     joinNode,
-		SessionId: neg.SessionId,
-	}, common.RequestFutureDuration)
+		Relay:        relayId,
+		Joint:        joinNode,
+		SessionId:    sessionId,
+		Hops:         neg.Hops - 1,
+		MixHops:      neg.MixHops,
+		SealedCookie: sealedCookie,
+		ProbeKey:     probeKey,
+	}, common.ExpendSessionTimeout*time.Duration(neg.Hops)+common.RequestFutureDuration)
 	res, err = fut.Result()
 	if err != nil {
 		log.Error(err)
@@ -274,21 +418,16 @@ func (service *DHTService) handleGossipMsg(clientInfo proxy.ClientInfo, negEnc *
 	resErr = res.(command.ResError).Err
 	if resErr != nil {
 		log.Errorf("Expend session err %v", resErr)
-		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: neg.SessionId})
+		service.actorCtx.Request(service.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
 		return
 	}
 
-	log.Infof("set relay node %v for session %v", relayId.String(), neg.SessionId)
+	log.Infof("set relay node %v", relayId.String())
 
 	//send probe signal to joint node
-	relayData, err := relay.NewProbeSignal(neg.SessionId)
-	if err != nil {
-		log.Errorf("NewProbeSignal err %v", err)
-	}
-
-	fut = service.actorCtx.RequestFuture(service.relayPid, relay.ReqSendRelay{
-		SessionId: neg.SessionId,
-		Data:      relayData,
+	fut = service.actorCtx.RequestFuture(service.relayPid, relay.ReqSendProbe{
+		SessionId: sessionId,
+		Key:       probeKey,
 	}, common.RequestFutureDuration)
 	res, err = fut.Result()
 	if err != nil {
@@ -0,0 +1,241 @@
+package gossip
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/mr-tron/base58"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidNeg  = errors.New("invalid negotiation")
+	ErrRateLimited = errors.New("negotiation rate limit exceeded")
+)
+
+//score penalty of a peer for every invalid negotiation it sent straight to us, it halves every penaltyHalfLife
+const (
+	invalidPenalty  = 10
+	penaltyHalfLife = time.Minute
+)
+
+//rate limits of peers that are idle and not penalized are dropped this often
+const limitPruneInterval = time.Minute
+
+//checkNeg checks the structure of an encrypted negotiation: the destination is a WhiteNoiseID hash and the ciphertext
+//is not empty.
+func checkNeg(neg *pb.EncryptedNeg) error {
+	des, err := base58.Decode(neg.Des)
+	if err != nil || len(des) != sha256.Size {
+		return fmt.Errorf("%w: bad destination %q", ErrInvalidNeg, neg.Des)
+	}
+	if len(neg.Cypher) == 0 {
+		return fmt.Errorf("%w: empty ciphertext", ErrInvalidNeg)
+	}
+	return nil
+}
+
+//parseNeg reads an encrypted negotiation and checks its size and structure.
+func parseNeg(data []byte) (*pb.EncryptedNeg, error) {
+	if len(data) > common.GossipMaxNegSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidNeg, len(data))
+	}
+	var neg pb.EncryptedNeg
+	if err := proto.Unmarshal(data, &neg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNeg, err)
+	}
+	if err := checkNeg(&neg); err != nil {
+		return nil, err
+	}
+	return &neg, nil
+}
+
+//seenCache remembers the hashes of the last size ciphertexts, the oldest is forgotten first.
+type seenCache struct {
+	mut    sync.Mutex
+	hashes map[[sha256.Size]byte]struct{}
+	ring   [][sha256.Size]byte
+	next   int
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		hashes: make(map[[sha256.Size]byte]struct{}, size),
+		ring:   make([][sha256.Size]byte, 0, size),
+	}
+}
+
+//add reports if cypher was not seen before and remembers it.
+func (c *seenCache) add(cypher []byte) bool {
+	hash := sha256.Sum256(cypher)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if _, ok := c.hashes[hash]; ok {
+		return false
+	}
+	if len(c.ring) < cap(c.ring) {
+		c.ring = append(c.ring, hash)
+	} else {
+		delete(c.hashes, c.ring[c.next])
+		c.ring[c.next] = hash
+		c.next = (c.next + 1) % len(c.ring)
+	}
+	c.hashes[hash] = struct{}{}
+	return true
+}
+
+//peerLimit is the token bucket of a peer and the penalty it earned by sending invalid negotiations straight to us.
+type peerLimit struct {
+	tokens  float64
+	penalty float64
+	last    time.Time
+}
+
+//update refills the bucket and decays the penalty for the time passed since the last update.
+func (l *peerLimit) update(now time.Time, rate, burst float64) {
+	elapsed := now.Sub(l.last)
+	l.tokens = math.Min(burst, l.tokens+elapsed.Seconds()*rate)
+	l.penalty *= math.Pow(0.5, float64(elapsed)/float64(penaltyHalfLife))
+	l.last = now
+}
+
+//negValidator validates negotiations on the noise topic before they are delivered or forwarded. Malformed ones are
+//rejected, which counts against the forwarding peer in gossipsub peer scoring. Replays and negotiations above the
+//rate limit of the forwarding peer are ignored without a penalty, as honest peers forward floods of others too.
+//Negotiations sent straight to us are limited the same way, malformed ones are penalized through the application score.
+type negValidator struct {
+	self      peer.ID
+	seen      *seenCache
+	rate      float64
+	burst     float64
+	mut       sync.Mutex
+	limits    map[peer.ID]*peerLimit
+	lastPrune time.Time
+}
+
+func newNegValidator(self peer.ID) *negValidator {
+	return &negValidator{
+		self:      self,
+		seen:      newSeenCache(common.GossipSeenCacheSize),
+		rate:      common.GossipPeerRate,
+		burst:     common.GossipPeerBurst,
+		limits:    make(map[peer.ID]*peerLimit),
+		lastPrune: time.Now(),
+	}
+}
+
+func (v *negValidator) Validate(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	neg, err := parseNeg(msg.Data)
+	if err != nil {
+		return pubsub.ValidationReject
+	}
+	if from != v.self && !v.allow(from) {
+		return pubsub.ValidationIgnore
+	}
+	if !v.seen.add(neg.Cypher) {
+		return pubsub.ValidationIgnore
+	}
+	return pubsub.ValidationAccept
+}
+
+//validateDirect validates a negotiation the peer from sent straight to us as the proxy of its destination.
+func (v *negValidator) validateDirect(from peer.ID, data []byte) (*pb.EncryptedNeg, error) {
+	neg, err := parseNeg(data)
+	if err != nil {
+		v.penalize(from)
+		return nil, err
+	}
+	if !v.allow(from) {
+		return nil, ErrRateLimited
+	}
+	return neg, nil
+}
+
+//allow takes a token from the bucket of the peer.
+func (v *negValidator) allow(id peer.ID) bool {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	limit := v.limit(id, time.Now())
+	if limit.tokens < 1 {
+		return false
+	}
+	limit.tokens--
+	return true
+}
+
+func (v *negValidator) penalize(id peer.ID) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.limit(id, time.Now()).penalty += invalidPenalty
+}
+
+//limit returns the updated limit of the peer, mut must be held.
+func (v *negValidator) limit(id peer.ID, now time.Time) *peerLimit {
+	v.prune(now)
+	limit, ok := v.limits[id]
+	if !ok {
+		limit = &peerLimit{tokens: v.burst, last: now}
+		v.limits[id] = limit
+	}
+	limit.update(now, v.rate, v.burst)
+	return limit
+}
+
+func (v *negValidator) prune(now time.Time) {
+	if now.Sub(v.lastPrune) < limitPruneInterval {
+		return
+	}
+	v.lastPrune = now
+	for id, limit := range v.limits {
+		limit.update(now, v.rate, v.burst)
+		if limit.tokens == v.burst && limit.penalty < 1 {
+			delete(v.limits, id)
+		}
+	}
+}
+
+//Score is the application specific score of a peer, the negative of its penalty for invalid negotiations.
+func (v *negValidator) Score(id peer.ID) float64 {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	limit, ok := v.limits[id]
+	if !ok {
+		return 0
+	}
+	limit.update(time.Now(), v.rate, v.burst)
+	return -limit.penalty
+}
+
+//peerScoreOptions scores peers on the noise topic by the invalid negotiations they forward and by the application
+//score of the validator for the ones they sent straight to us. A few invalid negotiations stop gossip with a peer, a flood graylists it.
+func peerScoreOptions(v *negValidator) pubsub.Option {
+	params := &pubsub.PeerScoreParams{
+		Topics: map[string]*pubsub.TopicScoreParams{
+			NoiseTopic: {
+				TopicWeight:                    1,
+				TimeInMeshQuantum:              time.Second,
+				InvalidMessageDeliveriesWeight: -100,
+				InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(10 * time.Minute),
+			},
+		},
+		AppSpecificScore:  v.Score,
+		AppSpecificWeight: 1,
+		DecayInterval:     pubsub.DefaultDecayInterval,
+		DecayToZero:       pubsub.DefaultDecayToZero,
+		RetainScore:       time.Hour,
+	}
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:   -500,
+		PublishThreshold:  -1000,
+		GraylistThreshold: -2500,
+	}
+	return pubsub.WithPeerScore(params, thresholds)
+}
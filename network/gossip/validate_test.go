@@ -0,0 +1,97 @@
+package gossip
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsub_pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/magiconair/properties/assert"
+	"github.com/mr-tron/base58"
+	"google.golang.org/protobuf/proto"
+	"strconv"
+	"testing"
+)
+
+func negMessage(t *testing.T, des string, cypher []byte) *pubsub.Message {
+	data, err := proto.Marshal(&pb.EncryptedNeg{Des: des, Cypher: cypher})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pubsub.Message{Message: &pubsub_pb.Message{Data: data}}
+}
+
+func TestSeenCache(t *testing.T) {
+	c := newSeenCache(2)
+	assert.Equal(t, c.add([]byte("a")), true)
+	assert.Equal(t, c.add([]byte("a")), false)
+	assert.Equal(t, c.add([]byte("b")), true)
+	assert.Equal(t, c.add([]byte("c")), true)
+	//a is the oldest and was forgotten
+	assert.Equal(t, c.add([]byte("a")), true)
+	assert.Equal(t, c.add([]byte("c")), false)
+	assert.Equal(t, len(c.hashes), 2)
+}
+
+func TestNegValidator(t *testing.T) {
+	self, _ := peer.Decode("QmdLEFWxMNZ5dKGKNn8tJHZG2RDnMXrzBkp94heQeUZYCr")
+	from, _ := peer.Decode("QmXkCpR1CtDqPWQ7RrUgSg2aikFPMFbk8oZYo1wRn3wLGH")
+	v := newNegValidator(self)
+	hash := sha256.Sum256([]byte("des"))
+	des := base58.Encode(hash[:])
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		msg  *pubsub.Message
+		want pubsub.ValidationResult
+	}{
+		{"valid", negMessage(t, des, []byte("cypher")), pubsub.ValidationAccept},
+		{"replay", negMessage(t, des, []byte("cypher")), pubsub.ValidationIgnore},
+		{"garbage", &pubsub.Message{Message: &pubsub_pb.Message{Data: []byte{0xff, 0xff}}}, pubsub.ValidationReject},
+		{"bad destination", negMessage(t, "des", []byte("other")), pubsub.ValidationReject},
+		{"empty ciphertext", negMessage(t, des, nil), pubsub.ValidationReject},
+		{"too large", negMessage(t, des, make([]byte, common.GossipMaxNegSize)), pubsub.ValidationReject},
+	}
+	for _, test := range tests {
+		assert.Equal(t, v.Validate(ctx, from, test.msg), test.want, test.name)
+	}
+	assert.Equal(t, v.Score(from), float64(0))
+
+	//the burst is used up, a flooding peer is ignored but not penalized, our own negotiations are not limited
+	for i := 0; i < common.GossipPeerBurst; i++ {
+		v.Validate(ctx, from, negMessage(t, des, []byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, v.Validate(ctx, from, negMessage(t, des, []byte("flood"))), pubsub.ValidationIgnore)
+	assert.Equal(t, v.Score(from), float64(0))
+	assert.Equal(t, v.Validate(ctx, self, negMessage(t, des, []byte("flood"))), pubsub.ValidationAccept)
+}
+
+func TestNegValidatorDirect(t *testing.T) {
+	self, _ := peer.Decode("QmdLEFWxMNZ5dKGKNn8tJHZG2RDnMXrzBkp94heQeUZYCr")
+	from, _ := peer.Decode("QmXkCpR1CtDqPWQ7RrUgSg2aikFPMFbk8oZYo1wRn3wLGH")
+	v := newNegValidator(self)
+	hash := sha256.Sum256([]byte("des"))
+	des := base58.Encode(hash[:])
+
+	_, err := v.validateDirect(from, negMessage(t, des, []byte("cypher")).Data)
+	assert.Equal(t, err, nil)
+
+	//the direct path shares the rate limit of the peer with the noise topic, exceeding it is not penalized
+	for i := 1; i < common.GossipPeerBurst; i++ {
+		v.Validate(context.Background(), from, negMessage(t, des, []byte(strconv.Itoa(i))))
+	}
+	_, err = v.validateDirect(from, negMessage(t, des, []byte("flood")).Data)
+	assert.Equal(t, err, ErrRateLimited)
+	assert.Equal(t, v.Score(from), float64(0))
+
+	//invalid negotiations are, as gossipsub does not score the direct path
+	_, err = v.validateDirect(from, []byte{0xff, 0xff})
+	assert.Equal(t, errors.Is(err, ErrInvalidNeg), true)
+	_, err = v.validateDirect(from, negMessage(t, des, make([]byte, common.GossipMaxNegSize)).Data)
+	assert.Equal(t, errors.Is(err, ErrInvalidNeg), true)
+	assert.Equal(t, v.Score(from) < 0, true)
+}
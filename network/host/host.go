@@ -6,12 +6,12 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
-	"github.com/libp2p/go-libp2p-core/peer"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
 	noise "github.com/libp2p/go-libp2p-noise"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/network/gossip"
 )
 
 func NewHost(ctx context.Context, cfg *config.NetworkConfig, priv crypto.PrivKey) (host.Host, *kaddht.IpfsDHT, error) {
@@ -19,16 +19,12 @@ func NewHost(ctx context.Context, cfg *config.NetworkConfig, priv crypto.PrivKey
 	if err != nil {
 		return nil, nil, err
 	}
-	bootpeers := make([]peer.AddrInfo, 0)
-	if cfg.BootStrapPeers != "" {
-		maddr, err := multiaddr.NewMultiaddr(cfg.BootStrapPeers)
-		if err != nil {
-			log.Errorf("Parse bootstrap node address err %v", err)
-			return nil, nil, err
-		}
-		bootinfo, _ := peer.AddrInfoFromP2pAddr(maddr)
-		bootpeers = append(bootpeers, *bootinfo)
+	bootpeers, err := config.ParseBootstrapPeers(cfg.BootStrapPeers)
+	if err != nil {
+		log.Errorf("Parse bootstrap node address err %v", err)
+		return nil, nil, err
 	}
+	bootpeers = config.ShuffleBootstrapPeers(bootpeers)
 
 	var h host.Host
 	if cfg.Mode == config.ClientMode {
@@ -54,7 +50,7 @@ func NewHost(ctx context.Context, cfg *config.NetworkConfig, priv crypto.PrivKey
 		if err != nil {
 			return nil, nil, err
 		}
-		dht, err := kaddht.New(ctx, h, kaddht.Mode(kaddht.ModeAutoServer), kaddht.ProtocolPrefix("/whitenoise_dht"))
+		dht, err := kaddht.New(ctx, h, kaddht.Mode(kaddht.ModeAutoServer), kaddht.ProtocolPrefix("/whitenoise_dht"), kaddht.NamespacedValidator(gossip.DestNamespace, gossip.NewDestValidator()))
 		if err != nil {
 			return nil, nil, err
 		}
@@ -71,7 +67,7 @@ func NewHost(ctx context.Context, cfg *config.NetworkConfig, priv crypto.PrivKey
 	if err != nil {
 		return nil, nil, err
 	}
-	dht, err := kaddht.New(ctx, h, kaddht.Mode(kaddht.ModeAutoServer), kaddht.ProtocolPrefix("/whitenoise_dht"), kaddht.BootstrapPeers(bootpeers...))
+	dht, err := kaddht.New(ctx, h, kaddht.Mode(kaddht.ModeAutoServer), kaddht.ProtocolPrefix("/whitenoise_dht"), kaddht.BootstrapPeers(bootpeers...), kaddht.NamespacedValidator(gossip.DestNamespace, gossip.NewDestValidator()))
 	if err != nil {
 		return nil, nil, err
 	}
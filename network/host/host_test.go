@@ -8,16 +8,19 @@ import (
 )
 
 func TestNewHost(t *testing.T) {
-	acc := account.GetAccount(1)
+	acc, err := account.GetAccount(1, []byte("whitenoise"))
+	if err != nil {
+		t.Fatal(err)
+	}
 	priv := acc.GetP2PPrivKey()
 	cfg := config.NetworkConfig{
 		RendezvousString: "whitenoise",
 		ListenHost:       "127.0.0.1",
 		ListenPort:       3331,
-		BootStrapPeers:   "",
+		BootStrapPeers:   nil,
 		Mode:             config.BootMode,
 	}
-	_, _, err := NewHost(context.Background(), &cfg, priv)
+	_, _, err = NewHost(context.Background(), &cfg, priv)
 	if err != nil {
 		t.Fatal(err)
 	}
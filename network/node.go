@@ -10,12 +10,14 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/network/gossip"
 	"github.com/Evanesco-Labs/WhiteNoise/network/host"
 	"github.com/Evanesco-Labs/WhiteNoise/network/noise"
+	"github.com/Evanesco-Labs/WhiteNoise/network/peerdb"
 	core "github.com/libp2p/go-libp2p-core"
 )
 
 type Node struct {
 	NoiseService *noise.NoiseService
 	DHTService   *gossip.DHTService
+	PeerDB       *peerdb.PeerDB
 }
 
 func NewNode(ctx context.Context, cfg *config.NetworkConfig, acc *account.Account) (*Node, error) {
@@ -30,23 +32,34 @@ func NewNode(ctx context.Context, cfg *config.NetworkConfig, acc *account.Accoun
 	if err != nil {
 		return nil, err
 	}
+	var pdb *peerdb.PeerDB
+	if cfg.PeerStorePath != "" {
+		pdb, err = peerdb.Open(cfg.PeerStorePath)
+		if err != nil {
+			log.Warnf("open peerstore %v err %v, known peers are not kept", cfg.PeerStorePath, err)
+		}
+	}
 	system := actor.NewActorSystem()
 	noiseService, err := noise.NewNoiseService(ctx, system.Root, cfg, h, priv, acc)
 	if err != nil {
 		return nil, err
 	}
+	noiseService.PeerDB = pdb
 	if cfg.Mode == config.ClientMode {
 		return &Node{
 			NoiseService: noiseService,
+			PeerDB:       pdb,
 		}, nil
 	}
 	pubsubService, err := gossip.NewDHTService(ctx, system.Root, cfg, h, dht)
 	if err != nil {
 		return nil, err
 	}
+	pubsubService.PeerDB = pdb
 	return &Node{
 		NoiseService: noiseService,
 		DHTService:   pubsubService,
+		PeerDB:       pdb,
 	}, nil
 }
 
@@ -0,0 +1,203 @@
+package noise
+
+import (
+	"context"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	core "github.com/libp2p/go-libp2p-core"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"sort"
+	"time"
+)
+
+var ErrNoProxyCandidate = errors.New("no reachable proxy candidate")
+
+//RegisterProxies ranks the candidates by round trip time and registers to the best count of them. The best registered
+//proxy is the primary that new circuits are dialed through, the others keep the client reachable, and the rest are
+//backups that take the place of registered proxies that go away.
+func (service *NoiseService) RegisterProxies(candidates []core.PeerID, count int) error {
+	if count <= 0 {
+		count = 1
+	}
+	//proxies from before the restart are candidates too
+	for _, id := range service.knownPeers(true) {
+		if !containsPeer(candidates, id) {
+			candidates = append(candidates, id)
+		}
+	}
+	ranks := service.rankProxies(candidates)
+	if len(ranks) == 0 {
+		return ErrNoProxyCandidate
+	}
+	service.failoverMut.Lock()
+	defer service.failoverMut.Unlock()
+	service.proxyMut.Lock()
+	service.ProxyRedundancy = count
+	service.proxyRanks = ranks
+	service.proxyMut.Unlock()
+	registered, missing := service.fillProxies()
+	if registered == 0 {
+		return ErrNoProxy
+	}
+	if missing > 0 {
+		//keep trying the candidates that failed in the background
+		go service.failover()
+	}
+	return nil
+}
+
+//Proxies returns the proxies the client is registered at, the primary first.
+func (service *NoiseService) Proxies() []core.PeerID {
+	service.proxyMut.Lock()
+	defer service.proxyMut.Unlock()
+	var proxies []core.PeerID
+	for _, id := range service.proxyRanks {
+		if _, ok := service.proxyLeases[id]; ok {
+			proxies = append(proxies, id)
+		}
+	}
+	return proxies
+}
+
+//rankProxies pings the candidates and returns the reachable ones, fastest first.
+func (service *NoiseService) rankProxies(candidates []core.PeerID) []core.PeerID {
+	type rank struct {
+		id  core.PeerID
+		rtt time.Duration
+	}
+	seen := make(map[core.PeerID]bool)
+	results := make(chan rank, len(candidates))
+	for _, id := range candidates {
+		if seen[id] || id == service.host.ID() {
+			continue
+		}
+		seen[id] = true
+		go func(id core.PeerID) {
+			ctx, cancel := context.WithTimeout(service.ctx, common.ProxyPingTimeout)
+			defer cancel()
+			res, ok := <-ping.Ping(ctx, service.host, id)
+			if !ok || res.Error != nil {
+				log.Debugf("Ping proxy candidate %v err: %v", id, res.Error)
+				results <- rank{id: id, rtt: -1}
+				return
+			}
+			results <- rank{id: id, rtt: res.RTT}
+		}(id)
+	}
+	var ranks []rank
+	for range seen {
+		if r := <-results; r.rtt >= 0 {
+			ranks = append(ranks, r)
+		}
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		return ranks[i].rtt < ranks[j].rtt
+	})
+	ids := make([]core.PeerID, len(ranks))
+	for i, r := range ranks {
+		ids[i] = r.id
+	}
+	return ids
+}
+
+//fillProxies registers to candidates in rank order until ProxyRedundancy proxies are registered, then makes the best
+//registered one the primary. It returns how many proxies are registered and how many are still missing,
+//failoverMut must be held.
+func (service *NoiseService) fillProxies() (int, int) {
+	service.proxyMut.Lock()
+	ranks := append([]core.PeerID(nil), service.proxyRanks...)
+	need := service.ProxyRedundancy - len(service.proxyLeases)
+	service.proxyMut.Unlock()
+	for _, id := range ranks {
+		if need <= 0 {
+			break
+		}
+		service.proxyMut.Lock()
+		_, registered := service.proxyLeases[id]
+		service.proxyMut.Unlock()
+		if registered {
+			continue
+		}
+		lease, err := service.registerAt(id)
+		if err != nil {
+			log.Warnf("Register to proxy %v err: %v", id, err)
+			continue
+		}
+		service.proxyMut.Lock()
+		service.startLease(id, lease)
+		service.proxyMut.Unlock()
+		log.Infof("Register to proxy %v, lease %v", id, lease)
+		need--
+	}
+
+	service.proxyMut.Lock()
+	old := service.ProxyNode
+	service.ProxyNode = ""
+	for _, id := range service.proxyRanks {
+		if _, ok := service.proxyLeases[id]; ok {
+			service.ProxyNode = id
+			break
+		}
+	}
+	primary := service.ProxyNode
+	registered := len(service.proxyLeases)
+	missing := service.ProxyRedundancy - registered
+	service.proxyMut.Unlock()
+	if primary != old {
+		log.Infof("Primary proxy changes from %v to %v", old, primary)
+		service.eventBus.Publish(common.ProxyChangedTopic, old, primary)
+	}
+	return registered, missing
+}
+
+//proxyLost drops the registration at proxyId that went away, and fails over to backups in the background. The lost
+//proxy is ranked last, as it may come back. It reports if proxyId was a proxy of this client.
+func (service *NoiseService) proxyLost(proxyId core.PeerID) bool {
+	service.proxyMut.Lock()
+	stop, ok := service.proxyLeases[proxyId]
+	if ok {
+		stop()
+		delete(service.proxyLeases, proxyId)
+		ranks := make([]core.PeerID, 0, len(service.proxyRanks))
+		for _, id := range service.proxyRanks {
+			if id != proxyId {
+				ranks = append(ranks, id)
+			}
+		}
+		service.proxyRanks = append(ranks, proxyId)
+	}
+	service.proxyMut.Unlock()
+	if !ok {
+		return false
+	}
+	log.Warnf("Lost proxy %v", proxyId)
+	go service.failover()
+	return true
+}
+
+//failover registers to backups until ProxyRedundancy proxies are registered again. Candidates that fail, the lost
+//proxy among them, are tried again every ProxyFailoverRetry.
+func (service *NoiseService) failover() {
+	registered := 0
+	for i := 0; i <= service.proxyManager.RetryTimes; i++ {
+		//not held while waiting, so RegisterProxies and other failovers are not blocked
+		service.failoverMut.Lock()
+		var missing int
+		registered, missing = service.fillProxies()
+		service.failoverMut.Unlock()
+		if missing <= 0 {
+			return
+		}
+		select {
+		case <-service.ctx.Done():
+			return
+		case <-time.After(common.ProxyFailoverRetry):
+		}
+	}
+	if registered == 0 {
+		log.Errorf("No proxy left to fail over to")
+		return
+	}
+	log.Warnf("Registered to %v proxies only, no backup left", registered)
+}
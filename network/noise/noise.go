@@ -15,6 +15,8 @@ import (
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/multiformats/go-multiaddr"
+	"math/rand"
+	"sync"
 	"time"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/common/account"
@@ -22,6 +24,7 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
 	crypto2 "github.com/Evanesco-Labs/WhiteNoise/crypto"
 	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/network/peerdb"
 	"github.com/Evanesco-Labs/WhiteNoise/network/session"
 	"github.com/Evanesco-Labs/WhiteNoise/protocol/ack"
 	"github.com/Evanesco-Labs/WhiteNoise/protocol/command"
@@ -30,19 +33,35 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/secure"
 )
 
+var (
+	ErrNoProxy            = errors.New("no proxy yet")
+	ErrInvalidDestination = errors.New("invalid destination WhiteNoiseID")
+	ErrDestinationRefused = errors.New("destination refused circuit")
+	ErrNewCircuitRejected = errors.New("new circuit rejected")
+	ErrNewCircuitTimeout  = errors.New("new circuit timeout")
+	ErrInvalidRelayHops   = errors.New("invalid relay hops")
+	ErrNoBootstrapPeer    = errors.New("no bootstrap peer")
+)
+
 type NoiseService struct {
-	host          host.Host
-	ctx           context.Context
-	actCtx        *actor.RootContext
-	ackManager    *ack.AckManager
-	proxyManager  *proxy.ProxyManager
-	relayManager  *relay.RelayMsgManager
-	cmdManager    *command.CmdManager
-	ProxyNode     core.PeerID
-	Role          config.ServiceMode
-	Account       *account.Account
-	eventBus      EventBus.Bus
-	BootstrapPeer peer.ID
+	host            host.Host
+	ctx             context.Context
+	actCtx          *actor.RootContext
+	ackManager      *ack.AckManager
+	proxyManager    *proxy.ProxyManager
+	relayManager    *relay.RelayMsgManager
+	cmdManager      *command.CmdManager
+	ProxyNode       core.PeerID //primary proxy, new circuits are dialed through it. Guarded by proxyMut, read it with PrimaryProxy
+	ProxyRedundancy int         //how many proxies the client stays registered at
+	proxyLeases     map[core.PeerID]context.CancelFunc
+	proxyRanks      []core.PeerID //candidate proxies, best first
+	proxyMut        sync.Mutex
+	failoverMut     sync.Mutex
+	Role            config.ServiceMode
+	Account         *account.Account
+	eventBus        EventBus.Bus
+	BootstrapPeers  []peer.ID
+	PeerDB          *peerdb.PeerDB //known peers kept across restarts, nil if not kept
 }
 
 func (service *NoiseService) Host() host.Host {
@@ -82,29 +101,35 @@ func NewNoiseService(ctx context.Context, actCtx *actor.RootContext, cfg *config
 		Role:         cfg.Mode,
 		Account:      acc,
 		eventBus:     eb,
+
+		ProxyRedundancy: 1,
+		proxyLeases:     make(map[core.PeerID]context.CancelFunc),
 	}
+	service.relayManager.LinkCover = cfg.LinkCover
+	service.relayManager.CircuitCover = cfg.CircuitCover
+	service.relayManager.SetMix(cfg.Mix)
 
 	service.host.SetStreamHandler(protocol.ID(ack.ACK_PROTOCOL), service.ackManager.AckStreamHandler)
 	service.host.SetStreamHandler(protocol.ID(proxy.PROXY_PROTOCOL), service.proxyManager.ProxyStreamHandler)
 
 	if cfg.Mode != config.BootMode {
 		service.host.SetStreamHandler(protocol.ID(relay.RelayProtocol), service.relayManager.RelayStreamHandler)
+		if cfg.Mix.Enabled() {
+			//only advertised through identify, relay streams still use RelayProtocol
+			service.host.SetStreamHandler(protocol.ID(relay.MixProtocol), service.relayManager.RelayStreamHandler)
+		}
 		service.host.SetStreamHandler(protocol.ID(command.CMD_PROTOCOL), service.cmdManager.CmdStreamHandler)
 	}
 
-	if cfg.BootStrapPeers == "" {
-		return &service, nil
-	}
-
-	peerAddr := cfg.BootStrapPeers
-	maddr, err := multiaddr.NewMultiaddr(peerAddr)
+	bootpeers, err := config.ParseBootstrapPeers(cfg.BootStrapPeers)
 	if err != nil {
 		log.Errorf("Parse bootstrap node address err %v", err)
 		return nil, err
 	}
-	peerinfo, _ := peer.AddrInfoFromP2pAddr(maddr)
-	service.host.Peerstore().AddAddrs(peerinfo.ID, peerinfo.Addrs, peerstore.PermanentAddrTTL)
-	service.BootstrapPeer = peerinfo.ID
+	for _, peerinfo := range bootpeers {
+		service.host.Peerstore().AddAddrs(peerinfo.ID, peerinfo.Addrs, peerstore.PermanentAddrTTL)
+		service.BootstrapPeers = append(service.BootstrapPeers, peerinfo.ID)
+	}
 	return &service, nil
 }
 
@@ -145,7 +170,7 @@ func (service *NoiseService) Start() {
 func (service *NoiseService) SetPid(gossipPid *actor.PID) {
 	service.proxyManager.SetPid(service.RelayPid(), service.AckPid(), gossipPid)
 	service.relayManager.SetPid(service.AckPid())
-	service.cmdManager.SetPid(service.RelayPid(), service.AckPid())
+	service.cmdManager.SetPid(service.RelayPid(), service.AckPid(), gossipPid)
 }
 
 func (service *NoiseService) SetNotify(h host.Host, cfg *config.NetworkConfig) {
@@ -155,41 +180,182 @@ func (service *NoiseService) SetNotify(h host.Host, cfg *config.NetworkConfig) {
 		proxyPid:      service.ProxyPid(),
 		relayPid:      service.RelayPid(),
 		whiteListMode: cfg.WhiteList,
+		service:       service,
 	}
 	service.Host().Network().Notify(notifiee)
 }
 
+//RegisterProxy registers to proxyId as the only proxy, and keeps renewing the lease until UnRegister or another
+//RegisterProxy. If the proxy goes away the client registers to it again.
 func (service *NoiseService) RegisterProxy(proxyId core.PeerID) error {
-	streamRaw, err := service.host.NewStream(service.ctx, proxyId, protocol.ID(proxy.PROXY_PROTOCOL))
+	lease, err := service.registerAt(proxyId)
 	if err != nil {
 		return err
 	}
-	stream := session.NewStream(streamRaw, service.ctx)
+	service.proxyMut.Lock()
+	for id, stop := range service.proxyLeases {
+		if id != proxyId {
+			stop()
+			delete(service.proxyLeases, id)
+		}
+	}
+	service.proxyRanks = []core.PeerID{proxyId}
+	service.ProxyRedundancy = 1
+	service.startLease(proxyId, lease)
+	service.ProxyNode = proxyId
+	service.proxyMut.Unlock()
+	log.Infof("Register to proxy %v, lease %v", proxyId, lease)
+	return nil
+}
+
+//registerAt proves the WhiteNoiseID to proxyId and registers to it, it returns the lease granted.
+func (service *NoiseService) registerAt(proxyId core.PeerID) (time.Duration, error) {
+	data, err := service.requestProxy(proxyId, pb.Reqtype_ProxyChallenge, &pb.ProxyChallenge{}, service.proxyManager.RegisterProxyTimeout)
+	if err != nil {
+		service.peerFailed(proxyId)
+		return 0, errors.New("register challenge: " + err.Error())
+	}
+	var challenge pb.ProxyChallenge
+	err = proto.Unmarshal(data, &challenge)
+	if err != nil {
+		return 0, err
+	}
+	//never sign data of the proxy's choosing
+	if len(challenge.Nonce) != proxy.ChallengeNonceLength {
+		return 0, proxy.ErrInvalidChallenge
+	}
+	//prove to the proxy that this WhiteNoiseID is ours
+	whiteNoiseID := service.Account.GetPublicKey().GetWhiteNoiseID().String()
+	sig, err := service.Account.GetPrivateKey().Sign(proxy.ChallengePayload(challenge.Nonce, proxyId, whiteNoiseID))
+	if err != nil {
+		return 0, err
+	}
+	//let the proxy publish where we are, so nobody else can claim to be our proxy
+	delegations, err := proxy.NewDelegations(service.Account.GetPrivateKey(), proxyId, proxy.ProxySerivceTime)
+	if err != nil {
+		return 0, err
+	}
 	newProxy := pb.NewProxy{
-		Time:         time.Hour.String(),
-		WhiteNoiseID: service.Account.GetPublicKey().GetWhiteNoiseID().String(),
+		Time:         proxy.ProxySerivceTime.String(),
+		WhiteNoiseID: whiteNoiseID,
+		Sig:          sig,
+		Delegations:  delegations,
 	}
-	data, err := proto.Marshal(&newProxy)
+	data, err = service.requestProxy(proxyId, pb.Reqtype_NewProxy, &newProxy, service.proxyManager.RegisterProxyTimeout)
 	if err != nil {
-		return err
+		return 0, errors.New("register rejected: " + err.Error())
+	}
+	service.rememberPeer(proxyId, true)
+	return parseLease(data, proxy.ProxySerivceTime), nil
+}
+
+//startLease keeps renewing the lease at proxyId, proxyMut must be held.
+func (service *NoiseService) startLease(proxyId core.PeerID, lease time.Duration) {
+	if stop, ok := service.proxyLeases[proxyId]; ok {
+		stop()
+	}
+	ctx, cancel := context.WithCancel(service.ctx)
+	service.proxyLeases[proxyId] = cancel
+	go service.keepLease(ctx, proxyId, lease)
+}
+
+//PrimaryProxy returns the proxy new circuits are dialed through, empty if the client is registered at none.
+func (service *NoiseService) PrimaryProxy() core.PeerID {
+	service.proxyMut.Lock()
+	defer service.proxyMut.Unlock()
+	return service.ProxyNode
+}
+
+//RenewProxy renews the lease at the primary proxy and returns how long it lasts from now.
+func (service *NoiseService) RenewProxy() (time.Duration, error) {
+	proxyId := service.PrimaryProxy()
+	if proxyId == "" {
+		return 0, ErrNoProxy
+	}
+	return service.renewAt(proxyId)
+}
+
+func (service *NoiseService) renewAt(proxyId core.PeerID) (time.Duration, error) {
+	delegations, err := proxy.NewDelegations(service.Account.GetPrivateKey(), proxyId, proxy.ProxySerivceTime)
+	if err != nil {
+		return 0, err
+	}
+	renew := pb.RenewProxy{Time: proxy.ProxySerivceTime.String(), Delegations: delegations}
+	data, err := service.requestProxy(proxyId, pb.Reqtype_RenewProxy, &renew, service.proxyManager.RegisterProxyTimeout)
+	if err != nil {
+		return 0, errors.New("renew rejected: " + err.Error())
+	}
+	return parseLease(data, proxy.ProxySerivceTime), nil
+}
+
+//keepLease renews the lease when half of it is left, and retries sooner if renewing fails. Once the lease has lapsed
+//the proxy is given up like one that went away.
+func (service *NoiseService) keepLease(ctx context.Context, proxyId core.PeerID, lease time.Duration) {
+	expire := time.Now().Add(lease)
+	wait := lease / 2
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		renewed, err := service.renewAt(proxyId)
+		if err == nil {
+			log.Debugf("Renew lease at proxy %v for %v", proxyId, renewed)
+			expire = time.Now().Add(renewed)
+			wait = renewed / 2
+			continue
+		}
+		log.Warnf("Renew lease at proxy %v err: %v", proxyId, err)
+		if time.Now().After(expire) {
+			service.proxyLost(proxyId)
+			return
+		}
+		wait = time.Until(expire) / 2
+		if wait < service.proxyManager.RegisterProxyTimeout {
+			wait = service.proxyManager.RegisterProxyTimeout
+		}
+	}
+}
+
+func parseLease(data []byte, fallback time.Duration) time.Duration {
+	var lease pb.Lease
+	if err := proto.Unmarshal(data, &lease); err != nil {
+		return fallback
+	}
+	d, err := time.ParseDuration(lease.Time)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+//requestProxy sends a request to the proxy protocol of proxyId and returns the data of its ack.
+func (service *NoiseService) requestProxy(proxyId core.PeerID, reqType pb.Reqtype, msg proto.Message, timeout time.Duration) ([]byte, error) {
+	streamRaw, err := service.host.NewStream(service.ctx, proxyId, protocol.ID(proxy.PROXY_PROTOCOL))
+	if err != nil {
+		return nil, err
+	}
+	stream := session.NewStream(streamRaw, service.ctx)
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
 	}
 	request := pb.Request{
 		ReqId:   "",
 		From:    service.host.ID().String(),
-		Reqtype: pb.Reqtype_NewProxy,
+		Reqtype: reqType,
 		Data:    data,
 	}
 	noID, err := proto.Marshal(&request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	hash := sha256.Sum256(noID)
 	request.ReqId = secure.EncodeMSGIDHash(hash[:])
 	reqData, err := proto.Marshal(&request)
-
-	err = stream.RW.WriteMsg(reqData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	task := ack.Task{
@@ -198,34 +364,57 @@ func (service *NoiseService) RegisterProxy(proxyId core.PeerID) error {
 	}
 	service.ackManager.AddTask(task)
 	defer service.ackManager.DeletTask(request.ReqId)
+	err = stream.RW.WriteMsg(reqData)
+	if err != nil {
+		return nil, err
+	}
 	select {
-	case <-time.After(service.proxyManager.RegisterProxyTimeout):
-		return errors.New("timeout")
+	case <-time.After(timeout):
+		return nil, errors.New("timeout")
 	case result := <-task.Channel:
 		if !result.Ok {
-			return errors.New("register rejected: " + string(result.Data))
+			return nil, errors.New(string(result.Data))
 		}
+		return result.Data, nil
 	}
-	service.ProxyNode = proxyId
-	log.Infof("Register to proxy %v", proxyId)
-	return nil
 }
 
+//UnRegister unregisters from every proxy the client is registered at.
 func (service *NoiseService) UnRegister() error {
-	defer func() {
-		service.ProxyNode = ""
-	}()
-	if service.ProxyNode == "" {
-		return errors.New("no proxy yet")
+	service.proxyMut.Lock()
+	proxies := make([]core.PeerID, 0, len(service.proxyLeases))
+	for id, stop := range service.proxyLeases {
+		stop()
+		proxies = append(proxies, id)
+	}
+	service.proxyLeases = make(map[core.PeerID]context.CancelFunc)
+	service.proxyRanks = nil
+	service.ProxyNode = ""
+	//stops a failover running in the background
+	service.ProxyRedundancy = 0
+	service.proxyMut.Unlock()
+	if len(proxies) == 0 {
+		return ErrNoProxy
+	}
+	var err error
+	for _, id := range proxies {
+		if e := service.unregisterAt(id); e != nil {
+			log.Warnf("Unregister from proxy %v err: %v", id, e)
+			err = e
+		}
 	}
-	streamRaw, err := service.host.NewStream(service.ctx, service.ProxyNode, protocol.ID(proxy.PROXY_PROTOCOL))
+	return err
+}
+
+func (service *NoiseService) unregisterAt(proxyId core.PeerID) error {
+	streamRaw, err := service.host.NewStream(service.ctx, proxyId, protocol.ID(proxy.PROXY_PROTOCOL))
 	if err != nil {
 		return err
 	}
 	stream := session.NewStream(streamRaw, service.ctx)
 
 	unReg := pb.UnRegister{
-		SessionID: service.relayManager.GetSessionIDList(),
+		CircuitId: service.relayManager.GetCircuitIdsWith(proxyId),
 	}
 
 	data, err := proto.Marshal(&unReg)
@@ -252,17 +441,42 @@ func (service *NoiseService) UnRegister() error {
 	return stream.RW.WriteMsg(payload)
 }
 
-func (service *NoiseService) NewCircuit(remoteIDString string, sessionId string) (err error) {
+func (service *NoiseService) NewCircuit(remoteIDString string, sessionId string) error {
+	return service.NewCircuitContext(service.ctx, remoteIDString, sessionId, common.DefaultRelayHops, relay.CircuitOptions{})
+}
+
+// NewCircuitContext returns once the proxy accepts the circuit; the circuit itself completes asynchronously.
+// hops is the number of relay nodes between the joint and the exit node.
+func (service *NoiseService) NewCircuitContext(ctx context.Context, remoteIDString string, sessionId string, hops int, opts relay.CircuitOptions) (err error) {
 	defer func() {
 		if err != nil {
 			log.Error(err)
-			service.relayManager.CloseCircuit(sessionId)
+			service.relayManager.CloseCircuit(sessionId, relay.ReasonSetupFailed)
 		}
 	}()
 
+	//one snapshot, the primary may change while the circuit is set up
+	proxyId := service.PrimaryProxy()
+	if proxyId == "" {
+		return ErrNoProxy
+	}
+
+	if hops < 1 || hops > common.MaxRelayHops {
+		return fmt.Errorf("%w: %d", ErrInvalidRelayHops, hops)
+	}
+	if opts.MixHops < 0 || opts.MixHops > hops {
+		return fmt.Errorf("%w: %d mixing of %d", ErrInvalidRelayHops, opts.MixHops, hops)
+	}
+
+	if remoteIDString == "" {
+		return ErrInvalidDestination
+	}
 	desWhiteNoiseID, err := crypto2.WhiteNoiseIDfromString(remoteIDString)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidDestination, err)
+	}
+	if _, err := desWhiteNoiseID.PublicKey(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDestination, err)
 	}
 
 	service.proxyManager.AddNewCircuitTask(sessionId, desWhiteNoiseID)
@@ -271,24 +485,35 @@ func (service *NoiseService) NewCircuit(remoteIDString string, sessionId string)
 		return errors.New("circuit with same sessionId already exist")
 	}
 
-	err = service.relayManager.NewSessionToPeer(service.ProxyNode, sessionId, common.CallerRole, common.EntryRole)
+	err = service.relayManager.NewSessionToPeerContext(ctx, proxyId, sessionId, common.CallerRole, common.EntryRole, "", nil)
 	if err != nil {
 		return err
 	}
+	sess, ok := service.relayManager.GetSession(sessionId)
+	if !ok {
+		return errors.New("session closed")
+	}
+	//the proxy only learns the circuit id of our hop, never the session id
+	circuitId, ok := sess.CircuitIdWith(proxyId)
+	if !ok {
+		return errors.New("no circuit to proxy")
+	}
 
-	streamRaw, err := service.host.NewStream(service.ctx, service.ProxyNode, protocol.ID(proxy.PROXY_PROTOCOL))
+	streamRaw, err := service.host.NewStream(ctx, proxyId, protocol.ID(proxy.PROXY_PROTOCOL))
 	if err != nil {
 		return err
 	}
 	stream := session.NewStream(streamRaw, service.ctx)
 
 	//Add new circuitConn for this session in MsgManager
-	service.relayManager.AddCircuitConnCaller(sessionId, desWhiteNoiseID)
+	service.relayManager.AddCircuitConnCaller(sessionId, desWhiteNoiseID, opts)
 
 	newCircuit := pb.NewCircuit{
 		From:      service.Account.GetPublicKey().GetWhiteNoiseID().Hash(),
 		To:        desWhiteNoiseID.Hash(),
-		SessionId: sessionId,
+		CircuitId: circuitId,
+		Hops:      int32(hops),
+		MixHops:   int32(opts.MixHops),
 	}
 
 	data, err := proto.Marshal(&newCircuit)
@@ -320,19 +545,65 @@ func (service *NoiseService) NewCircuit(remoteIDString string, sessionId string)
 	defer service.ackManager.DeletTask(request.ReqId)
 	timeout := time.After(service.proxyManager.NewCircuitTimeout)
 	select {
+	case <-ctx.Done():
+		return ctx.Err()
 	case <-timeout:
-		return errors.New("timeout")
+		return ErrNewCircuitTimeout
 	case result := <-task.Channel:
 		if !result.Ok {
-			service.relayManager.CloseCircuit(sessionId)
-			return errors.New("new circuit rejected: " + string(result.Data))
+			//the destination is a client of our proxy too and refused the circuit
+			if string(result.Data) == proxy.ErrDestinationNotFound.Error() {
+				return ErrDestinationRefused
+			}
+			return fmt.Errorf("%w: %s", ErrNewCircuitRejected, string(result.Data))
 		}
 		return nil
 	}
 }
 
+//GetMainnetPeers asks the bootstrap peers in random order for MainNet peers, until one of them answers. If none does,
+//the peers known from before the restart are asked the same way.
 func (service *NoiseService) GetMainnetPeers(max int) ([]peer.AddrInfo, error) {
-	streamRaw, err := service.host.NewStream(service.ctx, service.BootstrapPeer, protocol.ID(proxy.PROXY_PROTOCOL))
+	sources := make([]peer.ID, 0, len(service.BootstrapPeers))
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, i := range r.Perm(len(service.BootstrapPeers)) {
+		sources = append(sources, service.BootstrapPeers[i])
+	}
+	for _, id := range service.knownPeers(false) {
+		if !containsPeer(sources, id) {
+			sources = append(sources, id)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, ErrNoBootstrapPeer
+	}
+	var err error
+	for _, id := range sources {
+		var peerInfos []peer.AddrInfo
+		peerInfos, err = service.getMainnetPeersFrom(id, max)
+		if err == nil {
+			service.rememberPeer(id, false)
+			return peerInfos, nil
+		}
+		log.Warnf("get mainnet peers from %v err %v", id, err)
+		service.peerFailed(id)
+	}
+	return nil, err
+}
+
+func containsPeer(ids []peer.ID, id peer.ID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (service *NoiseService) getMainnetPeersFrom(bootstrapPeer peer.ID, max int) ([]peer.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(service.ctx, common.BootstrapConnectTimeout)
+	defer cancel()
+	streamRaw, err := service.host.NewStream(ctx, bootstrapPeer, protocol.ID(proxy.PROXY_PROTOCOL))
 	if err != nil {
 		return nil, err
 	}
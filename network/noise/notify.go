@@ -19,6 +19,7 @@ type NoiseNotifiee struct {
 	proxyPid      *actor.PID
 	relayPid      *actor.PID
 	whiteListMode bool
+	service       *NoiseService
 }
 
 func (n NoiseNotifiee) Listen(network network.Network, multiaddr multiaddr.Multiaddr) {}
@@ -35,11 +36,22 @@ func (n NoiseNotifiee) Connected(network network.Network, conn network.Conn) {
 	}
 	until, _ := time.Parse(time.RFC3339, common.NetTimeUntil)
 	n.host.Peerstore().AddAddr(conn.RemotePeer(), conn.RemoteMultiaddr(), time.Until(until))
+	if n.service != nil {
+		n.service.peerConnected(conn)
+	}
 }
 
-func (n NoiseNotifiee) Disconnected(network network.Network, conn network.Conn) {
-	//proxy handle client disconnect
+func (n NoiseNotifiee) Disconnected(net network.Network, conn network.Conn) {
+	//still connected to the peer over another connection
+	if net.Connectedness(conn.RemotePeer()) == network.Connected {
+		return
+	}
+	//proxy handle client disconnect, and close all circuits with the peer
 	n.actCtx.Request(n.proxyPid, proxy.ReqUnregister{PeerId: conn.RemotePeer()})
+	//a client fails over if it was one of its proxies, and keeps its addresses to register to it again later
+	if n.service != nil && n.service.proxyLost(conn.RemotePeer()) {
+		return
+	}
 	n.host.Peerstore().ClearAddrs(conn.RemotePeer())
 }
 
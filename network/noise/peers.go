@@ -0,0 +1,87 @@
+package noise
+
+import (
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	core "github.com/libp2p/go-libp2p-core"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+//dialedAddrs returns the addresses of our outbound connections to id, the ones worth dialing again after a restart.
+func (service *NoiseService) dialedAddrs(id core.PeerID) []multiaddr.Multiaddr {
+	var addrs []multiaddr.Multiaddr
+	for _, conn := range service.host.Network().ConnsToPeer(id) {
+		if conn.Stat().Direction == network.DirOutbound {
+			addrs = append(addrs, conn.RemoteMultiaddr())
+		}
+	}
+	return addrs
+}
+
+//rememberPeer records in the peerstore that id was reached, as a proxy of this node if proxy is set.
+func (service *NoiseService) rememberPeer(id core.PeerID, proxy bool) {
+	if service.PeerDB == nil {
+		return
+	}
+	addrs := service.dialedAddrs(id)
+	if len(addrs) == 0 {
+		return
+	}
+	var err error
+	if proxy {
+		err = service.PeerDB.SeenProxy(id, addrs...)
+	} else {
+		err = service.PeerDB.Seen(id, addrs...)
+	}
+	if err != nil {
+		log.Warnf("store peer %v err %v", id, err)
+	}
+}
+
+//peerConnected keeps the peers a MainNet node dialed, clients only keep their proxies and bootstrap sources.
+func (service *NoiseService) peerConnected(conn network.Conn) {
+	if service.PeerDB == nil || service.Role == config.ClientMode || conn.Stat().Direction != network.DirOutbound {
+		return
+	}
+	if err := service.PeerDB.Seen(conn.RemotePeer(), conn.RemoteMultiaddr()); err != nil {
+		log.Warnf("store peer %v err %v", conn.RemotePeer(), err)
+	}
+}
+
+func (service *NoiseService) peerFailed(id core.PeerID) {
+	if service.PeerDB == nil {
+		return
+	}
+	if err := service.PeerDB.Failed(id); err != nil {
+		log.Warnf("store peer %v err %v", id, err)
+	}
+}
+
+//knownPeers returns stored peers and adds their addresses to the libp2p peerstore, so they can be dialed. Only
+//peers that served as proxy are returned if proxies is set.
+func (service *NoiseService) knownPeers(proxies bool) []peer.ID {
+	if service.PeerDB == nil {
+		return nil
+	}
+	records, err := service.PeerDB.Peers()
+	if err != nil {
+		log.Warnf("load known peers err %v", err)
+		return nil
+	}
+	var ids []peer.ID
+	for _, record := range records {
+		if record.ID == service.host.ID() || (proxies && !record.Proxy) {
+			continue
+		}
+		service.host.Peerstore().AddAddrs(record.ID, record.Addrs, peerstore.AddressTTL)
+		ids = append(ids, record.ID)
+		if len(ids) == common.PeerStoreSeedCount {
+			break
+		}
+	}
+	return ids
+}
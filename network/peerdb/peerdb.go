@@ -0,0 +1,228 @@
+package peerdb
+
+import (
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/store"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"sort"
+	"sync"
+	"time"
+)
+
+const keyPrefix = "peer/"
+
+//addresses kept per peer, the most recently used first
+const maxAddrs = 8
+
+//PeerRecord is what the node knows about a peer it reached before. Failures counts failed attempts since the last
+//success, Proxy is set once the peer served as proxy of this node.
+type PeerRecord struct {
+	ID        peer.ID
+	Addrs     []multiaddr.Multiaddr
+	LastSeen  time.Time
+	Successes int
+	Failures  int
+	Proxy     bool
+}
+
+func (r PeerRecord) AddrInfo() peer.AddrInfo {
+	return peer.AddrInfo{ID: r.ID, Addrs: r.Addrs}
+}
+
+//PeerDB persists known-good peers in leveldb, so a node can find the network again after a restart without a bootstrap.
+type PeerDB struct {
+	db  *store.LevelDBStore
+	mut sync.Mutex
+	//peers not seen for MaxAge, or failing MaxFailures times in a row, are aged out
+	MaxAge      time.Duration
+	MaxFailures int
+	MaxPeers    int
+}
+
+func Open(path string) (*PeerDB, error) {
+	db, err := store.NewLevelDBStore(path)
+	if err != nil {
+		return nil, err
+	}
+	return &PeerDB{
+		db:          db,
+		MaxAge:      common.PeerStoreMaxAge,
+		MaxFailures: common.PeerStoreMaxFailures,
+		MaxPeers:    common.PeerStoreMaxPeers,
+	}, nil
+}
+
+func (pdb *PeerDB) Close() error {
+	return pdb.db.Close()
+}
+
+func key(id peer.ID) []byte {
+	return []byte(keyPrefix + id.String())
+}
+
+func (pdb *PeerDB) get(id peer.ID) (PeerRecord, bool) {
+	data, err := pdb.db.Get(key(id))
+	if err != nil {
+		return PeerRecord{ID: id}, false
+	}
+	record, err := unmarshalRecord(data)
+	if err != nil {
+		return PeerRecord{ID: id}, false
+	}
+	return record, true
+}
+
+func (pdb *PeerDB) put(record PeerRecord) error {
+	pbRecord := pb.PeerRecord{
+		Id:        record.ID.String(),
+		LastSeen:  record.LastSeen.UnixNano(),
+		Successes: uint32(record.Successes),
+		Failures:  uint32(record.Failures),
+		Proxy:     record.Proxy,
+	}
+	for _, addr := range record.Addrs {
+		pbRecord.Addrs = append(pbRecord.Addrs, addr.Bytes())
+	}
+	data, err := proto.Marshal(&pbRecord)
+	if err != nil {
+		return err
+	}
+	return pdb.db.Put(key(record.ID), data)
+}
+
+func unmarshalRecord(data []byte) (PeerRecord, error) {
+	var pbRecord pb.PeerRecord
+	if err := proto.Unmarshal(data, &pbRecord); err != nil {
+		return PeerRecord{}, err
+	}
+	id, err := peer.Decode(pbRecord.Id)
+	if err != nil {
+		return PeerRecord{}, err
+	}
+	record := PeerRecord{
+		ID:        id,
+		LastSeen:  time.Unix(0, pbRecord.LastSeen),
+		Successes: int(pbRecord.Successes),
+		Failures:  int(pbRecord.Failures),
+		Proxy:     pbRecord.Proxy,
+	}
+	for _, b := range pbRecord.Addrs {
+		addr, err := multiaddr.NewMultiaddrBytes(b)
+		if err != nil {
+			continue
+		}
+		record.Addrs = append(record.Addrs, addr)
+	}
+	return record, nil
+}
+
+//Seen records a successful connection to the peer at addrs, they are kept in front of the addresses known before.
+func (pdb *PeerDB) Seen(id peer.ID, addrs ...multiaddr.Multiaddr) error {
+	return pdb.seen(id, false, addrs)
+}
+
+//SeenProxy records a successful registration at the proxy reached at addrs.
+func (pdb *PeerDB) SeenProxy(id peer.ID, addrs ...multiaddr.Multiaddr) error {
+	return pdb.seen(id, true, addrs)
+}
+
+func (pdb *PeerDB) seen(id peer.ID, proxy bool, addrs []multiaddr.Multiaddr) error {
+	pdb.mut.Lock()
+	defer pdb.mut.Unlock()
+	record, _ := pdb.get(id)
+	record.Proxy = record.Proxy || proxy
+	merged := make([]multiaddr.Multiaddr, 0, len(addrs)+len(record.Addrs))
+	for _, addr := range append(append([]multiaddr.Multiaddr(nil), addrs...), record.Addrs...) {
+		if !containsAddr(merged, addr) {
+			merged = append(merged, addr)
+		}
+	}
+	if len(merged) > maxAddrs {
+		merged = merged[:maxAddrs]
+	}
+	record.Addrs = merged
+	record.LastSeen = time.Now()
+	record.Successes++
+	record.Failures = 0
+	return pdb.put(record)
+}
+
+//Failed records a failed attempt to reach a known peer, unknown peers are not stored.
+func (pdb *PeerDB) Failed(id peer.ID) error {
+	pdb.mut.Lock()
+	defer pdb.mut.Unlock()
+	record, ok := pdb.get(id)
+	if !ok {
+		return nil
+	}
+	record.Failures++
+	return pdb.put(record)
+}
+
+//Peers returns the stored peers, most reliable first. Stale or failing peers, and those beyond MaxPeers, are dropped.
+func (pdb *PeerDB) Peers() ([]PeerRecord, error) {
+	pdb.mut.Lock()
+	defer pdb.mut.Unlock()
+	keys, err := pdb.db.QueryStringKeysByPrefix([]byte(keyPrefix))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	records := make([]PeerRecord, 0, len(keys))
+	for _, k := range keys {
+		data, err := pdb.db.Get([]byte(k))
+		if err != nil {
+			continue
+		}
+		record, err := unmarshalRecord(data)
+		if err != nil || len(record.Addrs) == 0 || now.Sub(record.LastSeen) > pdb.MaxAge || record.Failures >= pdb.MaxFailures {
+			pdb.db.Delete([]byte(k))
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Failures != records[j].Failures {
+			return records[i].Failures < records[j].Failures
+		}
+		if records[i].Successes != records[j].Successes {
+			return records[i].Successes > records[j].Successes
+		}
+		return records[i].LastSeen.After(records[j].LastSeen)
+	})
+	if pdb.MaxPeers > 0 && len(records) > pdb.MaxPeers {
+		for _, record := range records[pdb.MaxPeers:] {
+			pdb.db.Delete(key(record.ID))
+		}
+		records = records[:pdb.MaxPeers]
+	}
+	return records, nil
+}
+
+//Seeds returns the AddrInfos of at most n stored peers, most reliable first.
+func (pdb *PeerDB) Seeds(n int) []peer.AddrInfo {
+	records, err := pdb.Peers()
+	if err != nil {
+		return nil
+	}
+	if len(records) > n {
+		records = records[:n]
+	}
+	seeds := make([]peer.AddrInfo, len(records))
+	for i, record := range records {
+		seeds[i] = record.AddrInfo()
+	}
+	return seeds
+}
+
+func containsAddr(addrs []multiaddr.Multiaddr, addr multiaddr.Multiaddr) bool {
+	for _, a := range addrs {
+		if a.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package peerdb
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/magiconair/properties/assert"
+	"github.com/multiformats/go-multiaddr"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPeerDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pdb, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := peer.Decode("QmdLEFWxMNZ5dKGKNn8tJHZG2RDnMXrzBkp94heQeUZYCr")
+	b, _ := peer.Decode("QmXkCpR1CtDqPWQ7RrUgSg2aikFPMFbk8oZYo1wRn3wLGH")
+	addr1, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/3331")
+	addr2, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/3332")
+	pdb.Seen(a, addr1)
+	pdb.Seen(a, addr2)
+	pdb.SeenProxy(b, addr1)
+	//unknown peers are not stored on failure
+	unknown, _ := peer.Decode("QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N")
+	pdb.Failed(unknown)
+
+	peers, err := pdb.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(peers), 2)
+	assert.Equal(t, peers[0].ID, a)
+	assert.Equal(t, peers[0].Successes, 2)
+	assert.Equal(t, len(peers[0].Addrs), 2)
+	assert.Equal(t, peers[0].Addrs[0].Equal(addr2), true)
+	assert.Equal(t, peers[0].Proxy, false)
+	assert.Equal(t, peers[1].Proxy, true)
+
+	//records survive a restart
+	pdb.Close()
+	pdb, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	assert.Equal(t, len(pdb.Seeds(1)), 1)
+
+	//failing peers rank last and are dropped after MaxFailures in a row
+	pdb.Failed(a)
+	peers, _ = pdb.Peers()
+	assert.Equal(t, peers[0].ID, b)
+	for i := 1; i < pdb.MaxFailures; i++ {
+		pdb.Failed(a)
+	}
+	peers, _ = pdb.Peers()
+	assert.Equal(t, len(peers), 1)
+
+	//stale peers are aged out
+	pdb.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	peers, _ = pdb.Peers()
+	assert.Equal(t, len(peers), 0)
+}
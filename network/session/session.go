@@ -11,10 +11,13 @@ import (
 
 const SessionIdNon string = "SessionIDNon"
 
+//Session is one circuit as seen by this node. Id is a local key and never sent out, except at the caller
+//and the answer where it is the end-to-end session id. CircuitIds[i] is the circuit id of the hop on Pair[i].
 type Session struct {
-	Id   string
-	Pair StreamPair
-	Role common.SessionRole
+	Id         string
+	Pair       StreamPair
+	CircuitIds []string
+	Role       common.SessionRole
 }
 
 type StreamPair []Stream
@@ -42,9 +45,10 @@ func NewStream(s network.Stream, parentCtx context.Context) Stream {
 
 func NewSession() Session {
 	return Session{
-		Id:   SessionIdNon,
-		Pair: StreamPair{},
-		Role: 0,
+		Id:         SessionIdNon,
+		Pair:       StreamPair{},
+		CircuitIds: []string{},
+		Role:       0,
 	}
 }
 
@@ -52,14 +56,16 @@ func (s *Session) SetSessionID(sID string) {
 	s.Id = sID
 }
 
-func (s *Session) AddStream(stream Stream) {
+func (s *Session) AddStream(stream Stream, circuitId string) {
 	if s.IsReady() {
 		return
 	}
 	s.Pair = append(s.Pair, stream)
+	s.CircuitIds = append(s.CircuitIds, circuitId)
 	for {
 		if len(s.Pair) > 2 {
 			s.Pair = s.Pair[1:]
+			s.CircuitIds = s.CircuitIds[1:]
 		} else {
 			break
 		}
@@ -75,16 +81,27 @@ func (s *Session) Has(streamID string) bool {
 	return false
 }
 
-func (s *Session) GetPattern(streamID string) (Stream, error) {
+//GetPattern returns the other hop of the session and its circuit id.
+func (s *Session) GetPattern(streamID string) (Stream, string, error) {
 	if len(s.Pair) != 2 {
-		return Stream{}, errors.New("session not ready")
+		return Stream{}, "", errors.New("session not ready")
 	}
 	for i, stream := range s.Pair {
 		if stream.StreamId == streamID {
-			return s.Pair[i^1], nil
+			return s.Pair[i^1], s.CircuitIds[i^1], nil
 		}
 	}
-	return Stream{}, errors.New("no such stream")
+	return Stream{}, "", errors.New("no such stream")
+}
+
+//CircuitIdWith returns the circuit id of the hop to peer.
+func (s *Session) CircuitIdWith(peer core.PeerID) (string, bool) {
+	for i, stream := range s.Pair {
+		if stream.RemotePeer == peer {
+			return s.CircuitIds[i], true
+		}
+	}
+	return "", false
 }
 
 func (s *Session) GetPair() StreamPair {
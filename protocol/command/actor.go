@@ -6,9 +6,13 @@ import (
 )
 
 type ReqExpendSession struct {
-	Relay     core.PeerID
-	Joint     core.PeerID
-	SessionId string
+	Relay        core.PeerID
+	Joint        core.PeerID
+	SessionId    string
+	Hops         int
+	MixHops      int
+	SealedCookie []byte
+	ProbeKey     string
 }
 
 type ResError struct {
@@ -18,7 +22,7 @@ type ResError struct {
 func (manager *CmdManager) Receive(ctx actor.Context) {
 	switch msg := ctx.Message().(type) {
 	case ReqExpendSession:
-		err := manager.ExpendSession(msg.Relay, msg.Joint, msg.SessionId)
+		err := manager.ExpendSession(msg.Relay, msg.Joint, msg.SessionId, msg.Hops, msg.MixHops, msg.SealedCookie, msg.ProbeKey)
 		ctx.Respond(ResError{
 			Err: err,
 		})
@@ -7,6 +7,7 @@ import (
 	"github.com/AsynkronIT/protoactor-go/actor"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/actorMsg"
 	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
 	"github.com/Evanesco-Labs/WhiteNoise/network/session"
 	"github.com/Evanesco-Labs/WhiteNoise/protocol/ack"
@@ -18,6 +19,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	"math/rand"
 	"time"
 )
 
@@ -30,7 +32,9 @@ type CmdManager struct {
 	relayPid             *actor.PID
 	ackPid               *actor.PID
 	cmdPid               *actor.PID
+	gossipPid            *actor.PID
 	ExpendSessionTimeout time.Duration
+	RetryTimes           int
 	eb                   EventBus.Bus
 }
 
@@ -40,6 +44,7 @@ func NewCmdHandler(host core.Host, ctx context.Context, actCtx *actor.RootContex
 		context:              ctx,
 		actorCtx:             actCtx,
 		ExpendSessionTimeout: common.ExpendSessionTimeout,
+		RetryTimes:           common.RetryTimes,
 		eb:                   eb,
 	}
 }
@@ -48,9 +53,10 @@ func (manager *CmdManager) Pid() *actor.PID {
 	return manager.cmdPid
 }
 
-func (manager *CmdManager) SetPid(relayPid *actor.PID, ackPid *actor.PID) {
+func (manager *CmdManager) SetPid(relayPid *actor.PID, ackPid *actor.PID, gossipPid *actor.PID) {
 	manager.relayPid = relayPid
 	manager.ackPid = ackPid
+	manager.gossipPid = gossipPid
 }
 
 func (manager *CmdManager) Start() {
@@ -88,7 +94,8 @@ func (manager *CmdManager) CmdStreamHandler(stream network.Stream) {
 			Data:      []byte{},
 		}
 
-		fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqGetSession{Id: cmd.SessionId}, common.RequestFutureDuration)
+		//the command names the circuit of the hop with its sender, the session behind it is only known locally
+		fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqGetSessionByHop{Peer: str.RemotePeer, CircuitId: cmd.CircuitId}, common.RequestFutureDuration)
 		res, err := fut.Result()
 		if err != nil {
 			return
@@ -98,64 +105,49 @@ func (manager *CmdManager) CmdStreamHandler(stream network.Stream) {
 		ok := res.(relay.ResGetSession).Ok
 
 		if !ok {
-			log.Warnf("No such session: %v", cmd.SessionId)
-			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: cmd.SessionId})
+			log.Warnf("No such circuit: %v", cmd.CircuitId)
 			ackMsg.Data = []byte("No such session")
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 			break
 		}
 		if sess.IsReady() {
-			log.Warnf("Session is ready, ack as both entry and relay %v", cmd.SessionId)
-			ackMsg.Result = true
+			log.Warnf("Session already extended, circuit %v", cmd.CircuitId)
+			ackMsg.Data = []byte("Session already extended")
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
-			log.Debug("send circuit success signal")
-			msg := relay.NewCircuitSuccess(cmd.SessionId)
-			fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqSendRelay{
-				SessionId: cmd.SessionId,
-				Data:      msg,
-			}, common.RequestFutureDuration)
-			res, err := fut.Result()
-			if err != nil {
-				log.Error("send success msg err", err)
-			}
-			resErr := res.(relay.ResError).Err
-			if resErr != nil {
-				log.Error("send success msg err", err)
-			}
 			break
 		}
 		id, err := peer.Decode(cmd.PeerId)
 		if err != nil {
 			log.Warnf("Decode peerid %v err: %v", cmd.PeerId, err)
-			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: cmd.SessionId})
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sess.Id, Reason: relay.ReasonPolicyRejected})
 
 			ackMsg.Data = []byte("Decode peerid error")
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 			break
 		}
-		fut = manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqNewSessiontoPeer{
-			PeerID:    id,
-			SessionID: cmd.SessionId,
-			MyRole:    common.RelayRole,
-			OtherRole: common.JointRole,
-		}, common.RequestFutureDuration)
-		res, err = fut.Result()
-		if err != nil {
+		if cmd.Hops < 0 || cmd.Hops >= common.MaxRelayHops || cmd.MixHops < 0 || cmd.MixHops > cmd.Hops {
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sess.Id, Reason: relay.ReasonPolicyRejected})
+			ackMsg.Data = []byte("Invalid relay hops")
+			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 			break
 		}
-		resErr := res.(relay.ResError).Err
 
-		if resErr != nil {
-			log.Errorf("NewSessionToPeer err: %v", resErr)
-			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: cmd.SessionId})
-			ackMsg.Data = []byte("NewSessionToPeer error")
-			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
-			break
+		manager.actorCtx.Request(manager.relayPid, relay.ReqSetProbeKey{SessionId: sess.Id, Key: cmd.ProbeKey})
+		//last relay hop, extend to the joint node
+		if cmd.Hops == 0 {
+			err = manager.newSessionToPeer(id, sess.Id, common.JointRole, cmd.SealedCookie)
 		} else {
-			ackMsg.Result = true
+			err = manager.extendToNextRelay(str.RemotePeer, id, sess.Id, int(cmd.Hops), int(cmd.MixHops), cmd.SealedCookie, cmd.ProbeKey)
+		}
+		if err != nil {
+			log.Errorf("Extend circuit %v err: %v", cmd.CircuitId, err)
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sess.Id, Reason: relay.ReasonRelayFailure})
+			ackMsg.Data = []byte("Extend session error")
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 			break
 		}
+		ackMsg.Result = true
+		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 
 	case pb.Cmdtype_Disconnect:
 		log.Info("get Disconnect cmd")
@@ -163,15 +155,102 @@ func (manager *CmdManager) CmdStreamHandler(stream network.Stream) {
 
 }
 
-func (manager *CmdManager) ExpendSession(relay core.PeerID, joint core.PeerID, sessionId string) error {
-	stream, err := manager.host.NewStream(manager.context, relay, protocol.ID(CMD_PROTOCOL))
+func (manager *CmdManager) newSessionToPeer(id core.PeerID, sessionId string, otherRole common.SessionRole, sealedCookie []byte) error {
+	//only the joint needs the cookie to join the two halves of the circuit
+	if otherRole != common.JointRole {
+		sealedCookie = nil
+	}
+	fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqNewSessiontoPeer{
+		PeerID:       id,
+		SessionID:    sessionId,
+		MyRole:       common.RelayRole,
+		OtherRole:    otherRole,
+		SealedCookie: sealedCookie,
+	}, common.RequestFutureDuration)
+	res, err := fut.Result()
+	if err != nil {
+		return err
+	}
+	return res.(relay.ResError).Err
+}
+
+//extendToNextRelay picks a random relay node after this one, and asks it to extend the session for the remaining hops.
+//Nodes only learn their neighbours on the path and the joint node. While mixHops is left the next relay must support mixing.
+//The rendezvous cookie is passed on sealed to the joint.
+func (manager *CmdManager) extendToNextRelay(prev core.PeerID, joint core.PeerID, sessionId string, hops int, mixHops int, sealedCookie []byte, probeKey string) error {
+	if manager.gossipPid == nil {
+		return errors.New("no dht service")
+	}
+	fut := manager.actorCtx.RequestFuture(manager.gossipPid, actorMsg.ReqDHTPeers{Max: common.ReqDHTPeersMaxAmount}, common.RequestFutureDuration)
+	res, err := fut.Result()
+	if err != nil {
+		return err
+	}
+	peers := res.(actorMsg.ResDHTPeers).PeerInfos
+
+	invalid := make(map[core.PeerID]bool)
+	source := rand.NewSource(time.Now().UnixNano())
+	for i := 0; i < manager.RetryTimes; i++ {
+		var next = core.PeerID("")
+		startIndex := rand.New(source).Int()
+		for j := 0; j < len(peers); j++ {
+			startIndex++
+			id := peers[startIndex%len(peers)].ID
+			if mixHops > 0 && !relay.SupportsMix(manager.host, id) {
+				continue
+			}
+			if !invalid[id] && id != manager.host.ID() && id != prev && id != joint {
+				next = id
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		err = manager.newSessionToPeer(next, sessionId, common.RelayRole, nil)
+		if err != nil {
+			invalid[next] = true
+			continue
+		}
+		log.Infof("set relay node %v, %v hops left", next, hops-1)
+		if mixHops > 0 {
+			mixHops--
+		}
+		return manager.ExpendSession(next, joint, sessionId, hops-1, mixHops, sealedCookie, probeKey)
+	}
+	return errors.New("no valid node for relay role")
+}
+
+//ExpendSession asks relayNode, which already has a hop of the local session sessionId, to extend it through hops
+//more relay nodes and then to joint. The command only carries the circuit id of the hop to relayNode, the cookie
+//sealed to joint and the key the relays derive their probes with.
+func (manager *CmdManager) ExpendSession(relayNode core.PeerID, joint core.PeerID, sessionId string, hops int, mixHops int, sealedCookie []byte, probeKey string) error {
+	fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqGetSession{Id: sessionId}, common.RequestFutureDuration)
+	res, err := fut.Result()
+	if err != nil {
+		return err
+	}
+	if !res.(relay.ResGetSession).Ok {
+		return errors.New("no such session")
+	}
+	sess := res.(relay.ResGetSession).Session
+	circuitId, ok := sess.CircuitIdWith(relayNode)
+	if !ok {
+		return errors.New("no circuit to relay node")
+	}
+
+	stream, err := manager.host.NewStream(manager.context, relayNode, protocol.ID(CMD_PROTOCOL))
 	if err != nil {
 		return err
 	}
 	s := session.NewStream(stream, manager.context)
 	cmd := pb.SessionExpend{
-		SessionId: sessionId,
-		PeerId:    joint.String(),
+		CircuitId:    circuitId,
+		PeerId:       joint.String(),
+		Hops:         int32(hops),
+		MixHops:      int32(mixHops),
+		SealedCookie: sealedCookie,
+		ProbeKey:     probeKey,
 	}
 	cmdData, err := proto.Marshal(&cmd)
 	if err != nil {
@@ -208,8 +287,9 @@ func (manager *CmdManager) ExpendSession(relay core.PeerID, joint core.PeerID, s
 	manager.actorCtx.Request(manager.ackPid, ack.ReqAddTask{T: task})
 
 	defer manager.actorCtx.Request(manager.ackPid, ack.ReqDeleteTask{Id: pl.CommandId})
+	//every further hop waits for its own extension before acking
 	select {
-	case <-time.After(manager.ExpendSessionTimeout):
+	case <-time.After(manager.ExpendSessionTimeout * time.Duration(hops+1)):
 		return errors.New("timeout")
 	case result := <-task.Channel:
 		if result.Ok {
@@ -14,19 +14,30 @@ type ResGetClient struct {
 	Ok   bool
 }
 
+//ReqClients asks for the WhiteNoiseID hashes of all registered clients.
+type ReqClients struct{}
+
+type ResClients struct {
+	Destinations []string
+}
+
 type ReqDecrypt struct {
 	CipherText []byte
 	Des        peer.ID
 }
 
 type ResDecrypt struct {
-	Join      string
-	SessionId string
-	Err       error
+	Join    string
+	Cookie  string
+	Hops    int
+	MixHops int
+	Err     error
 }
 
+//ReqUnregister removes the client PeerId and closes its circuits with one of CircuitIds, or all of them if CircuitIds is nil.
 type ReqUnregister struct {
-	PeerId peer.ID
+	PeerId     peer.ID
+	CircuitIds []string
 }
 
 func (manager *ProxyManager) Receive(ctx actor.Context) {
@@ -37,15 +48,22 @@ func (manager *ProxyManager) Receive(ctx actor.Context) {
 			Info: info,
 			Ok:   ok,
 		})
+	case ReqClients:
+		ctx.Respond(ResClients{Destinations: manager.Clients()})
 	case ReqDecrypt:
-		sessionId, join, err := manager.DecryptGossip(msg.Des, msg.CipherText)
+		neg, err := manager.DecryptGossip(msg.Des, msg.CipherText)
+		if err != nil {
+			ctx.Respond(ResDecrypt{Err: err})
+			break
+		}
 		ctx.Respond(ResDecrypt{
-			Join:      join,
-			SessionId: sessionId,
-			Err:       err,
+			Join:    neg.Join,
+			Cookie:  neg.Cookie,
+			Hops:    int(neg.Hops),
+			MixHops: int(neg.MixHops),
 		})
 	case ReqUnregister:
-		manager.UnRegisterClient(msg.PeerId)
+		manager.UnRegisterClient(msg.PeerId, msg.CircuitIds)
 	default:
 		//log.Debugf("Proxy actor cannot handle request %v", msg)
 	}
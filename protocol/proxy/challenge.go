@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	cr "crypto/rand"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	core "github.com/libp2p/go-libp2p-core"
+	"time"
+)
+
+const ChallengeNonceLength = 32
+
+//challengeDomain separates the signature of a registration from anything else the account key signs.
+const challengeDomain = "whitenoise/register/v1"
+
+var (
+	ErrNoChallenge      = errors.New("no registration challenge, or it expired")
+	ErrInvalidChallenge = errors.New("invalid registration challenge")
+	ErrInvalidSignature = errors.New("invalid registration signature")
+)
+
+type challenge struct {
+	nonce  []byte
+	expire time.Time
+}
+
+//ChallengePayload is what a client signs to prove it holds the key of whiteNoiseID when registering to proxyId. The
+//nonce must be ChallengeNonceLength bytes, so the proxy cannot choose where the other fields start.
+func ChallengePayload(nonce []byte, proxyId core.PeerID, whiteNoiseID string) []byte {
+	payload := make([]byte, 0, len(challengeDomain)+len(nonce)+len(proxyId)+len(whiteNoiseID))
+	payload = append(payload, challengeDomain...)
+	payload = append(payload, nonce...)
+	payload = append(payload, []byte(proxyId)...)
+	return append(payload, []byte(whiteNoiseID)...)
+}
+
+//NewChallenge returns a fresh nonce for a registration from peer id, replacing any earlier one.
+func (manager *ProxyManager) NewChallenge(id core.PeerID) ([]byte, error) {
+	nonce := make([]byte, ChallengeNonceLength)
+	if _, err := cr.Read(nonce); err != nil {
+		return nil, err
+	}
+	manager.challenges.Store(id.String(), challenge{
+		nonce:  nonce,
+		expire: time.Now().Add(common.ProxyChallengeTimeout),
+	})
+	return nonce, nil
+}
+
+//VerifyRegistration checks sig against the challenge of peer id and the public key of whiteNoiseID.
+//A challenge is used up by the first registration attempt.
+func (manager *ProxyManager) VerifyRegistration(id core.PeerID, whiteNoiseID crypto.WhiteNoiseID, sig []byte) error {
+	v, ok := manager.challenges.Load(id.String())
+	if !ok {
+		return ErrNoChallenge
+	}
+	manager.challenges.Delete(id.String())
+	c := v.(challenge)
+	if time.Now().After(c.expire) {
+		return ErrNoChallenge
+	}
+	return verifyChallenge(whiteNoiseID, c.nonce, manager.host.ID(), sig)
+}
+
+func verifyChallenge(whiteNoiseID crypto.WhiteNoiseID, nonce []byte, proxyId core.PeerID, sig []byte) error {
+	pk, err := whiteNoiseID.PublicKey()
+	if err != nil {
+		return err
+	}
+	ok, err := pk.Verify(ChallengePayload(nonce, proxyId, whiteNoiseID.String()), sig)
+	if err != nil || !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+//expireChallenges drops challenges that were never answered.
+func (manager *ProxyManager) expireChallenges() {
+	manager.challenges.Range(func(key, value interface{}) bool {
+		if time.Now().After(value.(challenge).expire) {
+			manager.challenges.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	core "github.com/libp2p/go-libp2p-core"
+	"strconv"
+	"time"
+)
+
+//delegationDomain separates the delegation of a destination record from anything else the account key signs.
+const delegationDomain = "whitenoise/delegate/v1"
+
+var ErrInvalidDelegation = errors.New("invalid destination delegation")
+
+//DestEpoch is the epoch of the destination records published at t, the keys of the records rotate every epoch.
+func DestEpoch(t time.Time) int64 {
+	return t.Unix() / int64(common.DestRecordEpoch/time.Second)
+}
+
+//DelegationPayload is what a client signs to let proxyId publish the destination record of desHash in epoch. Every
+//field is length prefixed.
+func DelegationPayload(desHash string, proxyId core.PeerID, epoch int64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(delegationDomain)
+	for _, field := range [][]byte{[]byte(desHash), []byte(proxyId), []byte(strconv.FormatInt(epoch, 10))} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+//NewDelegations signs the delegations to proxyId of the epochs a lease from now reaches into.
+func NewDelegations(priv crypto.PrivateKey, proxyId core.PeerID, lease time.Duration) ([]*pb.DestDelegation, error) {
+	desHash := priv.Public().GetWhiteNoiseID().Hash()
+	now := time.Now()
+	var delegations []*pb.DestDelegation
+	for epoch := DestEpoch(now); epoch <= DestEpoch(now.Add(lease)); epoch++ {
+		sig, err := priv.Sign(DelegationPayload(desHash, proxyId, epoch))
+		if err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, &pb.DestDelegation{Epoch: epoch, Sig: sig})
+	}
+	return delegations, nil
+}
+
+//VerifyDelegation checks sig lets proxyId publish the destination record of whiteNoiseID in epoch.
+func VerifyDelegation(whiteNoiseID crypto.WhiteNoiseID, proxyId core.PeerID, epoch int64, sig []byte) error {
+	pk, err := whiteNoiseID.PublicKey()
+	if err != nil {
+		return err
+	}
+	ok, err := pk.Verify(DelegationPayload(whiteNoiseID.Hash(), proxyId, epoch), sig)
+	if err != nil || !ok {
+		return ErrInvalidDelegation
+	}
+	return nil
+}
+
+//Delegation returns the delegation of the client to this proxy for epoch.
+func (info ClientInfo) Delegation(epoch int64) ([]byte, bool) {
+	sig, ok := info.delegations[epoch]
+	return sig, ok
+}
+
+//delegate keeps the delegations of the client registered from peer id that verify and are for an epoch its lease
+//can reach, next to the ones it gave before for epochs not over yet.
+func (manager *ProxyManager) delegate(id core.PeerID, delegations []*pb.DestDelegation) {
+	manager.leaseMut.Lock()
+	defer manager.leaseMut.Unlock()
+	v, ok := manager.clientPeerMap.Load(id.String())
+	if !ok {
+		return
+	}
+	info, ok := manager.GetClient(v.(crypto.WhiteNoiseID).Hash())
+	if !ok || info.PeerID != id {
+		return
+	}
+	now := time.Now()
+	first, last := DestEpoch(now), DestEpoch(now.Add(manager.MaxLease))+1
+	kept := make(map[int64][]byte)
+	for epoch, sig := range info.delegations {
+		if epoch >= first {
+			kept[epoch] = sig
+		}
+	}
+	for _, delegation := range delegations {
+		if delegation.Epoch < first || delegation.Epoch > last {
+			continue
+		}
+		if err := VerifyDelegation(info.WhiteNoiseID, manager.host.ID(), delegation.Epoch, delegation.Sig); err != nil {
+			log.Warnf("Drop delegation of client %v for epoch %v: %v", id, delegation.Epoch, err)
+			continue
+		}
+		kept[delegation.Epoch] = delegation.Sig
+	}
+	//the map of a stored ClientInfo is never changed, copies of it may be read without the lock
+	info.delegations = kept
+	manager.clientWNMap.Store(info.WhiteNoiseID.Hash(), info)
+}
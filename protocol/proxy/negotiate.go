@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bytes"
+	cr "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const NegNonceLength = 16
+
+//negDomain separates the signature of a negotiation from anything else the account key signs.
+const negDomain = "whitenoise/negotiate/v1"
+
+var (
+	ErrStaleNeg      = errors.New("stale negotiation")
+	ErrReplayedNeg   = errors.New("replayed negotiation")
+	ErrInvalidNegSig = errors.New("invalid negotiation signature")
+)
+
+//NegPayload is what the caller signs, every field of the negotiation but the session id and the signature itself.
+//Fields are length prefixed, after a prefix of their own.
+func NegPayload(neg *pb.Negotiate) []byte {
+	var buf bytes.Buffer
+	fields := [][]byte{
+		[]byte(negDomain),
+		[]byte(neg.Join),
+		[]byte(neg.Destination),
+		[]byte(strconv.Itoa(int(neg.Hops))),
+		[]byte(neg.Cookie),
+		[]byte(strconv.Itoa(int(neg.MixHops))),
+		[]byte(strconv.FormatInt(neg.Timestamp, 10)),
+		neg.Nonce,
+		[]byte(neg.Caller),
+	}
+	for _, field := range fields {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+//SignNeg stamps the negotiation with the time and a fresh nonce, and signs it with the account key of the caller.
+func SignNeg(neg *pb.Negotiate, priv crypto.PrivateKey) error {
+	neg.Timestamp = time.Now().UnixNano()
+	neg.Nonce = make([]byte, NegNonceLength)
+	if _, err := cr.Read(neg.Nonce); err != nil {
+		return err
+	}
+	neg.Caller = priv.Public().GetWhiteNoiseID().String()
+	sig, err := priv.Sign(NegPayload(neg))
+	if err != nil {
+		return err
+	}
+	neg.Sig = sig
+	return nil
+}
+
+//CheckNegFresh checks the negotiation was stamped no longer than common.NegMaxAge ago, with a nonce.
+func CheckNegFresh(neg *pb.Negotiate) error {
+	age := time.Since(time.Unix(0, neg.Timestamp))
+	if age > common.NegMaxAge || age < -common.NegMaxAge {
+		return ErrStaleNeg
+	}
+	if len(neg.Nonce) != NegNonceLength {
+		return ErrStaleNeg
+	}
+	return nil
+}
+
+//VerifyNeg checks the negotiation is fresh and was signed by its caller.
+func VerifyNeg(neg *pb.Negotiate) error {
+	if err := CheckNegFresh(neg); err != nil {
+		return err
+	}
+	whiteNoiseID, err := crypto.WhiteNoiseIDfromString(neg.Caller)
+	if err != nil {
+		return ErrInvalidNegSig
+	}
+	pk, err := whiteNoiseID.PublicKey()
+	if err != nil {
+		return ErrInvalidNegSig
+	}
+	ok, err := pk.Verify(NegPayload(neg), neg.Sig)
+	if err != nil || !ok {
+		return ErrInvalidNegSig
+	}
+	return nil
+}
+
+//negNonces remembers the nonces of negotiations accepted within common.NegMaxAge, older negotiations are stale anyway.
+type negNonces struct {
+	mut       sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newNegNonces() *negNonces {
+	return &negNonces{seen: make(map[string]time.Time), lastPrune: time.Now()}
+}
+
+//add reports if nonce was not seen before and remembers it.
+func (n *negNonces) add(nonce []byte) bool {
+	now := time.Now()
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if now.Sub(n.lastPrune) > common.NegMaxAge {
+		for k, added := range n.seen {
+			//the timestamp may be up to NegMaxAge ahead of our clock
+			if now.Sub(added) > 2*common.NegMaxAge {
+				delete(n.seen, k)
+			}
+		}
+		n.lastPrune = now
+	}
+	if _, ok := n.seen[string(nonce)]; ok {
+		return false
+	}
+	n.seen[string(nonce)] = now
+	return true
+}
+
+//acceptNeg is where the destination rejects stale, badly signed or replayed negotiations, its proxy never sees who
+//the caller is.
+func (manager *ProxyManager) acceptNeg(neg *pb.Negotiate) error {
+	if err := VerifyNeg(neg); err != nil {
+		log.Warnf("Reject negotiation from caller %v: %v", neg.Caller, err)
+		return err
+	}
+	if !manager.negNonces.add(neg.Nonce) {
+		//a client registered at several proxies is asked to decrypt the same negotiation by each of them
+		log.Debugf("Reject negotiation from caller %v: %v", neg.Caller, ErrReplayedNeg)
+		return ErrReplayedNeg
+	}
+	return nil
+}
+
+//checkNeg is where the proxy of the destination rejects stale or replayed negotiations its client decrypted. The client
+//checked the signature and dropped it with the caller.
+func (manager *ProxyManager) checkNeg(neg *pb.Negotiate) error {
+	if err := CheckNegFresh(neg); err != nil {
+		log.Warnf("Reject negotiation: %v", err)
+		return err
+	}
+	if !manager.negNonces.add(neg.Nonce) {
+		log.Debugf("Reject negotiation: %v", ErrReplayedNeg)
+		return ErrReplayedNeg
+	}
+	return nil
+}
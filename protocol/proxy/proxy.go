@@ -28,8 +28,12 @@ import (
 )
 
 const PROXY_PROTOCOL string = "/proxy"
+//ProxySerivceTime is the longest lease a proxy grants a client, and the lease it grants if the client asks for none.
 const ProxySerivceTime time.Duration = time.Hour
 
+//ErrDestinationNotFound is the answer to a new circuit whose destination, a client of this proxy too, refused it.
+var ErrDestinationNotFound = errors.New("destination not found")
+
 type ProxyManager struct {
 	clientWNMap          sync.Map
 	clientPeerMap        sync.Map
@@ -44,17 +48,33 @@ type ProxyManager struct {
 	NewCircuitTimeout    time.Duration
 	DecryptReqTimeout    time.Duration
 	RetryTimes           int
-	//todo:clean tasks
+	MaxLease             time.Duration
+	LeaseCheckInterval   time.Duration
+	leaseMut             sync.Mutex
+	challenges           sync.Map
+	//only needed while a circuit is set up, dropped after common.SessionSetupTimeout
 	circuitTask sync.Map
+	negNonces   *negNonces
 	Account     *account.Account
 	eb          EventBus.Bus
 }
 
+type circuitTask struct {
+	whiteNoiseID crypto.WhiteNoiseID
+	added        time.Time
+}
+
 type ClientInfo struct {
 	WhiteNoiseID crypto.WhiteNoiseID
 	PeerID       core.PeerID
 	state        int
-	time         time.Duration
+	expire       time.Time
+	delegations  map[int64][]byte //by epoch, signatures that let this proxy publish the destination record
+}
+
+//Lease returns how long the registration of the client lasts from now.
+func (info ClientInfo) Lease() time.Duration {
+	return time.Until(info.expire)
 }
 
 func NewProxyService(host core.Host, ctx context.Context, actCtx *actor.RootContext, acc *account.Account, eb EventBus.Bus) *ProxyManager {
@@ -68,7 +88,10 @@ func NewProxyService(host core.Host, ctx context.Context, actCtx *actor.RootCont
 		NewCircuitTimeout:    common.NewCircuitTimeout,
 		DecryptReqTimeout:    common.DecryptReqTimeout,
 		RetryTimes:           common.RetryTimes,
+		MaxLease:             ProxySerivceTime,
+		LeaseCheckInterval:   common.ProxyLeaseCheckInterval,
 		circuitTask:          sync.Map{},
+		negNonces:            newNegNonces(),
 		Account:              acc,
 		eb:                   eb,
 	}
@@ -79,6 +102,7 @@ func (manager *ProxyManager) Start() {
 		return manager
 	})
 	manager.proxyPid = manager.actorCtx.Spawn(props)
+	go manager.expireClients()
 }
 
 func (manager *ProxyManager) Pid() *actor.PID {
@@ -107,24 +131,123 @@ func (manager *ProxyManager) RemoveClient(peerIdString string) {
 	manager.clientPeerMap.Delete(peerIdString)
 }
 
+//replaceClient registers info under wnIdHash. An earlier registration of the same WhiteNoiseID from another peer is
+//removed, and its peer is returned.
+func (manager *ProxyManager) replaceClient(wnIdHash string, info ClientInfo) (core.PeerID, bool) {
+	manager.leaseMut.Lock()
+	defer manager.leaseMut.Unlock()
+	var oldPeer core.PeerID
+	replaced := false
+	if v, ok := manager.clientWNMap.Load(wnIdHash); ok && v.(ClientInfo).PeerID != info.PeerID {
+		oldPeer = v.(ClientInfo).PeerID
+		replaced = true
+		manager.RemoveClient(oldPeer.String())
+	}
+	manager.AddClient(wnIdHash, info)
+	return oldPeer, replaced
+}
+
+//Clients returns the WhiteNoiseID hashes of the clients whose lease has not lapsed.
+func (manager *ProxyManager) Clients() []string {
+	var hashes []string
+	manager.clientWNMap.Range(func(key, value interface{}) bool {
+		if value.(ClientInfo).Lease() > 0 {
+			hashes = append(hashes, key.(string))
+		}
+		return true
+	})
+	return hashes
+}
+
 func (manager *ProxyManager) GetClient(wnIdHash string) (ClientInfo, bool) {
 	v, ok := manager.clientWNMap.Load(wnIdHash)
 	if !ok {
 		return ClientInfo{}, ok
 	}
-	return v.(ClientInfo), ok
+	info := v.(ClientInfo)
+	if info.Lease() <= 0 {
+		return ClientInfo{}, false
+	}
+	return info, ok
+}
+
+//leaseTime parses the lease a client asks for, and limits it to MaxLease.
+func (manager *ProxyManager) leaseTime(requested string) time.Duration {
+	lease, err := time.ParseDuration(requested)
+	if err != nil || lease <= 0 || lease > manager.MaxLease {
+		return manager.MaxLease
+	}
+	return lease
+}
+
+//RenewClient extends the lease of the client registered from peer id, and returns the new lease.
+func (manager *ProxyManager) RenewClient(id core.PeerID, lease time.Duration) (time.Duration, bool) {
+	manager.leaseMut.Lock()
+	defer manager.leaseMut.Unlock()
+	v, ok := manager.clientPeerMap.Load(id.String())
+	if !ok {
+		return 0, false
+	}
+	info, ok := manager.GetClient(v.(crypto.WhiteNoiseID).Hash())
+	if !ok || info.PeerID != id {
+		return 0, false
+	}
+	info.expire = time.Now().Add(lease)
+	manager.clientWNMap.Store(info.WhiteNoiseID.Hash(), info)
+	return lease, true
+}
+
+//expireClients unregisters clients whose lease has lapsed and drops stale challenges and tasks, until the proxy stops.
+func (manager *ProxyManager) expireClients() {
+	ticker := time.NewTicker(manager.LeaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-manager.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		manager.leaseMut.Lock()
+		manager.clientWNMap.Range(func(key, value interface{}) bool {
+			info := value.(ClientInfo)
+			if info.Lease() <= 0 {
+				log.Infof("Lease of client %v expired", info.PeerID)
+				//the circuits of the client may still be in use, only the registration lapses
+				manager.RemoveClient(info.PeerID.String())
+			}
+			return true
+		})
+		manager.leaseMut.Unlock()
+		manager.expireChallenges()
+		manager.expireCircuitTasks()
+	}
+}
+
+func leaseAck(lease time.Duration) []byte {
+	data, _ := proto.Marshal(&pb.Lease{Time: lease.String()})
+	return data
 }
 
 func (manager *ProxyManager) AddNewCircuitTask(sessionId string, whitenoiseId crypto.WhiteNoiseID) {
-	manager.circuitTask.Store(sessionId, whitenoiseId)
+	manager.circuitTask.Store(sessionId, circuitTask{whiteNoiseID: whitenoiseId, added: time.Now()})
 }
 
 func (manager *ProxyManager) GetCircuitTask(sessionId string) (crypto.WhiteNoiseID, bool) {
-	id, ok := manager.circuitTask.Load(sessionId)
+	task, ok := manager.circuitTask.Load(sessionId)
 	if !ok {
 		return crypto.WhiteNoiseID{}, ok
 	}
-	return id.(crypto.WhiteNoiseID), ok
+	return task.(circuitTask).whiteNoiseID, ok
+}
+
+//expireCircuitTasks drops the tasks of circuits that are set up or have failed by now.
+func (manager *ProxyManager) expireCircuitTasks() {
+	manager.circuitTask.Range(func(key, value interface{}) bool {
+		if time.Since(value.(circuitTask).added) > common.SessionSetupTimeout {
+			manager.circuitTask.Delete(key)
+		}
+		return true
+	})
 }
 
 func (manager *ProxyManager) ProxyStreamHandler(stream network.Stream) {
@@ -204,28 +327,72 @@ func (manager *ProxyManager) ProxyStreamHandler(stream network.Stream) {
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 			break
 		}
-		idHash := whiteNoiseID.Hash()
-		if _, ok := manager.GetClient(idHash); ok {
-			ackMsg.Data = []byte("Proxy already")
+		//the client must prove it holds the key of the WhiteNoiseID
+		err = manager.VerifyRegistration(str.RemotePeer, whiteNoiseID, newProxyReq.Sig)
+		if err != nil {
+			log.Warnf("Reject registration of %v from %v: %v", newProxyReq.WhiteNoiseID, str.RemotePeer, err)
+			ackMsg.Data = []byte(err.Error())
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 			break
 		}
-
-		duration, err := time.ParseDuration(newProxyReq.Time)
-		if err != nil {
-			duration = ProxySerivceTime
-		}
-
-		manager.AddClient(idHash, ClientInfo{
+		idHash := whiteNoiseID.Hash()
+		lease := manager.leaseTime(newProxyReq.Time)
+		oldPeer, replaced := manager.replaceClient(idHash, ClientInfo{
 			WhiteNoiseID: whiteNoiseID,
 			PeerID:       str.RemotePeer,
 			state:        1,
-			time:         duration,
+			expire:       time.Now().Add(lease),
 		})
+		if replaced {
+			//the client reconnected from a new peer, the circuits of the old one are dead
+			log.Infof("Client %v registered again from %v, drop registration from %v", newProxyReq.WhiteNoiseID, str.RemotePeer, oldPeer)
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuitsWith{Peer: oldPeer, Reason: relay.ReasonProxyUnregistered})
+		}
+
+		manager.delegate(str.RemotePeer, newProxyReq.Delegations)
 
 		ackMsg.Result = true
+		ackMsg.Data = leaseAck(lease)
 		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
 		log.Infof("Add new client %v", str.RemotePeer)
+		if manager.gossipPid != nil {
+			manager.actorCtx.Request(manager.gossipPid, actorMsg.ReqPublishDest{DesHash: idHash})
+		}
+	case pb.Reqtype_ProxyChallenge:
+		nonce, err := manager.NewChallenge(str.RemotePeer)
+		if err != nil {
+			ackMsg.Data = []byte("New challenge err")
+			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
+			break
+		}
+		data, err := proto.Marshal(&pb.ProxyChallenge{Nonce: nonce})
+		if err != nil {
+			ackMsg.Data = []byte("Marshal challenge err")
+			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
+			break
+		}
+		ackMsg.Result = true
+		ackMsg.Data = data
+		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
+	case pb.Reqtype_RenewProxy:
+		var renewProxy = pb.RenewProxy{}
+		err = proto.Unmarshal(request.Data, &renewProxy)
+		if err != nil {
+			ackMsg.Data = []byte("Unmarshal renewProxy err")
+			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
+			break
+		}
+		lease, ok := manager.RenewClient(str.RemotePeer, manager.leaseTime(renewProxy.Time))
+		if !ok {
+			ackMsg.Data = []byte("Not registered")
+			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
+			break
+		}
+		manager.delegate(str.RemotePeer, renewProxy.Delegations)
+		ackMsg.Result = true
+		ackMsg.Data = leaseAck(lease)
+		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: str.RemotePeer})
+		log.Debugf("Renew lease of client %v for %v", str.RemotePeer, lease)
 	case pb.Reqtype_NewCircuit:
 		errMsg, err := manager.HandleNewCircuit(&request, str)
 		if err != nil {
@@ -272,7 +439,17 @@ func (manager *ProxyManager) ProxyStreamHandler(stream network.Stream) {
 		})
 	case pb.Reqtype_UnRegisterType:
 		log.Debug("handle unregister")
-		manager.UnRegisterClient(str.RemotePeer)
+		var unRegister = pb.UnRegister{}
+		err = proto.Unmarshal(request.Data, &unRegister)
+		if err != nil {
+			log.Debug("Unmarshal unRegister err", err)
+		}
+		//an empty list closes nothing, nil would close every circuit with the client
+		circuitIds := unRegister.CircuitId
+		if circuitIds == nil {
+			circuitIds = []string{}
+		}
+		manager.UnRegisterClient(str.RemotePeer, circuitIds)
 	}
 }
 
@@ -293,7 +470,26 @@ func (manager *ProxyManager) HandleDecrypt(request *pb.Request, str session.Stre
 	if err != nil {
 		return nil, err
 	}
-	return plain, nil
+	if err = manager.acceptNeg(&neg); err != nil {
+		return nil, err
+	}
+	//accept the hop from the exit node with this cookie as the session, and keep the session id from the exit node
+	fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqExpectAnswer{
+		Cookie:    neg.Cookie,
+		SessionId: neg.SessionId,
+	}, common.RequestFutureDuration)
+	res, err := fut.Result()
+	if err != nil {
+		return nil, err
+	}
+	if err = res.(relay.ResError).Err; err != nil {
+		return nil, err
+	}
+	//the proxy only learns what it needs to set up the circuit, and the timestamp and nonce to reject replays
+	neg.SessionId = ""
+	neg.Caller = ""
+	neg.Sig = nil
+	return proto.Marshal(&neg)
 }
 
 func (manager *ProxyManager) HandleEncrypt(request *pb.Request, str session.Stream) ([]byte, error) {
@@ -302,15 +498,42 @@ func (manager *ProxyManager) HandleEncrypt(request *pb.Request, str session.Stre
 	if err != nil {
 		return nil, err
 	}
-	whitenoise, ok := manager.GetCircuitTask(negPlaintext.SessionId)
+	//the entry only knows the circuit id of the hop to me, fill in the session id for the answer
+	fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqGetSessionByHop{
+		Peer:      str.RemotePeer,
+		CircuitId: negPlaintext.CircuitId,
+	}, common.RequestFutureDuration)
+	res, err := fut.Result()
+	if err != nil {
+		return nil, err
+	}
+	if !res.(relay.ResGetSession).Ok {
+		return nil, errors.New("session not exist")
+	}
+	sessionId := res.(relay.ResGetSession).Session.Id
+	whitenoise, ok := manager.GetCircuitTask(sessionId)
 	if !ok {
 		return nil, errors.New("session not exist")
 	}
+	var neg pb.Negotiate
+	err = proto.Unmarshal(negPlaintext.Neg, &neg)
+	if err != nil {
+		return nil, err
+	}
+	neg.SessionId = sessionId
+	//prove to the destination that the negotiation is fresh and ours
+	if err = SignNeg(&neg, manager.Account.GetPrivateKey()); err != nil {
+		return nil, err
+	}
+	negData, err := proto.Marshal(&neg)
+	if err != nil {
+		return nil, err
+	}
 	pk, err := whitenoise.PublicKey()
 	if err != nil {
 		return nil, err
 	}
-	negCypherData, err := pk.ECIESEncrypt(negPlaintext.Neg, cr.Reader)
+	negCypherData, err := pk.ECIESEncrypt(negData, cr.Reader)
 	if err != nil {
 		return nil, err
 	}
@@ -330,7 +553,20 @@ func (manager *ProxyManager) HandleNewCircuit(request *pb.Request, str session.S
 		return errMsg, errors.New(string(errMsg))
 	}
 
-	fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqGetSession{Id: newCircuit.SessionId}, common.RequestFutureDuration)
+	//old clients do not set hops
+	hops := newCircuit.Hops
+	if hops == 0 {
+		hops = common.DefaultRelayHops
+	}
+	if hops < 0 || hops > common.MaxRelayHops || newCircuit.MixHops < 0 || newCircuit.MixHops > hops {
+		errMsg := []byte("Invalid relay hops")
+		return errMsg, errors.New(string(errMsg))
+	}
+
+	fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqGetSessionByHop{
+		Peer:      str.RemotePeer,
+		CircuitId: newCircuit.CircuitId,
+	}, common.RequestFutureDuration)
 	res, err := fut.Result()
 	if err != nil {
 		return []byte{}, err
@@ -345,16 +581,42 @@ func (manager *ProxyManager) HandleNewCircuit(request *pb.Request, str session.S
 		errMsg := []byte("Session is full")
 		return errMsg, errors.New(string(errMsg))
 	}
+	sessionId := sess.Id
+	//cookie for the joint and exit node to find the two halves of this circuit
+	cookie := relay.NewCookie()
 
 	//server and client connect to the same proxy
 	if clientInfo, ok := manager.GetClient(newCircuit.To); ok {
 		log.Info("client server both to me")
+		//let the answer learn the session id from the caller
+		var neg = pb.Negotiate{
+			Join:        manager.host.ID().String(),
+			Destination: newCircuit.To,
+			Sig:         []byte{},
+			Hops:        hops,
+			Cookie:      cookie,
+		}
+		negData, _ := proto.Marshal(&neg)
+		negCypher, err := manager.EncryptGossip(negData, str.RemotePeer, newCircuit.CircuitId)
+		if err != nil {
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
+			return nil, err
+		}
+		_, err = manager.DecryptGossip(clientInfo.PeerID, negCypher)
+		if err != nil {
+			log.Warnf("Destination refused circuit %v: %v", newCircuit.CircuitId, err)
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonDestinationNotFound})
+			errMsg := []byte(ErrDestinationNotFound.Error())
+			return errMsg, ErrDestinationNotFound
+		}
+
 		//new session to the answer role
 		fut := manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqNewSessiontoPeer{
 			PeerID:    clientInfo.PeerID,
-			SessionID: newCircuit.SessionId,
+			SessionID: sessionId,
 			MyRole:    common.ExitRole,
 			OtherRole: common.AnswerRole,
+			Cookie:    cookie,
 		}, common.RequestFutureDuration)
 
 		res, err := fut.Result()
@@ -363,15 +625,15 @@ func (manager *ProxyManager) HandleNewCircuit(request *pb.Request, str session.S
 		}
 		resErr := res.(relay.ResError).Err
 		if resErr != nil {
-			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: newCircuit.SessionId})
+			manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
 			return nil, resErr
 		}
 
 		//send build circuit success to clients (act like a joint node)
 		log.Debug("send circuit success signal")
-		relayMsg := relay.NewCircuitSuccess(newCircuit.SessionId)
+		relayMsg := relay.NewCircuitSuccess()
 		fut = manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqSendRelay{
-			SessionId: newCircuit.SessionId,
+			SessionId: sessionId,
 			Data:      relayMsg,
 		}, common.RequestFutureDuration)
 
@@ -413,9 +675,10 @@ func (manager *ProxyManager) HandleNewCircuit(request *pb.Request, str session.S
 		}
 		fut = manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqNewSessiontoPeer{
 			PeerID:    join,
-			SessionID: newCircuit.SessionId,
+			SessionID: sessionId,
 			MyRole:    common.EntryRole,
 			OtherRole: common.JointRole,
+			Cookie:    cookie,
 		}, common.RequestFutureDuration)
 		res, err := fut.Result()
 		if err != nil {
@@ -431,46 +694,48 @@ func (manager *ProxyManager) HandleNewCircuit(request *pb.Request, str session.S
 	}
 
 	if !tryJoinSuccess {
-		log.Warnf("cannot find joint node %v", newCircuit.SessionId)
-		manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: newCircuit.SessionId})
+		log.Warnf("cannot find joint node for circuit %v", newCircuit.CircuitId)
+		manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
 		errMsg := []byte("Cannot find joint node " + err.Error())
 		return errMsg, errors.New(string(errMsg))
 	}
 	//request caller to encrypt gossip msg
 	var neg = pb.Negotiate{
 		Join:        join.String(),
-		SessionId:   newCircuit.SessionId,
 		Destination: newCircuit.To,
 		Sig:         []byte{},
+		Hops:        hops,
+		MixHops:     newCircuit.MixHops,
+		Cookie:      cookie,
 	}
 	negData, _ := proto.Marshal(&neg)
-	negCypher, err := manager.EncryptGossip(negData, str.RemotePeer, newCircuit.SessionId)
+	negCypher, err := manager.EncryptGossip(negData, str.RemotePeer, newCircuit.CircuitId)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Infof("Gossip for session %v, joint node %v", newCircuit.SessionId, join.String())
+	log.Infof("Gossip for circuit %v, joint node %v", newCircuit.CircuitId, join.String())
 	fut = manager.actorCtx.RequestFuture(manager.gossipPid, actorMsg.ReqGossipJoint{
 		DesHash:   newCircuit.To,
 		NegCypher: negCypher,
-	}, common.RequestFutureDuration)
+	}, common.DestLookupTimeout+common.RequestFutureDuration)
 	res, err = fut.Result()
 	if err != nil {
 		return nil, err
 	}
-	resErr := res.(actorMsg.ResError).Err
+	joint := res.(actorMsg.ResGossipJoint)
+	resErr := joint.Err
 	if resErr != nil {
 		log.Warnf("Gossip err %v", resErr)
-		manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: newCircuit.SessionId})
+		manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuit{SessionId: sessionId, Reason: relay.ReasonSetupFailed})
 		errMsg := []byte("GossipJoint err" + resErr.Error())
 		return errMsg, err
 	}
 	log.Debug("Sending probe signal")
 	//send probe signal to joint node
-	probeSignal, _ := relay.NewProbeSignal(newCircuit.SessionId)
-	fut = manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqSendRelay{
-		SessionId: newCircuit.SessionId,
-		Data:      probeSignal,
+	fut = manager.actorCtx.RequestFuture(manager.relayPid, relay.ReqSendProbe{
+		SessionId: sessionId,
+		Key:       cookie,
 	}, common.RequestFutureDuration)
 	res, err = fut.Result()
 	if err != nil {
@@ -480,13 +745,30 @@ func (manager *ProxyManager) HandleNewCircuit(request *pb.Request, str session.S
 	if resErr != nil {
 		return nil, resErr
 	}
+	//nobody tells the entry if the destination is not found or refuses the negotiation, the circuit just never comes up
+	var fallback func()
+	if joint.Direct {
+		//the proxy named in the DHT acked, but may drop the negotiation, so it is gossiped if no answer comes
+		fallback = func() {
+			manager.actorCtx.Request(manager.gossipPid, actorMsg.ReqGossipJoint{
+				DesHash:    newCircuit.To,
+				NegCypher:  negCypher,
+				GossipOnly: true,
+			})
+		}
+	}
+	manager.actorCtx.Request(manager.relayPid, relay.ReqAwaitAnswer{
+		SessionId: sessionId,
+		Timeout:   common.NegAnswerTimeout + common.ExpendSessionTimeout*time.Duration(hops),
+		Fallback:  fallback,
+	})
 	return nil, nil
 }
 
-func (manager *ProxyManager) DecryptGossip(des core.PeerID, cypher []byte) (string, string, error) {
+func (manager *ProxyManager) DecryptGossip(des core.PeerID, cypher []byte) (*pb.Negotiate, error) {
 	streamRaw, err := manager.NewProxyStream(des)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	s := session.NewStream(streamRaw, manager.ctx)
@@ -513,7 +795,7 @@ func (manager *ProxyManager) DecryptGossip(des core.PeerID, cypher []byte) (stri
 	pl, _ := proto.Marshal(&req)
 	err = s.RW.WriteMsg(pl)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	//wait for plaintext ack
@@ -526,21 +808,24 @@ func (manager *ProxyManager) DecryptGossip(des core.PeerID, cypher []byte) (stri
 	timeout := time.After(manager.DecryptReqTimeout)
 	select {
 	case <-timeout:
-		return "", "", errors.New("timeout")
+		return nil, errors.New("timeout")
 	case result := <-task.Channel:
 		if !result.Ok {
-			return "", "", errors.New("client decrypt err")
+			return nil, errors.New("client decrypt err")
 		}
 		var neg pb.Negotiate
 		err := proto.Unmarshal(result.Data, &neg)
 		if err != nil {
-			return "", "", err
+			return nil, err
+		}
+		if err = manager.checkNeg(&neg); err != nil {
+			return nil, err
 		}
-		return neg.SessionId, neg.Join, nil
+		return &neg, nil
 	}
 }
 
-func (manager *ProxyManager) EncryptGossip(plaintext []byte, caller peer.ID, sessionID string) ([]byte, error) {
+func (manager *ProxyManager) EncryptGossip(plaintext []byte, caller peer.ID, circuitId string) ([]byte, error) {
 	streamRaw, err := manager.NewProxyStream(caller)
 	if err != nil {
 		return nil, err
@@ -554,7 +839,7 @@ func (manager *ProxyManager) EncryptGossip(plaintext []byte, caller peer.ID, ses
 		Data:    []byte{},
 	}
 	negPlain := pb.NegPlaintext{
-		SessionId: sessionID,
+		CircuitId: circuitId,
 		Neg:       plaintext,
 	}
 	data, _ := proto.Marshal(&negPlain)
@@ -593,7 +878,9 @@ func (manager *ProxyManager) NewProxyStream(id core.PeerID) (network.Stream, err
 	return manager.host.NewStream(manager.ctx, id, protocol.ID(PROXY_PROTOCOL))
 }
 
-//todo:close client's existing circuits
-func (manager *ProxyManager) UnRegisterClient(id core.PeerID) {
+//UnRegisterClient removes the client id, and closes its circuits this node is entry or exit of with one of circuitIds,
+//or all of them if circuitIds is nil.
+func (manager *ProxyManager) UnRegisterClient(id core.PeerID, circuitIds []string) {
 	manager.RemoveClient(id.String())
+	manager.actorCtx.Request(manager.relayPid, relay.ReqCloseCircuitsWith{Peer: id, CircuitIds: circuitIds, Reason: relay.ReasonProxyUnregistered})
 }
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	cr "crypto/rand"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/magiconair/properties/assert"
+	"github.com/golang/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	manager := NewProxyService(nil, context.Background(), nil, nil, nil)
+	assert.Equal(t, manager.leaseTime("10m"), 10*time.Minute)
+	assert.Equal(t, manager.leaseTime("2h"), ProxySerivceTime)
+	assert.Equal(t, manager.leaseTime("-1s"), ProxySerivceTime)
+	assert.Equal(t, manager.leaseTime(""), ProxySerivceTime)
+
+	_, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, cr.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pub.GetWhiteNoiseID()
+	client := peer.ID("client")
+	manager.AddClient(id.Hash(), ClientInfo{WhiteNoiseID: id, PeerID: client, expire: time.Now().Add(-time.Second)})
+	if _, ok := manager.GetClient(id.Hash()); ok {
+		t.Fatal("expect expired client")
+	}
+	if _, ok := manager.RenewClient(client, time.Minute); ok {
+		t.Fatal("expect no renewal of an expired lease")
+	}
+
+	manager.AddClient(id.Hash(), ClientInfo{WhiteNoiseID: id, PeerID: client, expire: time.Now().Add(time.Second)})
+	if _, ok := manager.RenewClient(peer.ID("other"), time.Minute); ok {
+		t.Fatal("expect no renewal from another peer")
+	}
+	lease, ok := manager.RenewClient(client, time.Minute)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, lease, time.Minute)
+	info, ok := manager.GetClient(id.Hash())
+	assert.Equal(t, ok, true)
+	if info.Lease() <= time.Second {
+		t.Fatalf("lease not extended: %v", info.Lease())
+	}
+}
+
+func TestReplaceClient(t *testing.T) {
+	manager := NewProxyService(nil, context.Background(), nil, nil, nil)
+	_, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, cr.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pub.GetWhiteNoiseID()
+	first, second := peer.ID("first"), peer.ID("second")
+	_, replaced := manager.replaceClient(id.Hash(), ClientInfo{WhiteNoiseID: id, PeerID: first, expire: time.Now().Add(time.Minute)})
+	assert.Equal(t, replaced, false)
+
+	//the client reconnects from another peer
+	old, replaced := manager.replaceClient(id.Hash(), ClientInfo{WhiteNoiseID: id, PeerID: second, expire: time.Now().Add(time.Minute)})
+	assert.Equal(t, replaced, true)
+	assert.Equal(t, old, first)
+	info, ok := manager.GetClient(id.Hash())
+	assert.Equal(t, ok, true)
+	assert.Equal(t, info.PeerID, second)
+	if _, ok := manager.RenewClient(first, time.Minute); ok {
+		t.Fatal("expect no renewal from the replaced peer")
+	}
+
+	//registering again from the same peer keeps it
+	_, replaced = manager.replaceClient(id.Hash(), ClientInfo{WhiteNoiseID: id, PeerID: second, expire: time.Now().Add(time.Minute)})
+	assert.Equal(t, replaced, false)
+	_, ok = manager.RenewClient(second, time.Minute)
+	assert.Equal(t, ok, true)
+}
+
+func TestDelegation(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, cr.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pub.GetWhiteNoiseID()
+	proxyId := peer.ID("proxy")
+	//a lease of an hour reaches into the next epoch
+	delegations, err := NewDelegations(priv, proxyId, ProxySerivceTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := DestEpoch(time.Now())
+	assert.Equal(t, len(delegations), 2)
+	for i, delegation := range delegations {
+		assert.Equal(t, delegation.Epoch, epoch+int64(i))
+		assert.Equal(t, VerifyDelegation(id, proxyId, delegation.Epoch, delegation.Sig), nil)
+	}
+
+	sig := delegations[0].Sig
+	assert.Equal(t, VerifyDelegation(id, peer.ID("other"), epoch, sig), ErrInvalidDelegation)
+	assert.Equal(t, VerifyDelegation(id, proxyId, epoch+1, sig), ErrInvalidDelegation)
+	_, other, _ := crypto.GenerateKeyPair(crypto.Ed25519, cr.Reader)
+	assert.Equal(t, VerifyDelegation(other.GetWhiteNoiseID(), proxyId, epoch, sig), ErrInvalidDelegation)
+	//a registration signature is no delegation
+	challengeSig, _ := priv.Sign(ChallengePayload(make([]byte, ChallengeNonceLength), proxyId, id.String()))
+	assert.Equal(t, VerifyDelegation(id, proxyId, epoch, challengeSig), ErrInvalidDelegation)
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	nonce := make([]byte, ChallengeNonceLength)
+	cr.Read(nonce)
+	proxyId := peer.ID("proxy")
+	for _, keyType := range []int{crypto.Ed25519, crypto.Secpk1, crypto.ECDSA} {
+		priv, pub, err := crypto.GenerateKeyPair(keyType, cr.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := pub.GetWhiteNoiseID()
+		p2pPriv, _, err := priv.GetP2PKeypair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := p2pPriv.Sign(ChallengePayload(nonce, proxyId, id.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyChallenge(id, nonce, proxyId, sig); err != nil {
+			t.Fatalf("key type %v: %v", keyType, err)
+		}
+		//signed for another proxy
+		assert.Equal(t, verifyChallenge(id, nonce, peer.ID("other"), sig), ErrInvalidSignature)
+
+		//someone else's WhiteNoiseID
+		_, other, _ := crypto.GenerateKeyPair(keyType, cr.Reader)
+		assert.Equal(t, verifyChallenge(other.GetWhiteNoiseID(), nonce, proxyId, sig), ErrInvalidSignature)
+	}
+}
+
+func TestCheckNeg(t *testing.T) {
+	answer := NewProxyService(nil, context.Background(), nil, nil, nil)
+	proxy := NewProxyService(nil, context.Background(), nil, nil, nil)
+	for _, keyType := range []int{crypto.Ed25519, crypto.Secpk1, crypto.ECDSA} {
+		priv, _, err := crypto.GenerateKeyPair(keyType, cr.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		neg := pb.Negotiate{Join: "joint", Destination: "des", Hops: 1, Cookie: "cookie", SessionId: "session"}
+		if err := SignNeg(&neg, priv); err != nil {
+			t.Fatal(err)
+		}
+		//the answer checks the signature, then drops it with the caller and the session id
+		if err := answer.acceptNeg(&neg); err != nil {
+			t.Fatalf("key type %v: %v", keyType, err)
+		}
+		assert.Equal(t, answer.acceptNeg(&neg), ErrReplayedNeg)
+		stripped := proto.Clone(&neg).(*pb.Negotiate)
+		stripped.SessionId, stripped.Caller, stripped.Sig = "", "", nil
+		//its proxy only checks freshness and replays
+		assert.Equal(t, proxy.checkNeg(stripped), nil)
+		assert.Equal(t, proxy.checkNeg(stripped), ErrReplayedNeg)
+		assert.Equal(t, VerifyNeg(stripped), ErrInvalidNegSig)
+
+		tampered := proto.Clone(&neg).(*pb.Negotiate)
+		tampered.Join = "attacker"
+		assert.Equal(t, VerifyNeg(tampered), ErrInvalidNegSig)
+
+		stale := pb.Negotiate{Join: "joint", Destination: "des"}
+		SignNeg(&stale, priv)
+		stale.Timestamp = time.Now().Add(-2 * common.NegMaxAge).UnixNano()
+		assert.Equal(t, VerifyNeg(&stale), ErrStaleNeg)
+		assert.Equal(t, proxy.checkNeg(&stale), ErrStaleNeg)
+		noNonce := pb.Negotiate{Join: "joint", Destination: "des", Timestamp: time.Now().UnixNano()}
+		assert.Equal(t, proxy.checkNeg(&noNonce), ErrStaleNeg)
+
+		unsigned := pb.Negotiate{Join: "joint", Destination: "des", Timestamp: time.Now().UnixNano(), Nonce: make([]byte, NegNonceLength)}
+		assert.Equal(t, VerifyNeg(&unsigned), ErrInvalidNegSig)
+	}
+}
+
+func TestSignatureDomains(t *testing.T) {
+	//a signature over one payload is never valid over the other, whatever the fields are
+	neg := NegPayload(&pb.Negotiate{})
+	challenge := ChallengePayload(make([]byte, ChallengeNonceLength), peer.ID("proxy"), "id")
+	assert.Equal(t, bytes.HasPrefix(challenge, []byte(challengeDomain)), true)
+	assert.Equal(t, bytes.HasPrefix(neg[4:], []byte(negDomain)), true)
+	assert.Equal(t, bytes.HasPrefix(challenge, neg[:4]), false)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
 	"github.com/Evanesco-Labs/WhiteNoise/network/session"
 	core "github.com/libp2p/go-libp2p-core"
+	"time"
 )
 
 type ReqGetSession struct {
@@ -30,11 +31,47 @@ type ReqNewSessiontoPeer struct {
 	PeerID    core.PeerID
 	SessionID string
 	MyRole    common.SessionRole
-	OtherRole common.SessionRole
+	OtherRole    common.SessionRole
+	Cookie       string
+	SealedCookie []byte
+}
+
+type ReqGetSessionByHop struct {
+	Peer      core.PeerID
+	CircuitId string
+}
+
+type ReqRendezvous struct {
+	Cookie string
+}
+
+type ResRendezvous struct {
+	SessionId string
+}
+
+type ReqExpectAnswer struct {
+	Cookie    string
+	SessionId string
 }
 
 type ReqCloseCircuit struct {
 	SessionId string
+	Reason    DisconnectReason
+}
+
+//ReqCloseCircuitsWith closes the circuits with a hop to Peer that have one of CircuitIds, or all of them if CircuitIds is nil.
+type ReqCloseCircuitsWith struct {
+	Peer       core.PeerID
+	CircuitIds []string
+	Reason     DisconnectReason
+}
+
+//ReqAwaitAnswer closes the session with ReasonDestinationNotFound if its circuit is still set up after Timeout. If
+//Fallback is set it is called instead the first time, and the session gets another Timeout.
+type ReqAwaitAnswer struct {
+	SessionId string
+	Timeout   time.Duration
+	Fallback  func()
 }
 
 type ReqSendRelay struct {
@@ -42,6 +79,16 @@ type ReqSendRelay struct {
 	Data      []byte
 }
 
+type ReqSendProbe struct {
+	SessionId string
+	Key       string
+}
+
+type ReqSetProbeKey struct {
+	SessionId string
+	Key       string
+}
+
 type ReqHandleStreamClosed struct {
 	StreamId string
 }
@@ -55,22 +102,41 @@ func (manager *RelayMsgManager) Receive(ctx actor.Context) {
 			Ok:      ok,
 		})
 	case ReqNewSessiontoPeer:
-		err := manager.NewSessionToPeer(msg.PeerID, msg.SessionID, msg.MyRole, msg.OtherRole)
+		err := manager.NewSessionToPeerContext(manager.context, msg.PeerID, msg.SessionID, msg.MyRole, msg.OtherRole, msg.Cookie, msg.SealedCookie)
 		ctx.Respond(ResError{Err: err})
+	case ReqGetSessionByHop:
+		sess, ok := manager.GetSessionByHop(msg.Peer, msg.CircuitId)
+		ctx.Respond(ResGetSession{
+			Session: sess,
+			Ok:      ok,
+		})
+	case ReqRendezvous:
+		ctx.Respond(ResRendezvous{SessionId: manager.Rendezvous(msg.Cookie)})
+	case ReqExpectAnswer:
+		ctx.Respond(ResError{Err: manager.ExpectAnswer(msg.Cookie, msg.SessionId)})
 	case ReqSendRelay:
 		err := manager.SendRelay(msg.SessionId, msg.Data)
 		ctx.Respond(ResError{Err: err})
+	case ReqSendProbe:
+		err := manager.SendProbe(msg.SessionId, msg.Key)
+		ctx.Respond(ResError{Err: err})
+	case ReqSetProbeKey:
+		manager.SetProbeKey(msg.SessionId, msg.Key)
 	case ReqAddSessionID:
 		manager.AddSessionId(msg.Id, msg.Session)
 	case ReqCloseCircuit:
-		err := manager.CloseCircuit(msg.SessionId)
+		err := manager.CloseCircuit(msg.SessionId, msg.Reason)
 		if err != nil {
 			log.Warn("Close circuit err", err)
 		}
+	case ReqAwaitAnswer:
+		manager.AwaitAnswer(msg.SessionId, msg.Timeout, msg.Fallback)
+	case ReqCloseCircuitsWith:
+		manager.CloseCircuitsWith(msg.Peer, msg.CircuitIds, msg.Reason)
 	case ReqHandleStreamClosed:
 		if info, ok := manager.GetStream(msg.StreamId); ok {
 			if info.sessionID != "" {
-				manager.CloseCircuit(info.sessionID)
+				manager.CloseCircuit(info.sessionID, ReasonRelayFailure)
 			}
 			manager.DeleteStream(msg.StreamId)
 		}
@@ -1,11 +1,12 @@
 package relay
 
 import (
-	"bytes"
 	"context"
-	"github.com/asaskevich/EventBus"
 	"github.com/magiconair/properties/assert"
+	"io"
+	"runtime"
 	"testing"
+	"time"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/secure"
 )
@@ -14,11 +15,7 @@ func TestCircuitConn(t *testing.T) {
 	parentCtx := context.Background()
 	ctx, cancel := context.WithCancel(parentCtx)
 	circuit := CircuitConn{
-		buffer: SafeBuffer{
-			b:           new(bytes.Buffer),
-			eventBus:    EventBus.New(),
-			readTimeout: common.UnreadableTimeout,
-		},
+		buffer: NewSafeBuffer(common.CircuitConnWindow),
 		ctx:    ctx,
 		cancel: cancel,
 	}
@@ -30,3 +27,181 @@ func TestCircuitConn(t *testing.T) {
 	}
 	assert.Equal(t, msg, recMsg)
 }
+
+func TestCircuitConnWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	window := 8
+	circuit := CircuitConn{
+		buffer:       NewSafeBuffer(window),
+		ctx:          ctx,
+		cancel:       cancel,
+		window:       window,
+		sendWindow:   window,
+		creditCh:     make(chan struct{}, 1),
+		writeTimeout: 10 * time.Millisecond,
+	}
+
+	n, err := circuit.acquireCredit(10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, window)
+	_, err = circuit.acquireCredit(1)
+	assert.Equal(t, err, ErrWindowFull)
+
+	//credit is taken only once all of the wanted bytes fit
+	circuit.AddCredit(4)
+	_, err = circuit.acquireCredit(6)
+	assert.Equal(t, err, ErrWindowFull)
+	n, err = circuit.acquireCredit(4)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 4)
+
+	assert.Equal(t, circuit.InboundMsg(make([]byte, window)), nil)
+	assert.Equal(t, circuit.InboundMsg([]byte{1}), ErrWindowOverflow)
+}
+
+func TestCircuitConnWriteStall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	window := 8
+	circuit := CircuitConn{
+		buffer:       NewSafeBuffer(window),
+		ctx:          ctx,
+		cancel:       cancel,
+		window:       window,
+		sendWindow:   0,
+		creditCh:     make(chan struct{}, 1),
+		writeTimeout: 100 * time.Millisecond,
+	}
+
+	//credit for half of the frame comes back, the frame is not sent in part
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		circuit.AddCredit(window / 2)
+	}()
+	n, err := circuit.Write(make([]byte, window))
+	assert.Equal(t, err, ErrWindowFull)
+	assert.Equal(t, n, 0)
+	assert.Equal(t, circuit.hasCredit(window/2), true)
+}
+
+func TestCircuitConnNoRemoteWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	window := 8
+	circuit := CircuitConn{
+		buffer:       NewSafeBuffer(window),
+		ctx:          ctx,
+		cancel:       cancel,
+		window:       window,
+		sendWindow:   window,
+		creditCh:     make(chan struct{}, 1),
+		writeTimeout: 10 * time.Millisecond,
+	}
+	_, err := circuit.acquireCredit(window)
+	assert.Equal(t, err, nil)
+
+	//an old remote end sends no credit and ignores our window
+	circuit.setRemoteWindow(0)
+	n, err := circuit.acquireCredit(10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 10)
+	assert.Equal(t, circuit.hasCredit(100), true)
+	assert.Equal(t, circuit.InboundMsg(make([]byte, 2*window)), nil)
+}
+
+func TestSafeBufferReadBlocks(t *testing.T) {
+	buffer := NewSafeBuffer(common.CircuitConnWindow)
+	read := make(chan string, 1)
+	go func() {
+		p := make([]byte, 8)
+		n, _ := buffer.Read(p)
+		read <- string(p[:n])
+	}()
+	select {
+	case <-read:
+		t.Fatal("read returned without data")
+	case <-time.After(100 * time.Millisecond):
+	}
+	buffer.Write([]byte("late"))
+	assert.Equal(t, <-read, "late")
+}
+
+func TestSafeBufferClose(t *testing.T) {
+	buffer := NewSafeBuffer(common.CircuitConnWindow)
+	buffer.Write([]byte("tail"))
+	go buffer.Close()
+
+	//buffered data is still delivered before EOF
+	p := make([]byte, 8)
+	n, err := buffer.Read(p)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(p[:n]), "tail")
+	_, err = buffer.Read(p)
+	assert.Equal(t, err, io.EOF)
+	_, err = buffer.Write([]byte("late"))
+	assert.Equal(t, err, ErrCircuitClosed)
+}
+
+func newBenchCircuitConn() *CircuitConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CircuitConn{
+		buffer: NewSafeBuffer(common.CircuitConnWindow),
+		ctx:    ctx,
+		cancel: cancel,
+		window: common.CircuitConnWindow,
+	}
+}
+
+//BenchmarkCircuitConnLatency measures one small message from InboundMsg to a blocked reader.
+func BenchmarkCircuitConnLatency(b *testing.B) {
+	circuit := newBenchCircuitConn()
+	defer circuit.Close()
+	msg := make([]byte, 64)
+	done := make(chan struct{})
+	count := b.N
+	go func() {
+		p := make([]byte, len(msg))
+		for i := 0; i < count; i++ {
+			if _, err := io.ReadFull(circuit, p); err != nil {
+				b.Error(err)
+				return
+			}
+			done <- struct{}{}
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		circuit.InboundMsg(msg)
+		<-done
+	}
+}
+
+//BenchmarkCircuitConnThroughput streams relay sized messages into a CircuitConn while one reader drains it.
+func BenchmarkCircuitConnThroughput(b *testing.B) {
+	circuit := newBenchCircuitConn()
+	defer circuit.Close()
+	msg := make([]byte, 4096)
+	b.SetBytes(int64(len(msg)))
+	done := make(chan struct{})
+	total := len(msg) * b.N
+	go func() {
+		defer close(done)
+		p := make([]byte, 32*1024)
+		for total > 0 {
+			n, err := circuit.Read(p)
+			if err != nil && err != io.EOF {
+				b.Error(err)
+				return
+			}
+			total -= n
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for circuit.InboundMsg(msg) == ErrWindowOverflow {
+			runtime.Gosched()
+		}
+	}
+	<-done
+}
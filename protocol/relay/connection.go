@@ -3,13 +3,15 @@ package relay
 import (
 	"bytes"
 	"context"
-	"github.com/asaskevich/EventBus"
+	"errors"
 	"io"
 	"sync"
 	"time"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
 	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/secure"
 )
 
 type CircuitConnState int
@@ -19,6 +21,7 @@ const (
 	CircuitConnReady
 )
 
+//Deprecated: SafeBuffer no longer wakes readers through an EventBus, the topics are not published.
 const (
 	NonEmptyTopic = "NonEmptyTopic"
 	EmptyTopic    = "EmptyTopic"
@@ -26,69 +29,177 @@ const (
 	NonFullTopic  = "NonFullTopic"
 )
 
+var (
+	ErrWindowFull     = errors.New("circuit send window full")
+	ErrWindowOverflow = errors.New("circuit receive window overflow")
+	ErrCircuitClosed  = errors.New("circuit closed")
+)
+
+//SafeBuffer is the receive queue of a CircuitConn. Both ends touch the bytes only under mut,
+//and a writer always leaves a token in notEmpty, so a reader that found the queue empty
+//cannot miss data written right after it checked.
 type SafeBuffer struct {
-	b           *bytes.Buffer
-	mut         sync.RWMutex
-	eventBus    EventBus.Bus
-	readTimeout time.Duration
+	b        *bytes.Buffer
+	mut      sync.Mutex
+	notEmpty chan struct{}
+	done     chan struct{}
+	closed   bool
+	capacity int
+}
+
+func NewSafeBuffer(capacity int) *SafeBuffer {
+	return &SafeBuffer{
+		b:        new(bytes.Buffer),
+		notEmpty: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		capacity: capacity,
+	}
 }
 
-//Using event bus to read synchronously.
+func (b *SafeBuffer) signal() {
+	select {
+	case b.notEmpty <- struct{}{}:
+	default:
+	}
+}
+
+//tryRead returns ok false if there is nothing to read and the buffer is still open.
+func (b *SafeBuffer) tryRead(p []byte) (n int, ok bool, err error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.b.Len() == 0 {
+		if b.closed {
+			return 0, true, io.EOF
+		}
+		return 0, false, nil
+	}
+	n, _ = b.b.Read(p)
+	if b.b.Len() > 0 {
+		//pass the wakeup on to any other reader
+		b.signal()
+	}
+	return n, true, nil
+}
+
+//Read blocks until data arrives or the buffer is closed.
 func (b *SafeBuffer) Read(p []byte) (n int, err error) {
-	n, err = b.b.Read(p)
-	if err != nil {
-		if err == io.EOF {
-			wait := make(chan struct{})
-			timeout := time.After(b.readTimeout)
-			b.eventBus.SubscribeOnce(NonEmptyTopic, func() {
-				n, err = b.b.Read(p)
-				wait <- struct{}{}
-			})
-			select {
-			case <-wait:
-			case <-timeout:
-				log.Error("connection unreadable timeout")
-			}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if n, ok, err := b.tryRead(p); ok {
+			return n, err
+		}
+		select {
+		case <-b.notEmpty:
+		case <-b.done:
 		}
-		return n, err
 	}
-	return n, nil
 }
 
+//Write refuses data beyond capacity, the remote sender must respect the credit it was given.
 func (b *SafeBuffer) Write(p []byte) (n int, err error) {
-	return b.b.Write(p)
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.closed {
+		return 0, ErrCircuitClosed
+	}
+	if b.capacity > 0 && b.b.Len()+len(p) > b.capacity {
+		return 0, ErrWindowOverflow
+	}
+	n, err = b.b.Write(p)
+	b.signal()
+	return n, err
+}
+
+//Close wakes blocked readers, they get io.EOF once the buffered data is drained.
+func (b *SafeBuffer) Close() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+}
+
+func (b *SafeBuffer) Len() int {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.b.Len()
+}
+
+//SetCapacity changes how much data the buffer holds, 0 lifts the limit.
+func (b *SafeBuffer) SetCapacity(capacity int) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.capacity = capacity
 }
 
+//Deprecated: Read blocks until data arrives or the buffer is closed, use the deadlines of the sdk connection instead.
 func (b *SafeBuffer) SetReadTimeout(duration time.Duration) {
-	b.readTimeout = duration
 }
 
 type CircuitConn struct {
 	localWhiteNoiseID  crypto.WhiteNoiseID
 	remoteWhiteNoiseId crypto.WhiteNoiseID
-	buffer             SafeBuffer
+	buffer             *SafeBuffer
 	sessionId          string
 	relayMananger      *RelayMsgManager
 	ctx                context.Context
 	cancel             context.CancelFunc
 	state              CircuitConnState
+
+	//credit based flow control, both ends start with the same window and advertise it in the secure handshake.
+	//noCredit is set if the remote end is too old to send credit.
+	noCredit     bool
+	window       int
+	sendWindow   int
+	consumed     int
+	creditMut    sync.Mutex
+	creditCh     chan struct{}
+	writeTimeout time.Duration
+
+	//cells are asked for by the caller and negotiated in the secure handshake, a cell is never split across relay messages
+	wantCells bool
+	cellSize  int
+	cover     config.CoverConfig
+
+	//dummy cells from the remote end are dropped on arrival, they never take buffer space nor credit
+	coverKey []byte
+	dummies  *secure.DummyFilter
+
+	//protocol is sent to the answer in the secure handshake
+	protocol string
+}
+
+//CircuitOptions are the settings a caller picks for its circuit. A disabled Cover uses the node default.
+//MixHops is how many of the relay hops must be mixing nodes. Protocol tells the answer what the circuit is for.
+type CircuitOptions struct {
+	Cells    bool
+	Cover    config.CoverConfig
+	MixHops  int
+	Protocol string
 }
 
 func (manager *RelayMsgManager) NewCircuitConn(parentCtx context.Context, sessionID string, remote crypto.WhiteNoiseID) *CircuitConn {
 	ctx, cancel := context.WithCancel(parentCtx)
+	coverKey := secure.NewCoverKey()
 	circuit := CircuitConn{
 		localWhiteNoiseID:  manager.Account.GetPublicKey().GetWhiteNoiseID(),
 		remoteWhiteNoiseId: remote,
-		buffer: SafeBuffer{
-			b:           new(bytes.Buffer),
-			eventBus:    EventBus.New(),
-			readTimeout: common.UnreadableTimeout,
-		},
-		relayMananger: manager,
-		ctx:           ctx,
-		cancel:        cancel,
-		sessionId:     sessionID,
-		state:         CircuitConnBuilding,
+		buffer:             NewSafeBuffer(common.CircuitConnWindow),
+		relayMananger:      manager,
+		ctx:                ctx,
+		cancel:             cancel,
+		sessionId:          sessionID,
+		state:              CircuitConnBuilding,
+		window:             common.CircuitConnWindow,
+		sendWindow:         common.CircuitConnWindow,
+		creditCh:           make(chan struct{}, 1),
+		writeTimeout:       common.CircuitConnWriteTimeout,
+		cover:              manager.CircuitCover,
+		coverKey:           coverKey,
+		dummies:            secure.NewDummyFilter(coverKey),
 	}
 	return &circuit
 }
@@ -98,25 +209,146 @@ func (c *CircuitConn) State() CircuitConnState {
 }
 
 func (c *CircuitConn) Read(b []byte) (n int, err error) {
-	return c.buffer.Read(b)
+	n, err = c.buffer.Read(b)
+	if n > 0 {
+		c.grantCredit(n)
+	}
+	return n, err
+}
+
+//grantCredit returns consumed buffer space to the remote sender once a quarter of the window is read.
+func (c *CircuitConn) grantCredit(n int) {
+	c.creditMut.Lock()
+	c.consumed += n
+	if c.noCredit || c.consumed < c.window/4 || c.relayMananger == nil {
+		c.creditMut.Unlock()
+		return
+	}
+	credit := c.consumed
+	c.consumed = 0
+	c.creditMut.Unlock()
+
+	err := c.relayMananger.SendRelay(c.sessionId, NewCredit(credit))
+	if err != nil {
+		log.Error("send credit err", err)
+	}
+}
+
+//AddCredit is called when the remote end has read data and frees window for us to send.
+func (c *CircuitConn) AddCredit(n int) {
+	c.creditMut.Lock()
+	c.sendWindow += n
+	if c.sendWindow > c.window {
+		c.sendWindow = c.window
+	}
+	c.creditMut.Unlock()
+	select {
+	case c.creditCh <- struct{}{}:
+	default:
+	}
+}
+
+//hasCredit reports if n bytes can be sent without waiting for credit.
+func (c *CircuitConn) hasCredit(n int) bool {
+	c.creditMut.Lock()
+	defer c.creditMut.Unlock()
+	return c.noCredit || c.sendWindow >= n
+}
+
+//setRemoteWindow is called with the window the remote end advertised in the secure handshake. An old end that
+//advertises none never sends credit nor keeps to our window, so neither side of the circuit is limited.
+func (c *CircuitConn) setRemoteWindow(window int) {
+	if window > 0 {
+		return
+	}
+	c.creditMut.Lock()
+	c.noCredit = true
+	c.creditMut.Unlock()
+	c.buffer.SetCapacity(0)
+	select {
+	case c.creditCh <- struct{}{}:
+	default:
+	}
+}
+
+//acquireCredit blocks until the remote window has room for all of want, or the whole window if want is larger,
+//and takes at most want bytes of it.
+func (c *CircuitConn) acquireCredit(want int) (int, error) {
+	timeout := time.NewTimer(c.writeTimeout)
+	defer timeout.Stop()
+	least := want
+	if least > c.window {
+		least = c.window
+	}
+	for {
+		c.creditMut.Lock()
+		if c.noCredit {
+			c.creditMut.Unlock()
+			return want, nil
+		}
+		if c.sendWindow >= least {
+			n := want
+			if n > c.sendWindow {
+				n = c.sendWindow
+			}
+			c.sendWindow -= n
+			c.creditMut.Unlock()
+			return n, nil
+		}
+		c.creditMut.Unlock()
+
+		select {
+		case <-c.creditCh:
+		case <-c.ctx.Done():
+			return 0, ErrCircuitClosed
+		case <-timeout.C:
+			return 0, ErrWindowFull
+		}
+	}
 }
 
+//Write sends b once the remote window has room for all of it, so a Noise frame is never left half sent when credit
+//runs out. Only a write larger than the window goes out in parts, and the circuit is closed if it stalls half way.
 func (c *CircuitConn) Write(b []byte) (int, error) {
-	msg := NewRelayMsg(b, c.sessionId)
-	err := c.relayMananger.SendRelay(c.sessionId, msg)
-	return len(b), err
+	written := 0
+	for written < len(b) {
+		want := len(b) - written
+		if c.cellSize > 0 && want > c.cellSize {
+			want = c.cellSize
+		}
+		n, err := c.acquireCredit(want)
+		if err != nil {
+			if written > 0 && err == ErrWindowFull {
+				c.relayMananger.CloseCircuit(c.sessionId, ReasonRelayFailure)
+			}
+			return written, err
+		}
+		msg := NewRelayMsg(b[written : written+n])
+		err = c.relayMananger.SendRelay(c.sessionId, msg)
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
 }
 
-func (c *CircuitConn) InboundMsg(b []byte) {
-	_, err := c.buffer.Write(b)
-	c.buffer.eventBus.Publish(NonEmptyTopic)
-	if err != nil {
-		log.Error("inbound msg buffer write", err)
+func (c *CircuitConn) InboundMsg(b []byte) error {
+	if c.dummies.Drop(b) {
+		return nil
 	}
+	_, err := c.buffer.Write(b)
+	return err
+}
+
+//sendDummy sends a dummy cell of the secure session, it is dropped before the remote buffer and takes no credit.
+func (c *CircuitConn) sendDummy(cell []byte) error {
+	return c.relayMananger.SendRelay(c.sessionId, NewRelayMsg(cell))
 }
 
 func (c *CircuitConn) Close() error {
 	c.cancel()
+	c.buffer.Close()
 	return nil
 }
 
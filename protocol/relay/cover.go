@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"context"
+	cr "crypto/rand"
+	"crypto/sha256"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	"github.com/Evanesco-Labs/WhiteNoise/secure"
+	"github.com/golang/protobuf/proto"
+	"math/rand"
+	"time"
+)
+
+//coverInterval returns the wait before the next dummy cell.
+func coverInterval(cfg config.CoverConfig, r *rand.Rand) time.Duration {
+	mean := float64(time.Second) / cfg.Rate
+	if cfg.Mode == config.CoverPoisson {
+		return time.Duration(r.ExpFloat64() * mean)
+	}
+	return time.Duration(mean)
+}
+
+//runCover calls send at the cover rate until ctx is done or send fails.
+func runCover(ctx context.Context, cfg config.CoverConfig, send func() error) {
+	if !cfg.Enabled() {
+		return
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	timer := time.NewTimer(coverInterval(cfg, r))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := send(); err != nil {
+				log.Debugf("stop cover traffic: %v", err)
+				return
+			}
+			timer.Reset(coverInterval(cfg, r))
+		}
+	}
+}
+
+//startLinkCover sends hop-by-hop dummy cells on a relay stream, the neighbour drops them.
+func (manager *RelayMsgManager) startLinkCover(s session.Stream) {
+	if !manager.LinkCover.Enabled() {
+		return
+	}
+	go runCover(s.Ctx, manager.LinkCover, func() error {
+		return s.RW.WriteMsg(NewDummyCell())
+	})
+}
+
+//startCircuitCover sends end-to-end dummy cells on a circuit. They are relayed as normal data and only the
+//remote circuit can tell them apart and drop them. Nothing is sent without cells or to an end that would not drop them.
+func (manager *RelayMsgManager) startCircuitCover(conn *CircuitConn, secureConn *secure.SecureSession) {
+	if !conn.cover.Enabled() {
+		return
+	}
+	if !secureConn.CoverSupported() {
+		log.Debugf("no cover traffic on circuit %v: %v", conn.sessionId, secure.ErrCoverNotSupported)
+		return
+	}
+	go runCover(conn.ctx, conn.cover, func() error {
+		cell, err := secureConn.NewDummy()
+		if err != nil {
+			return err
+		}
+		return conn.sendDummy(cell)
+	})
+}
+
+//NewDummyCell is a wake message of the size of a data message carrying a cell, used as hop-by-hop cover traffic.
+func NewDummyCell() []byte {
+	padding := make([]byte, common.CircuitCellSize)
+	cr.Read(padding)
+	msg := pb.RelayMsg{
+		CircuitId: NewCircuitId(),
+		Data:      padding,
+	}
+	data, _ := proto.Marshal(&msg)
+	relay := pb.Relay{
+		Id:   "",
+		Type: pb.Relaytype_Wake,
+		Data: data,
+	}
+	relayNoId, _ := proto.Marshal(&relay)
+	hash := sha256.Sum256(relayNoId)
+	relay.Id = secure.EncodeMSGIDHash(hash[:])
+	relayBytes, _ := proto.Marshal(&relay)
+	return relayBytes
+}
@@ -0,0 +1,60 @@
+package relay
+
+import (
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	"strconv"
+)
+
+//DisconnectReason is carried in the errCode of Disconnect signals along the circuit, it tells both ends why the circuit
+//was torn down.
+type DisconnectReason int32
+
+const (
+	ReasonUnknown           DisconnectReason = iota
+	ReasonRemoteClosed                       //an end closed the circuit
+	ReasonRelayFailure                       //a node of the circuit failed to forward or lost a stream to its hop
+	ReasonProxyUnregistered                  //the client of the circuit unregistered from or lost its proxy
+	ReasonIdleTimeout                        //the circuit was idle for too long
+	ReasonSetupTimeout                       //the circuit was not set up in time
+	ReasonSetupFailed                        //no path could be built for the circuit
+	ReasonPolicyRejected                     //a node refused the circuit or a peer broke the rules of the circuit
+	ReasonPingTimeout                        //an end declared the circuit dead after its keepalive pings went unanswered
+	ReasonDestinationNotFound                //the destination did not answer the negotiation of the circuit or refused it
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case ReasonUnknown:
+		return "unknown"
+	case ReasonRemoteClosed:
+		return "remote closed"
+	case ReasonRelayFailure:
+		return "relay failure"
+	case ReasonProxyUnregistered:
+		return "proxy unregistered"
+	case ReasonIdleTimeout:
+		return "idle timeout"
+	case ReasonSetupTimeout:
+		return "setup timeout"
+	case ReasonSetupFailed:
+		return "setup failed"
+	case ReasonPolicyRejected:
+		return "policy rejected"
+	case ReasonPingTimeout:
+		return "ping timeout"
+	case ReasonDestinationNotFound:
+		return "destination not found"
+	default:
+		return "reason " + strconv.Itoa(int(r))
+	}
+}
+
+//circuitClosed publishes the reason the circuit is torn down at its ends, before the connections of the session are
+//closed, so readers see the reason rather than a bare EOF.
+func (manager *RelayMsgManager) circuitClosed(sess session.Session, reason DisconnectReason) {
+	if sess.Role != common.CallerRole && sess.Role != common.AnswerRole {
+		return
+	}
+	manager.eb.Publish(common.CircuitClosedTopic, sess.Id, reason)
+}
@@ -0,0 +1,225 @@
+package relay
+
+import (
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//States of a session in its lifecycle. A session is in setup until the circuit success signal or the first data passes.
+const (
+	SessionSetup int32 = iota
+	SessionReady
+)
+
+//activity is the lifecycle of one local session, lastActive is updated on every message of the session.
+type activity struct {
+	state      int32
+	created    time.Time
+	lastActive int64
+}
+
+//orphanKey names an entry that only lives as long as some session, and is reaped once it outlives setup without one.
+type orphanKey struct {
+	kind string
+	id   string
+}
+
+func (manager *RelayMsgManager) track(sessionId string) *activity {
+	now := time.Now()
+	v, _ := manager.activityMap.LoadOrStore(sessionId, &activity{created: now, lastActive: now.UnixNano()})
+	return v.(*activity)
+}
+
+//touch records activity of the session, it keeps the session from being reaped as idle.
+func (manager *RelayMsgManager) touch(sessionId string) {
+	atomic.StoreInt64(&manager.track(sessionId).lastActive, time.Now().UnixNano())
+}
+
+//markReady ends the setup of the session.
+func (manager *RelayMsgManager) markReady(sessionId string) {
+	a := manager.track(sessionId)
+	atomic.StoreInt32(&a.state, SessionReady)
+	atomic.StoreInt64(&a.lastActive, time.Now().UnixNano())
+}
+
+//SessionState returns the lifecycle state of the session and when it was last active.
+func (manager *RelayMsgManager) SessionState(sessionId string) (int32, time.Time, bool) {
+	v, ok := manager.activityMap.Load(sessionId)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	a := v.(*activity)
+	return atomic.LoadInt32(&a.state), time.Unix(0, atomic.LoadInt64(&a.lastActive)), true
+}
+
+//expired reports if the session has outlived its setup or has been idle for too long.
+func (manager *RelayMsgManager) expired(a *activity, now time.Time) (bool, DisconnectReason) {
+	if atomic.LoadInt32(&a.state) == SessionSetup && now.Sub(a.created) > manager.SessionSetupTimeout {
+		return true, ReasonSetupTimeout
+	}
+	if now.Sub(time.Unix(0, atomic.LoadInt64(&a.lastActive))) > manager.SessionIdleTimeout {
+		return true, ReasonIdleTimeout
+	}
+	return false, ReasonUnknown
+}
+
+//orphaned reports if the entry key, which has no session, has been seen without one for longer than setup may take.
+func (manager *RelayMsgManager) orphaned(key orphanKey, now time.Time) bool {
+	v, _ := manager.orphanMap.LoadOrStore(key, now)
+	if now.Sub(v.(time.Time)) <= manager.SessionSetupTimeout {
+		return false
+	}
+	manager.orphanMap.Delete(key)
+	return true
+}
+
+//AwaitAnswer closes the circuit with ReasonDestinationNotFound if it is still in setup after timeout. The entry calls it
+//once the negotiation is on its way, a destination that never answers or refuses the negotiation leaves the circuit
+//in setup. If fallback is not nil it is called the first time instead, to send the negotiation another way, and the
+//circuit gets another timeout.
+func (manager *RelayMsgManager) AwaitAnswer(sessionId string, timeout time.Duration, fallback func()) {
+	time.AfterFunc(timeout, func() {
+		if !manager.hasSession(sessionId) || atomic.LoadInt32(&manager.track(sessionId).state) != SessionSetup {
+			return
+		}
+		if fallback != nil {
+			log.Infof("Negotiation of circuit %v not answered in %v, try another way", sessionId, timeout)
+			fallback()
+			manager.AwaitAnswer(sessionId, timeout, nil)
+			return
+		}
+		log.Infof("Negotiation of circuit %v not answered in %v", sessionId, timeout)
+		manager.CloseCircuit(sessionId, ReasonDestinationNotFound)
+	})
+}
+
+func (manager *RelayMsgManager) hasSession(sessionId string) bool {
+	_, ok := manager.sessionMap.Load(sessionId)
+	return ok
+}
+
+func (manager *RelayMsgManager) runReaper() {
+	ticker := time.NewTicker(manager.SessionReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-manager.context.Done():
+			return
+		case <-ticker.C:
+			manager.reap(time.Now())
+		}
+	}
+}
+
+func (manager *RelayMsgManager) reapConns(kind string, conns *sync.Map, now time.Time) {
+	conns.Range(func(key, value interface{}) bool {
+		sessionId := key.(string)
+		if !manager.hasSession(sessionId) && manager.orphaned(orphanKey{kind, sessionId}, now) {
+			log.Infof("Reap %v connection of session %v", kind, sessionId)
+			manager.RemoveSession(sessionId)
+		}
+		return true
+	})
+}
+
+//reap closes expired sessions with Disconnect signals to both hops, and drops the entries left behind by sessions that
+//never completed or are already gone.
+func (manager *RelayMsgManager) reap(now time.Time) {
+	manager.sessionMap.Range(func(key, value interface{}) bool {
+		sessionId := key.(string)
+		if ok, reason := manager.expired(manager.track(sessionId), now); ok {
+			log.Infof("Reap session %v: %v", sessionId, reason)
+			manager.CloseCircuit(sessionId, reason)
+		}
+		return true
+	})
+
+	//circuit and secure connections whose session never came up or is gone
+	manager.reapConns("circuit", &manager.circuitConnMap, now)
+	manager.reapConns("secure", &manager.secureConnMap, now)
+
+	manager.activityMap.Range(func(key, value interface{}) bool {
+		sessionId := key.(string)
+		if manager.hasSession(sessionId) {
+			return true
+		}
+		if _, ok := manager.circuitConnMap.Load(sessionId); ok {
+			return true
+		}
+		if now.Sub(value.(*activity).created) > manager.SessionSetupTimeout {
+			manager.activityMap.Delete(sessionId)
+		}
+		return true
+	})
+
+	manager.probeMap.Range(func(key, value interface{}) bool {
+		if !manager.hasSession(key.(string)) {
+			manager.probeMap.Delete(key)
+		}
+		return true
+	})
+
+	manager.pingHopMap.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(pingHop).sent) > pingHopTimeout {
+			manager.pingHopMap.Delete(key)
+		}
+		return true
+	})
+
+	manager.streamMap.Range(func(key, value interface{}) bool {
+		info := value.(StreamInfo)
+		if info.sessionID != "" && manager.hasSession(info.sessionID) {
+			return true
+		}
+		if manager.orphaned(orphanKey{"stream", key.(string)}, now) {
+			log.Debugf("Reap stream %v", key)
+			info.stream.Close()
+			manager.streamMap.Delete(key)
+		}
+		return true
+	})
+
+	//rendezvous cookies of circuits that were never completed
+	manager.cookieMap.Range(func(key, value interface{}) bool {
+		if !manager.hasSession(value.(string)) && manager.orphaned(orphanKey{"cookie", key.(string)}, now) {
+			manager.cookieMap.Delete(key)
+		}
+		return true
+	})
+	manager.answerMap.Range(func(key, value interface{}) bool {
+		if manager.orphaned(orphanKey{"answer", key.(string)}, now) {
+			manager.answerMap.Delete(key)
+		}
+		return true
+	})
+
+	//forget orphans that found their session
+	manager.orphanMap.Range(func(key, value interface{}) bool {
+		k := key.(orphanKey)
+		switch k.kind {
+		case "circuit":
+			if _, ok := manager.circuitConnMap.Load(k.id); !ok || manager.hasSession(k.id) {
+				manager.orphanMap.Delete(key)
+			}
+		case "secure":
+			if _, ok := manager.secureConnMap.Load(k.id); !ok || manager.hasSession(k.id) {
+				manager.orphanMap.Delete(key)
+			}
+		case "stream":
+			if v, ok := manager.streamMap.Load(k.id); !ok || manager.hasSession(v.(StreamInfo).sessionID) {
+				manager.orphanMap.Delete(key)
+			}
+		case "cookie":
+			if v, ok := manager.cookieMap.Load(k.id); !ok || manager.hasSession(v.(string)) {
+				manager.orphanMap.Delete(key)
+			}
+		case "answer":
+			if _, ok := manager.answerMap.Load(k.id); !ok {
+				manager.orphanMap.Delete(key)
+			}
+		}
+		return true
+	})
+}
@@ -3,12 +3,14 @@ package relay
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
 	"github.com/AsynkronIT/protoactor-go/actor"
 	"github.com/asaskevich/EventBus"
 	core "github.com/libp2p/go-libp2p-core"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/mr-tron/base58"
 	"sync"
 	"time"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
@@ -28,39 +30,66 @@ type StreamInfo struct {
 	sessionID string
 }
 
+//hopKey is the inbound side of a forwarding table entry, a circuit id is scoped to the link with one peer.
+type hopKey struct {
+	peer      core.PeerID
+	circuitId string
+}
+
 type RelayMsgManager struct {
-	circuitConnMap    sync.Map
-	secureConnMap     sync.Map
-	streamMap         sync.Map
-	sessionMap        sync.Map
-	probeMap          sync.Map
-	ackPid            *actor.PID
-	relayPid          *actor.PID
-	host              core.Host
-	actorCtx          *actor.RootContext
-	context           context.Context
-	role              config.ServiceMode
-	privateKey        crypto.PrivKey
-	SetSessionTimeout time.Duration
-	Account           *account.Account
-	eb                EventBus.Bus
+	circuitConnMap      sync.Map
+	secureConnMap       sync.Map
+	streamMap           sync.Map
+	sessionMap          sync.Map
+	probeMap            sync.Map
+	hopMap              sync.Map //hopKey -> local session id
+	cookieMap           sync.Map //rendezvous cookie -> local session id, at the joint and exit node
+	probeKeyMap         sync.Map //local session id -> key of the probes of the exit half, at its relays
+	answerMap           sync.Map //rendezvous cookie -> end-to-end session id, at the answer
+	activityMap         sync.Map //local session id -> *activity
+	orphanMap           sync.Map //orphanKey -> time the reaper first saw it without a session
+	pingMap             sync.Map //pingKey -> chan of the Ping waiting for its Pong
+	pingHopMap          sync.Map //pingKey of a forwarded Ping -> pingHop, at relays
+	ackPid              *actor.PID
+	relayPid            *actor.PID
+	host                core.Host
+	actorCtx            *actor.RootContext
+	context             context.Context
+	role                config.ServiceMode
+	privateKey          crypto.PrivKey
+	SetSessionTimeout   time.Duration
+	SessionSetupTimeout time.Duration
+	SessionIdleTimeout  time.Duration
+	SessionReapInterval time.Duration
+	LinkCover           config.CoverConfig
+	CircuitCover        config.CoverConfig
+	Mix                 config.MixConfig
+	mixer               *mixer
+	Account             *account.Account
+	eb                  EventBus.Bus
 }
 
 func NewRelayMsgManager(host core.Host, ctx context.Context, actCtx *actor.RootContext, role config.ServiceMode, privateKey crypto.PrivKey, acc *account.Account, eb EventBus.Bus) *RelayMsgManager {
 	return &RelayMsgManager{
-		circuitConnMap:    sync.Map{},
-		secureConnMap:     sync.Map{},
-		streamMap:         sync.Map{},
-		sessionMap:        sync.Map{},
-		probeMap:          sync.Map{},
-		host:              host,
-		actorCtx:          actCtx,
-		context:           ctx,
-		role:              role,
-		SetSessionTimeout: common.SetSessionTimeout,
-		privateKey:        privateKey,
-		Account:           acc,
-		eb:                eb,
+		circuitConnMap:      sync.Map{},
+		secureConnMap:       sync.Map{},
+		streamMap:           sync.Map{},
+		sessionMap:          sync.Map{},
+		probeMap:            sync.Map{},
+		hopMap:              sync.Map{},
+		cookieMap:           sync.Map{},
+		answerMap:           sync.Map{},
+		host:                host,
+		actorCtx:            actCtx,
+		context:             ctx,
+		role:                role,
+		SetSessionTimeout:   common.SetSessionTimeout,
+		SessionSetupTimeout: common.SessionSetupTimeout,
+		SessionIdleTimeout:  common.SessionIdleTimeout,
+		SessionReapInterval: common.SessionReapInterval,
+		privateKey:          privateKey,
+		Account:             acc,
+		eb:                  eb,
 	}
 }
 
@@ -69,6 +98,7 @@ func (manager *RelayMsgManager) Start() {
 		return manager
 	})
 	manager.relayPid = manager.actorCtx.Spawn(props)
+	go manager.runReaper()
 }
 
 func (manager *RelayMsgManager) Pid() *actor.PID {
@@ -80,7 +110,9 @@ func (manager *RelayMsgManager) SetPid(ackPid *actor.PID) {
 }
 
 func (manager *RelayMsgManager) RemoveSession(sessionId string) {
+	secureReady := false
 	if v, ok := manager.secureConnMap.Load(sessionId); ok {
+		secureReady = true
 		v.(*secure.SecureSession).Close()
 		manager.secureConnMap.Delete(sessionId)
 	}
@@ -88,17 +120,28 @@ func (manager *RelayMsgManager) RemoveSession(sessionId string) {
 	if v, ok := manager.circuitConnMap.Load(sessionId); ok {
 		v.(*CircuitConn).Close()
 		manager.circuitConnMap.Delete(sessionId)
+		if !secureReady {
+			manager.eb.Publish(common.NewSecureConnFailedTopic, sessionId, ErrCircuitNotReady)
+		}
 	}
 
 	if sess, ok := manager.sessionMap.Load(sessionId); ok {
 		sess := sess.(session.Session)
-		for _, stream := range sess.Pair {
+		for i, stream := range sess.Pair {
 			stream.Close()
+			manager.hopMap.Delete(hopKey{peer: stream.RemotePeer, circuitId: sess.CircuitIds[i]})
 		}
 		manager.sessionMap.Delete(sessionId)
 	}
+	manager.cookieMap.Range(func(key, value interface{}) bool {
+		if value.(string) == sessionId {
+			manager.cookieMap.Delete(key)
+		}
+		return true
+	})
 	manager.probeMap.Delete(sessionId)
-
+	manager.probeKeyMap.Delete(sessionId)
+	manager.activityMap.Delete(sessionId)
 }
 
 func (manager *RelayMsgManager) GetCircuit(sessionId string) (*CircuitConn, bool) {
@@ -117,10 +160,16 @@ func (manager *RelayMsgManager) GetSecureConn(sessionId string) (*secure.SecureS
 	return v.(*secure.SecureSession), ok
 }
 
-func (manager *RelayMsgManager) AddCircuitConnCaller(sessionId string, remote crypto2.WhiteNoiseID) {
+func (manager *RelayMsgManager) AddCircuitConnCaller(sessionId string, remote crypto2.WhiteNoiseID, opts CircuitOptions) {
 	_, ok := manager.circuitConnMap.Load(sessionId)
 	if !ok {
 		conn := manager.NewCircuitConn(manager.context, sessionId, remote)
+		if opts.Cover.Enabled() {
+			conn.cover = opts.Cover
+		}
+		//dummy cells only look like data among cells
+		conn.wantCells = opts.Cells || conn.cover.Enabled()
+		conn.protocol = opts.Protocol
 		manager.circuitConnMap.Store(sessionId, conn)
 	}
 }
@@ -133,6 +182,105 @@ func (manager *RelayMsgManager) AddCircuitConnAnswer(sessionId string) {
 	}
 }
 
+//GetSessionByHop finds the session of the hop with peer that uses circuitId.
+func (manager *RelayMsgManager) GetSessionByHop(peer core.PeerID, circuitId string) (session.Session, bool) {
+	v, ok := manager.hopMap.Load(hopKey{peer: peer, circuitId: circuitId})
+	if !ok {
+		return session.Session{}, false
+	}
+	return manager.GetSession(v.(string))
+}
+
+//Rendezvous returns the local session id for a rendezvous cookie, if this node is also the joint
+//of the circuit it is the session already joined from the entry side.
+func (manager *RelayMsgManager) Rendezvous(cookie string) string {
+	v, _ := manager.cookieMap.LoadOrStore(cookie, NewSessionKey())
+	return v.(string)
+}
+
+//cookieOf returns the rendezvous cookie of a joint session.
+func (manager *RelayMsgManager) cookieOf(sessionId string) (string, bool) {
+	cookie := ""
+	manager.cookieMap.Range(func(key, value interface{}) bool {
+		if value.(string) == sessionId {
+			cookie = key.(string)
+			return false
+		}
+		return true
+	})
+	return cookie, cookie != ""
+}
+
+//SetProbeKey gives a relay of the exit half the key it derives the probe of its next hop with.
+func (manager *RelayMsgManager) SetProbeKey(sessionId string, key string) {
+	manager.probeKeyMap.Store(sessionId, key)
+}
+
+//SendProbe sends the probe derived from key on every hop of the session.
+func (manager *RelayMsgManager) SendProbe(sessionId string, key string) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("SendProbe err: %v", err)
+			manager.CloseCircuit(sessionId, ReasonRelayFailure)
+		}
+	}()
+	sess, ok := manager.GetSession(sessionId)
+	if !ok {
+		return errors.New("SendProbe no such session")
+	}
+	for i, stream := range sess.GetPair() {
+		data, err := NewProbeSignal(key, sess.CircuitIds[i])
+		if err != nil {
+			return err
+		}
+		err = stream.RW.WriteMsg(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SealCookie encrypts the rendezvous cookie to the joint, the relays that carry it there cannot read it.
+func SealCookie(joint crypto.PubKey, cookie string) ([]byte, error) {
+	if joint == nil {
+		return nil, errors.New("no public key of joint node")
+	}
+	id, err := crypto2.WhiteNoiseIDfromString(crypto2.WhiteNoiseIDFromP2PPK(joint))
+	if err != nil {
+		return nil, err
+	}
+	pk, err := id.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return pk.ECIESEncrypt([]byte(cookie), rand.Reader)
+}
+
+func (manager *RelayMsgManager) openCookie(sealed []byte) (string, error) {
+	cookie, err := manager.Account.GetPrivateKey().ECIESDecrypt(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(cookie), nil
+}
+
+var ErrAlreadyAnswered = errors.New("circuit already answered")
+
+//ExpectAnswer lets the answer accept the hop from its exit node carrying cookie as session sessionId. A client
+//registered at several proxies is asked by each of them, only the first is accepted.
+func (manager *RelayMsgManager) ExpectAnswer(cookie string, sessionId string) error {
+	if _, ok := manager.GetSession(sessionId); ok {
+		return ErrAlreadyAnswered
+	}
+	if _, loaded := manager.answerMap.LoadOrStore(cookie, sessionId); loaded {
+		return ErrAlreadyAnswered
+	}
+	return nil
+}
+
+//handleProbe gets the checked probes of the joint session, each hop has its own so the second different one comes
+//from the other half of the circuit.
 func (manager *RelayMsgManager) handleProbe(sessionProbe session.Probe) {
 	v, ok := manager.probeMap.Load(sessionProbe.SessionId)
 	if !ok {
@@ -140,16 +288,15 @@ func (manager *RelayMsgManager) handleProbe(sessionProbe session.Probe) {
 		return
 	}
 	p := v.(session.Probe)
-	if bytes.Equal(p.Rand, sessionProbe.Rand) {
+	if !bytes.Equal(p.Rand, sessionProbe.Rand) {
+		manager.markReady(sessionProbe.SessionId)
 		//circuit success
 		log.Debug("send circuit success signal")
-		data := NewCircuitSuccess(sessionProbe.SessionId)
+		data := NewCircuitSuccess()
 		err := manager.SendRelay(sessionProbe.SessionId, data)
 		if err != nil {
 			log.Error(err)
 		}
-	} else {
-		manager.CloseCircuit(sessionProbe.SessionId)
 	}
 }
 
@@ -210,19 +357,24 @@ func (manager *RelayMsgManager) SendRelay(sessionid string, data []byte) (err er
 	defer func() {
 		if err != nil {
 			log.Errorf("SendRelay err: %v", err)
-			manager.CloseCircuit(sessionid)
+			manager.CloseCircuit(sessionid, ReasonRelayFailure)
 		}
 	}()
 	sess, ok := manager.GetSession(sessionid)
 	if !ok {
 		return errors.New("SendRelay no such session")
 	}
+	manager.touch(sessionid)
 
 	if len(sess.GetPair()) == 0 {
 		return errors.New("stream pair in this session is empty")
 	}
-	for _, stream := range sess.GetPair() {
-		err := stream.RW.WriteMsg(data)
+	for i, stream := range sess.GetPair() {
+		out, err := withCircuitId(data, sess.CircuitIds[i])
+		if err != nil {
+			return err
+		}
+		err = stream.RW.WriteMsg(out)
 		if err != nil {
 			log.Error("write err", err)
 			return err
@@ -235,21 +387,26 @@ func (manager *RelayMsgManager) ForwardRelay(sessionId string, data []byte, from
 	defer func() {
 		if err != nil {
 			log.Errorf("SendRelay err: %v", err)
-			manager.CloseCircuit(sessionId)
+			manager.CloseCircuit(sessionId, ReasonRelayFailure)
 		}
 	}()
 	sess, ok := manager.GetSession(sessionId)
 	if !ok {
 		return errors.New("SendRelay no such session")
 	}
+	manager.touch(sessionId)
 	if len(sess.GetPair()) == 0 {
 		return errors.New("stream pair in this session is empty")
 	}
-	for _, stream := range sess.GetPair() {
+	for i, stream := range sess.GetPair() {
 		if stream.RemotePeer == from {
 			continue
 		}
-		err := stream.RW.WriteMsg(data)
+		out, err := withCircuitId(data, sess.CircuitIds[i])
+		if err != nil {
+			return err
+		}
+		err = stream.RW.WriteMsg(out)
 		if err != nil {
 			log.Error("write err", err)
 			return err
@@ -258,8 +415,8 @@ func (manager *RelayMsgManager) ForwardRelay(sessionId string, data []byte, from
 	return nil
 }
 
-func (manager *RelayMsgManager) SendDisconnectRelay(sessionId string) (err error) {
-	disData, err := NewDisconnect(sessionId)
+func (manager *RelayMsgManager) SendDisconnectRelay(sessionId string, reason DisconnectReason) (err error) {
+	disData, err := NewDisconnect(reason)
 	if err != nil {
 		return err
 	}
@@ -271,8 +428,12 @@ func (manager *RelayMsgManager) SendDisconnectRelay(sessionId string) (err error
 	if len(sess.GetPair()) == 0 {
 		return errors.New("stream pair in this session is empty")
 	}
-	for _, stream := range sess.GetPair() {
-		err := stream.RW.WriteMsg(disData)
+	for i, stream := range sess.GetPair() {
+		out, err := withCircuitId(disData, sess.CircuitIds[i])
+		if err != nil {
+			continue
+		}
+		err = stream.RW.WriteMsg(out)
 		if err != nil {
 			log.Debug("write err", err)
 			continue
@@ -281,22 +442,55 @@ func (manager *RelayMsgManager) SendDisconnectRelay(sessionId string) (err error
 	return nil
 }
 
-func (manager *RelayMsgManager) CloseCircuit(sessionId string) error {
-	log.Infof("Close circuit %v", sessionId)
+//CloseCircuit tears down the circuit of the session, reason is sent to the other nodes of the circuit and told to the
+//local end.
+func (manager *RelayMsgManager) CloseCircuit(sessionId string, reason DisconnectReason) error {
+	log.Infof("Close circuit %v: %v", sessionId, reason)
 	defer func() { manager.RemoveSession(sessionId) }()
-	_, ok := manager.sessionMap.Load(sessionId)
+	sess, ok := manager.GetSession(sessionId)
 	if !ok {
 		return errors.New("no such session")
 	}
-	err := manager.SendDisconnectRelay(sessionId)
+	manager.circuitClosed(sess, reason)
+	err := manager.SendDisconnectRelay(sessionId, reason)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+//CloseCircuitsWith closes the circuits with a hop to peer that have one of circuitIds, or all of them if circuitIds is nil.
+//Disconnect is sent along both hops of each circuit, so the other nodes free them without waiting for stream errors.
+func (manager *RelayMsgManager) CloseCircuitsWith(peer core.PeerID, circuitIds []string, reason DisconnectReason) {
+	sessionIds := make(map[string]bool)
+	if circuitIds == nil {
+		manager.hopMap.Range(func(key, value interface{}) bool {
+			if key.(hopKey).peer == peer {
+				sessionIds[value.(string)] = true
+			}
+			return true
+		})
+	}
+	for _, circuitId := range circuitIds {
+		if v, ok := manager.hopMap.Load(hopKey{peer: peer, circuitId: circuitId}); ok {
+			sessionIds[v.(string)] = true
+		}
+	}
+	for sessionId := range sessionIds {
+		err := manager.CloseCircuit(sessionId, reason)
+		if err != nil {
+			log.Debugf("Close circuit %v with %v err: %v", sessionId, peer, err)
+		}
+	}
+}
+
 func (manager *RelayMsgManager) NewRelayStream(peerID core.PeerID) (string, error) {
-	stream, err := manager.host.NewStream(manager.context, peerID, protocol.ID(RelayProtocol))
+	return manager.newRelayStream(manager.context, peerID)
+}
+
+//newRelayStream opens a relay stream to peerID, ctx only bounds opening the stream.
+func (manager *RelayMsgManager) newRelayStream(ctx context.Context, peerID core.PeerID) (string, error) {
+	stream, err := manager.host.NewStream(ctx, peerID, protocol.ID(RelayProtocol))
 	if err != nil {
 		log.Infof("newstream to %v error: %v\n", peerID, err)
 		return "", err
@@ -311,28 +505,38 @@ func (manager *RelayMsgManager) NewRelayStream(peerID core.PeerID) (string, erro
 		log.Error("write err", err)
 		return "", err
 	}
+	manager.startLinkCover(s)
 	return s.StreamId, nil
 }
 
-func (manager *RelayMsgManager) NewSessionToPeer(peerID core.PeerID, sessionID string, myRole common.SessionRole, otherRole common.SessionRole) error {
-	streamId, err := manager.NewRelayStream(peerID)
+//NewSessionToPeer adds a new hop to peerID to the local session sessionID. The cookie is only needed by a joint
+//or an answer on the other end.
+func (manager *RelayMsgManager) NewSessionToPeer(peerID core.PeerID, sessionID string, myRole common.SessionRole, otherRole common.SessionRole, cookie string) error {
+	return manager.NewSessionToPeerContext(manager.context, peerID, sessionID, myRole, otherRole, cookie, nil)
+}
+
+//NewSessionToPeerContext is NewSessionToPeer, but gives up opening the stream to peerID once ctx is done.
+//A relay extending to the joint gives the cookie sealed to the joint instead.
+func (manager *RelayMsgManager) NewSessionToPeerContext(ctx context.Context, peerID core.PeerID, sessionID string, myRole common.SessionRole, otherRole common.SessionRole, cookie string, sealedCookie []byte) error {
+	streamId, err := manager.newRelayStream(ctx, peerID)
 	if err != nil {
 		return err
 	}
-	err = manager.SetSessionId(sessionID, streamId, myRole, otherRole)
+	err = manager.SetSessionId(sessionID, streamId, myRole, otherRole, cookie, sealedCookie)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (manager *RelayMsgManager) SetSessionId(sessionID string, streamID string, myRole common.SessionRole, otherRole common.SessionRole) error {
+func (manager *RelayMsgManager) SetSessionId(sessionID string, streamID string, myRole common.SessionRole, otherRole common.SessionRole, cookie string, sealedCookie []byte) error {
 	streamInfo, ok := manager.GetStream(streamID)
 	if !ok {
 		return errors.New("no such stream:" + streamID)
 	}
 	stream := streamInfo.stream
-	data, id := NewSetSessionIDCommand(sessionID, otherRole)
+	circuitId := NewCircuitId()
+	data, id := NewSetSessionIDCommand(circuitId, otherRole, cookie, sealedCookie)
 	err := stream.RW.WriteMsg(data)
 	if err != nil {
 		log.Error("write err", err)
@@ -357,9 +561,10 @@ func (manager *RelayMsgManager) SetSessionId(sessionID string, streamID string,
 				s.SetSessionID(sessionID)
 				s.Role = myRole
 			}
-			s.AddStream(stream)
+			s.AddStream(stream, circuitId)
 			manager.AddSessionId(sessionID, s)
 			manager.AddStreamSessionID(streamID, sessionID)
+			manager.hopMap.Store(hopKey{peer: stream.RemotePeer, circuitId: circuitId}, sessionID)
 			log.Infof("session: %v\n", s)
 			return nil
 		} else {
@@ -368,11 +573,33 @@ func (manager *RelayMsgManager) SetSessionId(sessionID string, streamID string,
 	}
 }
 
-func (manager *RelayMsgManager) GetSessionIDList() []string {
-	sessionList := make([]string, 0)
-	manager.sessionMap.Range(func(key, value interface{}) bool {
-		sessionList = append(sessionList, key.(string))
+//GetCircuitIdsWith lists the circuit ids of all hops to peer.
+func (manager *RelayMsgManager) GetCircuitIdsWith(peer core.PeerID) []string {
+	circuitIds := make([]string, 0)
+	manager.hopMap.Range(func(key, value interface{}) bool {
+		if key.(hopKey).peer == peer {
+			circuitIds = append(circuitIds, key.(hopKey).circuitId)
+		}
 		return true
 	})
-	return sessionList
+	return circuitIds
+}
+
+func randomId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base58.Encode(b)
+}
+
+//NewSessionKey generates a local session id, it never leaves this node.
+func NewSessionKey() string {
+	return randomId()
+}
+
+func NewCircuitId() string {
+	return randomId()
+}
+
+func NewCookie() string {
+	return randomId()
 }
@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"context"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	core "github.com/libp2p/go-libp2p-core"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//MixProtocol is registered by nodes that mix the data they relay, other nodes learn it through identify.
+const MixProtocol string = "/relay/mix"
+
+//SupportsMix reports if peer advertises mixing, as far as identify has told us.
+func SupportsMix(h core.Host, id core.PeerID) bool {
+	protos, err := h.Peerstore().SupportsProtocols(id, MixProtocol)
+	return err == nil && len(protos) > 0
+}
+
+//mixKey is one direction of one circuit, messages with the same key keep their order.
+type mixKey struct {
+	sessionId string
+	from      string
+}
+
+type mixMsg struct {
+	key     mixKey
+	release time.Time
+	send    func()
+}
+
+//mixer holds forwarded messages to break the timing link between inbound and outbound messages of a relay.
+type mixer struct {
+	cfg    config.MixConfig
+	mut    sync.Mutex
+	rand   *rand.Rand
+	queued int
+
+	//MixDelay: one queue and one sending goroutine per key
+	queues map[mixKey][]mixMsg
+
+	//MixBatch: flushed batches wait in out and are sent one after another by a single goroutine
+	batch []mixMsg
+	timer *time.Timer
+	out   [][]mixMsg
+	ready chan struct{}
+}
+
+func newMixer(ctx context.Context, cfg config.MixConfig) *mixer {
+	m := &mixer{
+		cfg:    cfg,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		queues: make(map[mixKey][]mixMsg),
+		ready:  make(chan struct{}, 1),
+	}
+	if cfg.Mode == config.MixBatch {
+		go m.sendBatches(ctx)
+	}
+	return m
+}
+
+//push queues send. When the mixer is full messages are no longer delayed, but still keep their order.
+func (m *mixer) push(key mixKey, send func()) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	msg := mixMsg{key: key, send: send}
+	m.queued++
+	if m.cfg.Mode == config.MixBatch {
+		m.batch = append(m.batch, msg)
+		if len(m.batch) >= m.cfg.BatchSize || m.queued >= common.MixMaxQueued {
+			m.flushLocked()
+		} else if m.timer == nil {
+			m.timer = time.AfterFunc(m.cfg.Delay, m.flush)
+		}
+		return
+	}
+
+	delay := time.Duration(m.rand.ExpFloat64() * float64(m.cfg.Delay))
+	if m.queued >= common.MixMaxQueued {
+		delay = 0
+	}
+	msg.release = time.Now().Add(delay)
+	q, running := m.queues[key]
+	if len(q) > 0 && msg.release.Before(q[len(q)-1].release) {
+		msg.release = q[len(q)-1].release
+	}
+	m.queues[key] = append(q, msg)
+	if !running {
+		go m.drain(key)
+	}
+}
+
+func (m *mixer) drain(key mixKey) {
+	for {
+		m.mut.Lock()
+		q := m.queues[key]
+		if len(q) == 0 {
+			delete(m.queues, key)
+			m.mut.Unlock()
+			return
+		}
+		msg := q[0]
+		m.mut.Unlock()
+
+		time.Sleep(time.Until(msg.release))
+		msg.send()
+
+		m.mut.Lock()
+		m.queues[key] = m.queues[key][1:]
+		m.queued--
+		m.mut.Unlock()
+	}
+}
+
+func (m *mixer) flush() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.flushLocked()
+}
+
+func (m *mixer) flushLocked() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	if len(m.batch) == 0 {
+		return
+	}
+	batch := interleave(m.batch, m.rand)
+	m.batch = nil
+	m.queued -= len(batch)
+	//never block under mut, the sender takes the batches from out
+	m.out = append(m.out, batch)
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mixer) sendBatches(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.ready:
+		}
+		m.mut.Lock()
+		out := m.out
+		m.out = nil
+		m.mut.Unlock()
+		for _, batch := range out {
+			for _, msg := range batch {
+				msg.send()
+			}
+		}
+	}
+}
+
+//interleave returns the batch in random order, but keeps the order of messages with the same key.
+func interleave(batch []mixMsg, r *rand.Rand) []mixMsg {
+	keys := make([]mixKey, len(batch))
+	groups := make(map[mixKey][]mixMsg)
+	for i, msg := range batch {
+		keys[i] = msg.key
+		groups[msg.key] = append(groups[msg.key], msg)
+	}
+	//a random permutation of the keys is a random interleaving of the groups
+	r.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	out := make([]mixMsg, len(batch))
+	for i, key := range keys {
+		out[i] = groups[key][0]
+		groups[key] = groups[key][1:]
+	}
+	return out
+}
+
+//mixes reports if messages of sess are mixed here, only nodes that forward them do.
+func (manager *RelayMsgManager) mixes(sess session.Session) bool {
+	return manager.mixer != nil && sess.Role != common.CallerRole && sess.Role != common.AnswerRole
+}
+
+//SetMix turns on mixing of forwarded data messages, it must be called before the node starts relaying.
+func (manager *RelayMsgManager) SetMix(cfg config.MixConfig) {
+	if !cfg.Enabled() {
+		return
+	}
+	if cfg.Mode == config.MixBatch && cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	manager.Mix = cfg
+	manager.mixer = newMixer(manager.context, cfg)
+}
@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	"github.com/Evanesco-Labs/WhiteNoise/secure"
+	"github.com/golang/protobuf/proto"
+	"time"
+)
+
+var ErrNotEndpoint = errors.New("not an end of the circuit")
+
+type pingKey struct {
+	sessionId string
+	nonce     uint64
+}
+
+//pingHop is the nonce a forwarded Ping arrived with, its Pong goes back with it.
+type pingHop struct {
+	nonce uint64
+	sent  time.Time
+}
+
+//pingHopTimeout is how long a relay waits for the Pong of a Ping it forwarded.
+const pingHopTimeout = time.Minute
+
+func randomNonce() (uint64, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func newPingMsg(relayType pb.Relaytype, nonce uint64) []byte {
+	ping := pb.PingMsg{
+		Nonce: nonce,
+	}
+	data, _ := proto.Marshal(&ping)
+	relay := pb.Relay{
+		Id:   "",
+		Type: relayType,
+		Data: data,
+	}
+	dataNoId, _ := proto.Marshal(&relay)
+	hash := sha256.Sum256(dataNoId)
+	relay.Id = secure.EncodeMSGIDHash(hash[:])
+	relayData, _ := proto.Marshal(&relay)
+	return relayData
+}
+
+func NewPing(nonce uint64) []byte {
+	return newPingMsg(pb.Relaytype_Ping, nonce)
+}
+
+func NewPong(nonce uint64) []byte {
+	return newPingMsg(pb.Relaytype_Pong, nonce)
+}
+
+//Ping sends a Ping to the other end of the circuit and returns the round trip time once its Pong is back.
+//Only the caller and the answer of a circuit can ping.
+func (manager *RelayMsgManager) Ping(ctx context.Context, sessionId string) (time.Duration, error) {
+	sess, ok := manager.GetSession(sessionId)
+	if !ok {
+		return 0, errors.New("no such session")
+	}
+	if sess.Role != common.CallerRole && sess.Role != common.AnswerRole {
+		return 0, ErrNotEndpoint
+	}
+	nonce, err := randomNonce()
+	if err != nil {
+		return 0, err
+	}
+	key := pingKey{sessionId: sessionId, nonce: nonce}
+	pong := make(chan struct{}, 1)
+	manager.pingMap.Store(key, pong)
+	defer manager.pingMap.Delete(key)
+
+	start := time.Now()
+	err = manager.SendRelay(sessionId, NewPing(key.nonce))
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case <-pong:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+//handlePing answers a Ping at the end of a circuit, hands a Pong to the waiting Ping, and forwards both elsewhere.
+//Relays give every Ping they forward a new nonce and restore the old one on its Pong, so a nonce is only seen on one hop.
+func (manager *RelayMsgManager) handlePing(relay *pb.Relay, s session.Stream, data []byte) error {
+	var ping pb.PingMsg
+	err := proto.Unmarshal(relay.Data, &ping)
+	if err != nil {
+		return err
+	}
+	sess, ok := manager.lookupHop(s, ping.CircuitId)
+	if !ok {
+		return errors.New("no such circuit " + ping.CircuitId)
+	}
+	manager.touch(sess.Id)
+	if sess.Role == common.CallerRole || sess.Role == common.AnswerRole {
+		if relay.Type == pb.Relaytype_Ping {
+			return manager.SendRelay(sess.Id, NewPong(ping.Nonce))
+		}
+		if v, ok := manager.pingMap.Load(pingKey{sessionId: sess.Id, nonce: ping.Nonce}); ok {
+			select {
+			case v.(chan struct{}) <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+	if !sess.IsReady() {
+		return errors.New("session not ready " + sess.Id)
+	}
+	if relay.Type == pb.Relaytype_Ping {
+		nonce, err := manager.rewritePing(sess.Id, ping.Nonce)
+		if err != nil {
+			return err
+		}
+		return manager.forward(sess, s, NewPing(nonce))
+	}
+	nonce, ok := manager.restorePong(sess.Id, ping.Nonce)
+	if !ok {
+		return errors.New("no ping for pong on " + sess.Id)
+	}
+	return manager.forward(sess, s, NewPong(nonce))
+}
+
+//rewritePing returns the nonce to forward a Ping of the session with, and remembers the nonce it came with.
+func (manager *RelayMsgManager) rewritePing(sessionId string, nonce uint64) (uint64, error) {
+	out, err := randomNonce()
+	if err != nil {
+		return 0, err
+	}
+	manager.pingHopMap.Store(pingKey{sessionId: sessionId, nonce: out}, pingHop{nonce: nonce, sent: time.Now()})
+	return out, nil
+}
+
+//restorePong returns the nonce the Ping answered by a Pong with nonce came with.
+func (manager *RelayMsgManager) restorePong(sessionId string, nonce uint64) (uint64, bool) {
+	v, ok := manager.pingHopMap.LoadAndDelete(pingKey{sessionId: sessionId, nonce: nonce})
+	if !ok {
+		return 0, false
+	}
+	return v.(pingHop).nonce, true
+}
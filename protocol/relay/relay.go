@@ -1,10 +1,11 @@
 package relay
 
 import (
-	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
 	"github.com/golang/protobuf/proto"
+	"github.com/mr-tron/base58"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/common/config"
@@ -22,6 +23,7 @@ func (manager *RelayMsgManager) RelayStreamHandler(stream network.Stream) {
 	str := session.NewStream(stream, manager.context)
 	manager.AddStream(str)
 	go manager.RelayInboundHandler(str)
+	manager.startLinkCover(str)
 }
 
 func (manager *RelayMsgManager) RelayInboundHandler(s session.Stream) {
@@ -64,6 +66,20 @@ func (manager *RelayMsgManager) RelayInboundHandler(s session.Stream) {
 			}
 			continue
 
+		case pb.Relaytype_Credit:
+			err = manager.handleCredit(&relay, s, msgBytes)
+			if err != nil {
+				log.Error("Handle credit err ", err)
+			}
+			continue
+
+		case pb.Relaytype_Ping, pb.Relaytype_Pong:
+			err = manager.handlePing(&relay, s, msgBytes)
+			if err != nil {
+				log.Warn("Handle ping err ", err)
+			}
+			continue
+
 		case pb.Relaytype_Probe:
 			err = manager.handleRelayProbe(&relay, s, msgBytes)
 			if err != nil {
@@ -78,7 +94,7 @@ func (manager *RelayMsgManager) RelayInboundHandler(s session.Stream) {
 			}
 		case pb.Relaytype_Ack:
 		case pb.Relaytype_Wake:
-			log.Debug("Stream awake")
+			//also hop-by-hop cover traffic, never forwarded
 		case pb.Relaytype_Success:
 			log.Debug("Receive circuit success signal")
 			go func() {
@@ -108,57 +124,55 @@ func (manager *RelayMsgManager) handleSetSession(relay *pb.Relay, s session.Stre
 		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: s.RemotePeer})
 		return errors.New("setSessionIdMsg unmarshall err " + err.Error())
 	}
+	role := common.SessionRole(setSession.Role)
 
 	//Client reject Sessions for Server Role
-	if manager.role == config.ClientMode && common.SessionRole(setSession.Role) != common.AnswerRole {
+	if manager.role == config.ClientMode && role != common.AnswerRole {
 		ackMsg.Data = []byte("reject")
 		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: s.RemotePeer})
 		return errors.New("reject")
 	}
 
-	if manager.role == config.ClientMode && common.SessionRole(setSession.Role) == common.AnswerRole {
-		manager.AddCircuitConnAnswer(setSession.SessionId)
-	}
-
-	//act as both entry and relay
-	//1. tell join node stream closed 2. remove the stream to joint node 3. add stream to exit node 4. response success to clients 5. ignore session expend command
-	sess, ok := manager.GetSession(setSession.SessionId)
-	if ok && sess.Role == common.EntryRole && common.SessionRole(setSession.Role) == common.RelayRole {
-		log.Warnf("act as both entry and relay for session %v", setSession.SessionId)
-		if sess.IsReady() {
-			//tell join node to close circuit
-			streamJoin := sess.Pair[1]
-			disData, _ := NewDisconnect(setSession.SessionId)
-			streamJoin.RW.WriteMsg(disData)
-			//remove the stream to joint node
-			manager.DeleteStream(streamJoin.StreamId)
-			sess.Pair[1] = s
-			manager.AddSessionId(setSession.SessionId, sess)
-			manager.AddStream(s)
-			manager.AddStreamSessionID(s.StreamId, setSession.SessionId)
-			log.Debugf("add sessionid %v to stream %v\n", setSession.SessionId, s.StreamId)
-			log.Debugf("session: %v\n", sess)
-			//respond ack to exit node
-			ackMsg.Result = true
+	key := hopKey{peer: s.RemotePeer, circuitId: setSession.CircuitId}
+	if _, ok := manager.hopMap.Load(key); ok || setSession.CircuitId == "" {
+		ackMsg.Data = []byte("invalid circuit id")
+		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: s.RemotePeer})
+		return errors.New("invalid circuit id " + setSession.CircuitId)
+	}
+
+	cookie := setSession.Cookie
+	if len(setSession.SealedCookie) != 0 && role == common.JointRole {
+		cookie, err = manager.openCookie(setSession.SealedCookie)
+		if err != nil {
+			ackMsg.Data = []byte("invalid sealed cookie")
 			manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: s.RemotePeer})
-			return nil
-		} else {
-			manager.CloseCircuit(setSession.SessionId)
-			return errors.New("create circuit as entry node failed")
+			return err
 		}
 	}
+	sessionId, err := manager.sessionForHop(role, cookie)
+	if err != nil {
+		ackMsg.Data = []byte(err.Error())
+		manager.actorCtx.Request(manager.ackPid, ack.ReqAck{Ack: &ackMsg, PeerId: s.RemotePeer})
+		return err
+	}
 
+	if role == common.AnswerRole {
+		manager.AddCircuitConnAnswer(sessionId)
+	}
+
+	sess, ok := manager.GetSession(sessionId)
 	if !ok {
 		sess = session.NewSession()
-		sess.SetSessionID(setSession.SessionId)
-		sess.Role = common.SessionRole(setSession.Role)
+		sess.SetSessionID(sessionId)
+		sess.Role = role
 	}
-	sess.AddStream(s)
-	manager.AddSessionId(setSession.SessionId, sess)
+	sess.AddStream(s, setSession.CircuitId)
+	manager.AddSessionId(sessionId, sess)
 	manager.AddStream(s)
-	manager.AddStreamSessionID(s.StreamId, setSession.SessionId)
+	manager.AddStreamSessionID(s.StreamId, sessionId)
+	manager.hopMap.Store(key, sessionId)
 
-	log.Debugf("add sessionId %v to stream %v\n", setSession.SessionId, s.StreamId)
+	log.Debugf("add circuit %v of session %v to stream %v\n", setSession.CircuitId, sessionId, s.StreamId)
 	log.Debugf("session: %v\n", sess)
 
 	ackMsg.Result = true
@@ -166,6 +180,54 @@ func (manager *RelayMsgManager) handleSetSession(relay *pb.Relay, s session.Stre
 	return nil
 }
 
+//sessionForHop picks the local session a new inbound hop belongs to. Only the joint joins two hops into one session,
+//matched by the rendezvous cookie, and only the answer maps its hop to the end-to-end session id it has decrypted.
+func (manager *RelayMsgManager) sessionForHop(role common.SessionRole, cookie string) (string, error) {
+	switch {
+	case role == common.AnswerRole:
+		v, ok := manager.answerMap.Load(cookie)
+		if !ok {
+			return "", errors.New("unknown circuit")
+		}
+		manager.answerMap.Delete(cookie)
+		sessionId := v.(string)
+		if _, ok := manager.GetSession(sessionId); ok {
+			return "", errors.New("session already exist")
+		}
+		return sessionId, nil
+	case role == common.JointRole && cookie != "":
+		v, loaded := manager.cookieMap.LoadOrStore(cookie, NewSessionKey())
+		if !loaded {
+			return v.(string), nil
+		}
+		sess, ok := manager.GetSession(v.(string))
+		if !ok || sess.IsReady() || sess.Role != common.JointRole {
+			return "", errors.New("session already exist")
+		}
+		return v.(string), nil
+	default:
+		return NewSessionKey(), nil
+	}
+}
+
+//lookupHop finds the session of the circuit id that arrives on stream s.
+func (manager *RelayMsgManager) lookupHop(s session.Stream, circuitId string) (session.Session, bool) {
+	return manager.GetSessionByHop(s.RemotePeer, circuitId)
+}
+
+//forward passes a relay message from stream s to the other hop of the session, with that hop's circuit id.
+func (manager *RelayMsgManager) forward(sess session.Session, s session.Stream, data []byte) error {
+	part, circuitId, err := sess.GetPattern(s.StreamId)
+	if err != nil {
+		return err
+	}
+	out, err := withCircuitId(data, circuitId)
+	if err != nil {
+		return err
+	}
+	return part.RW.WriteMsg(out)
+}
+
 func (manager *RelayMsgManager) handleRelayMsg(relay *pb.Relay, s session.Stream, data []byte) error {
 	var relayMsg pb.RelayMsg
 	err := proto.Unmarshal(relay.Data, &relayMsg)
@@ -173,85 +235,136 @@ func (manager *RelayMsgManager) handleRelayMsg(relay *pb.Relay, s session.Stream
 		return err
 	}
 
-	sess, ok := manager.GetSession(relayMsg.SessionId)
+	sess, ok := manager.lookupHop(s, relayMsg.CircuitId)
 	if !ok {
-		log.Warn("relay no such session")
+		log.Warn("relay no such circuit")
 		return nil
 	}
+	manager.markReady(sess.Id)
 	if sess.Role == common.CallerRole || sess.Role == common.AnswerRole {
-		if c, ok := manager.GetCircuit(relayMsg.SessionId); ok {
-			c.InboundMsg(relayMsg.Data)
+		if c, ok := manager.GetCircuit(sess.Id); ok {
+			err = c.InboundMsg(relayMsg.Data)
+			if err != nil {
+				manager.CloseCircuit(sess.Id, ReasonPolicyRejected)
+				return err
+			}
 		} else {
-			log.Warnf("Got relay msg, but session %v have not init CircuitConn in MsgManager", relayMsg.SessionId)
+			log.Warnf("Got relay msg, but session %v have not init CircuitConn in MsgManager", sess.Id)
 		}
-		log.Debugf("Got msg from circuit %v: %v\n", relayMsg.SessionId, string(relayMsg.Data))
+		log.Debugf("Got msg from circuit %v: %v\n", sess.Id, string(relayMsg.Data))
 		return nil
 	}
 	if sess.IsReady() {
-		part, err := sess.GetPattern(s.StreamId)
-		if err != nil {
-			manager.CloseCircuit(relayMsg.SessionId)
-			log.Error("get part err", err)
-			return err
+		if manager.mixes(sess) {
+			manager.mixer.push(mixKey{sessionId: sess.Id, from: s.StreamId}, func() {
+				err := manager.forward(sess, s, data)
+				if err != nil {
+					manager.CloseCircuit(sess.Id, ReasonRelayFailure)
+					log.Error("forward err", err)
+				}
+			})
+			return nil
 		}
-		err = part.RW.WriteMsg(data)
+		err = manager.forward(sess, s, data)
 		if err != nil {
-			manager.CloseCircuit(relayMsg.SessionId)
-			log.Error("write err", err)
+			manager.CloseCircuit(sess.Id, ReasonRelayFailure)
+			log.Error("forward err", err)
 			return err
 		}
 	} else {
-		log.Warnf("Session not ready yet %v", relayMsg.SessionId)
+		log.Warnf("Session not ready yet %v", sess.Id)
 	}
 	return nil
 }
 
+func (manager *RelayMsgManager) handleCredit(relay *pb.Relay, s session.Stream, data []byte) error {
+	var credit pb.CreditMsg
+	err := proto.Unmarshal(relay.Data, &credit)
+	if err != nil {
+		return err
+	}
+
+	sess, ok := manager.lookupHop(s, credit.CircuitId)
+	if !ok {
+		return errors.New("no such circuit " + credit.CircuitId)
+	}
+	manager.touch(sess.Id)
+	if sess.Role == common.CallerRole || sess.Role == common.AnswerRole {
+		if c, ok := manager.GetCircuit(sess.Id); ok {
+			c.AddCredit(int(credit.Size))
+		}
+		return nil
+	}
+	if !sess.IsReady() {
+		return errors.New("session not ready " + sess.Id)
+	}
+	//credit goes through the mixer like data, its timing must not give away the data it answers
+	if manager.mixes(sess) {
+		manager.mixer.push(mixKey{sessionId: sess.Id, from: s.StreamId}, func() {
+			err := manager.forward(sess, s, data)
+			if err != nil {
+				log.Error("forward credit err", err)
+			}
+		})
+		return nil
+	}
+	return manager.forward(sess, s, data)
+}
+
 func (manager *RelayMsgManager) handleRelayProbe(relay *pb.Relay, s session.Stream, data []byte) error {
 	var probe pb.ProbeSignal
 	err := proto.Unmarshal(relay.Data, &probe)
 	if err != nil {
 		return err
 	}
-	sess, ok := manager.GetSession(probe.SessionId)
-	if ok && sess.Role == common.JointRole {
-		sessionProbe := session.Probe{
-			SessionId: probe.SessionId,
-			Rand:      probe.Data,
-		}
-		v, ok := manager.probeMap.Load(sessionProbe.SessionId)
+	sess, ok := manager.lookupHop(s, probe.CircuitId)
+	if !ok {
+		return nil
+	}
+	if sess.Role == common.JointRole {
+		cookie, ok := manager.cookieOf(sess.Id)
 		if !ok {
-			manager.probeMap.Store(sessionProbe.SessionId, sessionProbe)
-			return nil
+			return errors.New("no rendezvous cookie for session " + sess.Id)
 		}
-		p := v.(session.Probe)
-		if bytes.Equal(p.Rand, sessionProbe.Rand) {
-			//circuit success
-			log.Debug("send circuit success signal")
-			data := NewCircuitSuccess(sessionProbe.SessionId)
-			err := manager.SendRelay(sessionProbe.SessionId, data)
-			if err != nil {
-				log.Error(err)
-			}
-		} else {
-			manager.CloseCircuit(sessionProbe.SessionId)
+		if !hmac.Equal(probe.Data, probeValue(cookie, probe.CircuitId)) &&
+			!hmac.Equal(probe.Data, probeValue(ExitProbeKey(cookie), probe.CircuitId)) {
+			log.Warnf("Invalid probe on circuit %v", probe.CircuitId)
+			manager.CloseCircuit(sess.Id, ReasonSetupFailed)
+			return nil
 		}
+		manager.handleProbe(session.Probe{
+			SessionId: sess.Id,
+			Rand:      probe.Data,
+		})
 		return nil
 	}
 	if sess.IsReady() {
-		part, err := sess.GetPattern(s.StreamId)
-		if err != nil {
-			log.Error("get part err", err)
-			return err
+		//a relay of the exit half derives the probe of its next hop
+		if v, ok := manager.probeKeyMap.Load(sess.Id); ok {
+			err = manager.forwardProbe(sess, s, v.(string))
+		} else {
+			err = manager.forward(sess, s, data)
 		}
-		err = part.RW.WriteMsg(data)
 		if err != nil {
-			log.Error("write err", err)
+			log.Error("forward err", err)
 			return err
 		}
 	}
 	return nil
 }
 
+func (manager *RelayMsgManager) forwardProbe(sess session.Session, s session.Stream, key string) error {
+	part, circuitId, err := sess.GetPattern(s.StreamId)
+	if err != nil {
+		return err
+	}
+	out, err := NewProbeSignal(key, circuitId)
+	if err != nil {
+		return err
+	}
+	return part.RW.WriteMsg(out)
+}
+
 func (manager *RelayMsgManager) handleDisconnect(relay *pb.Relay, s session.Stream, data []byte) error {
 	log.Debug("Handle Disconnect signal")
 	var dis pb.Disconnect
@@ -260,14 +373,23 @@ func (manager *RelayMsgManager) handleDisconnect(relay *pb.Relay, s session.Stre
 		return err
 	}
 
-	log.Infof("Close circuit %v", dis.SessionId)
-	defer func() { manager.RemoveSession(dis.SessionId) }()
-	_, ok := manager.sessionMap.Load(dis.SessionId)
+	sess, ok := manager.lookupHop(s, dis.CircuitId)
 	if !ok {
-		return errors.New("no such session")
+		return errors.New("no such circuit")
+	}
+	log.Infof("Close circuit %v: %v", sess.Id, DisconnectReason(dis.ErrCode))
+	manager.circuitClosed(sess, DisconnectReason(dis.ErrCode))
+	if manager.mixes(sess) {
+		//after the data still held for this circuit
+		manager.mixer.push(mixKey{sessionId: sess.Id, from: s.StreamId}, func() {
+			manager.ForwardRelay(sess.Id, data, s.RemotePeer)
+			manager.RemoveSession(sess.Id)
+		})
+		return nil
 	}
+	defer func() { manager.RemoveSession(sess.Id) }()
 
-	err = manager.ForwardRelay(dis.SessionId, data, s.RemotePeer)
+	err = manager.ForwardRelay(sess.Id, data, s.RemotePeer)
 	if err != nil {
 		return err
 	}
@@ -283,20 +405,21 @@ func (manager *RelayMsgManager) handleCircuitSuccess(relay *pb.Relay, s session.
 	if err != nil {
 		return err
 	}
-	sess, ok := manager.GetSession(succ.SessionId)
+	sess, ok := manager.lookupHop(s, succ.CircuitId)
 	if !ok {
-		return errors.New("no such session " + succ.SessionId)
+		return errors.New("no such circuit " + succ.CircuitId)
 	}
+	manager.markReady(sess.Id)
 	if sess.Role == common.CallerRole {
 		log.Debug("caller handle circuit success")
-		v, ok := manager.circuitConnMap.Load(succ.SessionId)
+		v, ok := manager.circuitConnMap.Load(sess.Id)
 		if !ok {
 			return errors.New("circuitConn not exist")
 		}
 		conn := v.(*CircuitConn)
 		conn.state = CircuitConnReady
-		manager.circuitConnMap.Store(succ.SessionId, conn)
-		log.Debug("circuitConn ready ", succ.SessionId)
+		manager.circuitConnMap.Store(sess.Id, conn)
+		log.Debug("circuitConn ready ", sess.Id)
 		err := manager.NewSecureConnCaller(conn)
 		if err != nil {
 			log.Error(err)
@@ -305,14 +428,14 @@ func (manager *RelayMsgManager) handleCircuitSuccess(relay *pb.Relay, s session.
 	}
 
 	if sess.Role == common.AnswerRole {
-		v, ok := manager.circuitConnMap.Load(succ.SessionId)
+		v, ok := manager.circuitConnMap.Load(sess.Id)
 		if !ok {
 			return errors.New("circuitConn not exist")
 		}
 		conn := v.(*CircuitConn)
 		conn.state = CircuitConnReady
-		manager.circuitConnMap.Store(succ.SessionId, conn)
-		log.Debug("circuitConn ready ", succ.SessionId)
+		manager.circuitConnMap.Store(sess.Id, conn)
+		log.Debug("circuitConn ready ", sess.Id)
 		err := manager.NewSecureConnAnswer(conn)
 		if err != nil {
 			log.Error(err)
@@ -321,26 +444,80 @@ func (manager *RelayMsgManager) handleCircuitSuccess(relay *pb.Relay, s session.
 	}
 
 	if !sess.IsReady() {
-		return errors.New("Received success signal,but session not ready: " + succ.SessionId)
+		return errors.New("Received success signal,but session not ready: " + sess.Id)
 	}
 
-	part, err := sess.GetPattern(s.StreamId)
+	err = manager.forward(sess, s, data)
 	if err != nil {
-		log.Error("get part err", err)
+		log.Error("forward err", err)
 		return err
 	}
-	err = part.RW.WriteMsg(data)
+	return nil
+}
+
+//withCircuitId addresses a relay message to one hop. Messages are built without circuit id and every node
+//sets the id of the hop it writes to, so the same message carries a different id on each hop.
+func withCircuitId(data []byte, circuitId string) ([]byte, error) {
+	var relay pb.Relay
+	err := proto.Unmarshal(data, &relay)
+	if err != nil {
+		return nil, err
+	}
+	var msg proto.Message
+	switch relay.Type {
+	case pb.Relaytype_Data:
+		relayMsg := pb.RelayMsg{}
+		err = proto.Unmarshal(relay.Data, &relayMsg)
+		relayMsg.CircuitId = circuitId
+		msg = &relayMsg
+	case pb.Relaytype_Credit:
+		credit := pb.CreditMsg{}
+		err = proto.Unmarshal(relay.Data, &credit)
+		credit.CircuitId = circuitId
+		msg = &credit
+	case pb.Relaytype_Probe:
+		probe := pb.ProbeSignal{}
+		err = proto.Unmarshal(relay.Data, &probe)
+		probe.CircuitId = circuitId
+		msg = &probe
+	case pb.Relaytype_Disconnect:
+		dis := pb.Disconnect{}
+		err = proto.Unmarshal(relay.Data, &dis)
+		dis.CircuitId = circuitId
+		msg = &dis
+	case pb.Relaytype_Ping, pb.Relaytype_Pong:
+		ping := pb.PingMsg{}
+		err = proto.Unmarshal(relay.Data, &ping)
+		ping.CircuitId = circuitId
+		msg = &ping
+	case pb.Relaytype_Success:
+		succ := pb.CircuitSuccess{}
+		err = proto.Unmarshal(relay.Data, &succ)
+		succ.CircuitId = circuitId
+		msg = &succ
+	default:
+		return data, nil
+	}
 	if err != nil {
-		log.Error("write err", err)
-		return err
+		return nil, err
 	}
-	return nil
+	relay.Data, err = proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	relay.Id = ""
+	dataNoId, _ := proto.Marshal(&relay)
+	hash := sha256.Sum256(dataNoId)
+	relay.Id = secure.EncodeMSGIDHash(hash[:])
+	return proto.Marshal(&relay)
 }
 
-func NewSetSessionIDCommand(sessionID string, otherRole common.SessionRole) ([]byte, string) {
+func NewSetSessionIDCommand(circuitId string, otherRole common.SessionRole, cookie string, sealedCookie []byte) ([]byte, string) {
 	cmd := pb.SetSessionIdMsg{
-		SessionId: sessionID,
-		Role:      int32(otherRole),
+		CircuitId:    circuitId,
+		Role:         int32(otherRole),
+		Cookie:       cookie,
+		SealedCookie: sealedCookie,
 	}
 	data, _ := proto.Marshal(&cmd)
 	relay := pb.Relay{
@@ -368,10 +545,9 @@ func NewRelayWake() []byte {
 	return relayBytes
 }
 
-func NewRelayMsg(msg []byte, sessionID string) []byte {
+func NewRelayMsg(msg []byte) []byte {
 	relayMsg := pb.RelayMsg{
-		SessionId: sessionID,
-		Data:      msg,
+		Data: msg,
 	}
 	relayMsgData, _ := proto.Marshal(&relayMsg)
 	relay := pb.Relay{
@@ -386,11 +562,27 @@ func NewRelayMsg(msg []byte, sessionID string) []byte {
 	return relayBytes
 }
 
-func NewProbeSignal(sessionId string) ([]byte, error) {
-	signal := sha256.Sum256([]byte(sessionId))
+//exitProbeLabel separates the probe key of the exit half from the probes the entry derives from the cookie itself.
+const exitProbeLabel = "whitenoise exit probe"
+
+//ExitProbeKey is the key the probes of the exit half are derived with. Relays of the exit half are given it instead
+//of the cookie, only the joint derives it from the cookie to check the probe of the last relay.
+func ExitProbeKey(cookie string) string {
+	return base58.Encode(probeValue(cookie, exitProbeLabel))
+}
+
+func probeValue(key string, circuitId string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(circuitId))
+	return mac.Sum(nil)
+}
+
+//NewProbeSignal is sent towards the joint on the hop with circuitId, the entry derives it from the rendezvous cookie
+//and the exit half from ExitProbeKey. Every hop gets its own, so probes seen on two hops cannot be matched.
+func NewProbeSignal(key string, circuitId string) ([]byte, error) {
 	probe := pb.ProbeSignal{
-		SessionId: sessionId,
-		Data:      signal[:],
+		CircuitId: circuitId,
+		Data:      probeValue(key, circuitId),
 	}
 	data, err := proto.Marshal(&probe)
 	if err != nil {
@@ -411,10 +603,9 @@ func NewProbeSignal(sessionId string) ([]byte, error) {
 	return relayData, nil
 }
 
-func NewDisconnect(sessionId string) ([]byte, error) {
+func NewDisconnect(reason DisconnectReason) ([]byte, error) {
 	dis := pb.Disconnect{
-		SessionId: sessionId,
-		ErrCode:   0,
+		ErrCode: int32(reason),
 	}
 	data, err := proto.Marshal(&dis)
 	if err != nil {
@@ -435,8 +626,8 @@ func NewDisconnect(sessionId string) ([]byte, error) {
 	return relayData, nil
 }
 
-func NewCircuitSuccess(sessionId string) []byte {
-	succ := pb.CircuitSuccess{SessionId: sessionId}
+func NewCircuitSuccess() []byte {
+	succ := pb.CircuitSuccess{}
 	data, _ := proto.Marshal(&succ)
 	relay := pb.Relay{
 		Id:   "",
@@ -449,3 +640,20 @@ func NewCircuitSuccess(sessionId string) []byte {
 	relayData, _ := proto.Marshal(&relay)
 	return relayData
 }
+
+func NewCredit(size int) []byte {
+	credit := pb.CreditMsg{
+		Size: uint32(size),
+	}
+	data, _ := proto.Marshal(&credit)
+	relay := pb.Relay{
+		Id:   "",
+		Type: pb.Relaytype_Credit,
+		Data: data,
+	}
+	dataNoId, _ := proto.Marshal(&relay)
+	hash := sha256.Sum256(dataNoId)
+	relay.Id = secure.EncodeMSGIDHash(hash[:])
+	relayData, _ := proto.Marshal(&relay)
+	return relayData
+}
@@ -0,0 +1,329 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/account"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/internal/pb"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	"github.com/Evanesco-Labs/WhiteNoise/secure"
+	"github.com/golang/protobuf/proto"
+	"github.com/magiconair/properties/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWithCircuitId(t *testing.T) {
+	data := NewRelayMsg([]byte("hello whitenoise"))
+	hop1, err := withCircuitId(data, NewCircuitId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hop2, err := withCircuitId(hop1, NewCircuitId())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relay1, relay2 pb.Relay
+	if err = proto.Unmarshal(hop1, &relay1); err != nil {
+		t.Fatal(err)
+	}
+	if err = proto.Unmarshal(hop2, &relay2); err != nil {
+		t.Fatal(err)
+	}
+	//the same message must not be linkable across hops
+	assert.Equal(t, relay1.Id != relay2.Id, true)
+
+	var msg1, msg2 pb.RelayMsg
+	proto.Unmarshal(relay1.Data, &msg1)
+	proto.Unmarshal(relay2.Data, &msg2)
+	assert.Equal(t, msg1.CircuitId != msg2.CircuitId, true)
+	assert.Equal(t, msg2.Data, []byte("hello whitenoise"))
+
+	id := relay2.Id
+	relay2.Id = ""
+	noId, _ := proto.Marshal(&relay2)
+	hash := sha256.Sum256(noId)
+	assert.Equal(t, id, secure.EncodeMSGIDHash(hash[:]))
+}
+
+func TestCoverInterval(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	constant := config.CoverConfig{Mode: config.CoverConstant, Rate: 4}
+	assert.Equal(t, coverInterval(constant, r), time.Second/4)
+
+	poisson := config.CoverConfig{Mode: config.CoverPoisson, Rate: 4}
+	var sum time.Duration
+	for i := 0; i < 10000; i++ {
+		sum += coverInterval(poisson, r)
+	}
+	mean := sum / 10000
+	if mean < time.Second/5 || mean > time.Second/3 {
+		t.Fatalf("poisson mean interval %v", mean)
+	}
+	assert.Equal(t, config.CoverConfig{}.Enabled(), false)
+}
+
+func TestDummyCell(t *testing.T) {
+	var relay pb.Relay
+	if err := proto.Unmarshal(NewDummyCell(), &relay); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, relay.Type, pb.Relaytype_Wake)
+	//as large as a cell of data on a hop, ids are random of varying length in both
+	var msg pb.RelayMsg
+	if err := proto.Unmarshal(relay.Data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(msg.Data), common.CircuitCellSize)
+	cell, _ := withCircuitId(NewRelayMsg(make([]byte, common.CircuitCellSize)), msg.CircuitId)
+	var data pb.Relay
+	proto.Unmarshal(cell, &data)
+	assert.Equal(t, len(data.Data), len(relay.Data))
+	//not addressed to any hop
+	dummy := NewDummyCell()
+	out, _ := withCircuitId(dummy, NewCircuitId())
+	assert.Equal(t, out, dummy)
+}
+
+func TestInterleave(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var batch []mixMsg
+	for i := 0; i < 30; i++ {
+		key := mixKey{sessionId: string(rune('a' + i%3))}
+		n := i
+		batch = append(batch, mixMsg{key: key, send: func() {}, release: time.Unix(int64(n), 0)})
+	}
+	out := interleave(batch, r)
+	assert.Equal(t, len(out), len(batch))
+	last := make(map[mixKey]int64)
+	for _, msg := range out {
+		if prev, ok := last[msg.key]; ok && msg.release.Unix() < prev {
+			t.Fatal("interleave reorders messages of a key")
+		}
+		last[msg.key] = msg.release.Unix()
+	}
+}
+
+func TestMixer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, cfg := range []config.MixConfig{
+		{Mode: config.MixDelay, Delay: time.Millisecond},
+		{Mode: config.MixBatch, Delay: 10 * time.Millisecond, BatchSize: 4},
+	} {
+		m := newMixer(ctx, cfg)
+		got := make(chan int, 100)
+		for i := 0; i < 50; i++ {
+			n := i
+			m.push(mixKey{sessionId: "s", from: "p"}, func() { got <- n })
+		}
+		for i := 0; i < 50; i++ {
+			select {
+			case n := <-got:
+				assert.Equal(t, n, i)
+			case <-time.After(time.Second):
+				t.Fatalf("mode %v: message %v not sent", cfg.Mode, i)
+			}
+		}
+	}
+}
+
+func TestMixerSlowSender(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newMixer(ctx, config.MixConfig{Mode: config.MixBatch, Delay: time.Second, BatchSize: 1})
+	blocked := make(chan struct{})
+	m.push(mixKey{sessionId: "s", from: "p"}, func() { <-blocked })
+	//a stuck stream must not stall relaying of other circuits into the mixer
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.push(mixKey{sessionId: "s", from: "p"}, func() {})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked on a slow sender")
+	}
+	close(blocked)
+}
+
+func TestReap(t *testing.T) {
+	manager := NewRelayMsgManager(nil, context.Background(), nil, config.ServerMode, nil, nil, nil)
+	now := time.Now()
+	for _, id := range []string{"setup", "ready"} {
+		sess := session.NewSession()
+		sess.SetSessionID(id)
+		manager.AddSessionId(id, sess)
+		manager.track(id)
+	}
+	manager.markReady("ready")
+	cookie := NewCookie()
+	manager.Rendezvous(cookie)
+	manager.ExpectAnswer(NewCookie(), "answer")
+
+	manager.reap(now)
+	assert.Equal(t, manager.hasSession("setup"), true)
+
+	later := now.Add(manager.SessionSetupTimeout + time.Second)
+	manager.reap(later)
+	assert.Equal(t, manager.hasSession("setup"), false)
+	assert.Equal(t, manager.hasSession("ready"), true)
+	_, ok := manager.cookieMap.Load(cookie)
+	assert.Equal(t, ok, false)
+	answers := 0
+	manager.answerMap.Range(func(key, value interface{}) bool {
+		answers++
+		return true
+	})
+	assert.Equal(t, answers, 0)
+
+	manager.reap(now.Add(manager.SessionIdleTimeout + time.Second))
+	assert.Equal(t, manager.hasSession("ready"), false)
+	if _, _, ok := manager.SessionState("ready"); ok {
+		t.Fatal("expect no state of a removed session")
+	}
+}
+
+func TestAwaitAnswerFallback(t *testing.T) {
+	manager := NewRelayMsgManager(nil, context.Background(), nil, config.ServerMode, nil, nil, nil)
+	sess := session.NewSession()
+	sess.SetSessionID("setup")
+	manager.AddSessionId("setup", sess)
+	manager.track("setup")
+
+	//the fallback gets the circuit another timeout before it is given up
+	fellBack := make(chan struct{}, 2)
+	manager.AwaitAnswer("setup", 50*time.Millisecond, func() { fellBack <- struct{}{} })
+	select {
+	case <-fellBack:
+	case <-time.After(time.Second):
+		t.Fatal("fallback not called")
+	}
+	assert.Equal(t, manager.hasSession("setup"), true)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, manager.hasSession("setup"), false)
+	assert.Equal(t, len(fellBack), 0)
+}
+
+func TestPing(t *testing.T) {
+	circuitId := NewCircuitId()
+	data, err := withCircuitId(NewPing(42), circuitId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var relay pb.Relay
+	if err = proto.Unmarshal(data, &relay); err != nil {
+		t.Fatal(err)
+	}
+	var ping pb.PingMsg
+	if err = proto.Unmarshal(relay.Data, &ping); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, relay.Type, pb.Relaytype_Ping)
+	assert.Equal(t, ping.CircuitId, circuitId)
+	assert.Equal(t, ping.Nonce, uint64(42))
+
+	//only the ends of a circuit answer pings
+	manager := NewRelayMsgManager(nil, context.Background(), nil, config.ServerMode, nil, nil, nil)
+	sess := session.NewSession()
+	sess.SetSessionID("relay")
+	sess.Role = common.RelayRole
+	manager.AddSessionId("relay", sess)
+	_, err = manager.Ping(context.Background(), "relay")
+	assert.Equal(t, err, ErrNotEndpoint)
+	_, err = manager.Ping(context.Background(), "none")
+	if err == nil {
+		t.Fatal("expect error pinging an unknown session")
+	}
+
+	//relays forward a ping with a nonce of their own and answer with the one it came with
+	out, err := manager.rewritePing("relay", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out != 42, true)
+	_, ok := manager.restorePong("other", out)
+	assert.Equal(t, ok, false)
+	nonce, ok := manager.restorePong("relay", out)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, nonce, uint64(42))
+	_, ok = manager.restorePong("relay", out)
+	assert.Equal(t, ok, false)
+}
+
+func TestDisconnectReason(t *testing.T) {
+	data, err := NewDisconnect(ReasonIdleTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the reason must survive the hops
+	data, err = withCircuitId(data, NewCircuitId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var relay pb.Relay
+	if err = proto.Unmarshal(data, &relay); err != nil {
+		t.Fatal(err)
+	}
+	var dis pb.Disconnect
+	if err = proto.Unmarshal(relay.Data, &dis); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, DisconnectReason(dis.ErrCode), ReasonIdleTimeout)
+	assert.Equal(t, DisconnectReason(dis.ErrCode).String(), "idle timeout")
+	assert.Equal(t, DisconnectReason(100).String(), "reason 100")
+}
+
+func TestExpectAnswerOnce(t *testing.T) {
+	manager := NewRelayMsgManager(nil, context.Background(), nil, config.ServerMode, nil, nil, nil)
+	cookie := NewCookie()
+	assert.Equal(t, manager.ExpectAnswer(cookie, "s1"), nil)
+	//the same request reaches the client through another proxy
+	assert.Equal(t, manager.ExpectAnswer(cookie, "s1"), ErrAlreadyAnswered)
+
+	sess := session.NewSession()
+	sess.SetSessionID("s2")
+	manager.AddSessionId("s2", sess)
+	assert.Equal(t, manager.ExpectAnswer(NewCookie(), "s2"), ErrAlreadyAnswered)
+}
+
+func TestProbe(t *testing.T) {
+	cookie := NewCookie()
+	cid1, cid2 := NewCircuitId(), NewCircuitId()
+	//probes of different hops cannot be matched
+	assert.Equal(t, string(probeValue(cookie, cid1)) != string(probeValue(cookie, cid2)), true)
+	//the relays of the exit half only get a key that does not give the cookie away
+	key := ExitProbeKey(cookie)
+	assert.Equal(t, key != cookie, true)
+	assert.Equal(t, string(probeValue(key, cid1)) != string(probeValue(cookie, cid1)), true)
+
+	acc, err := account.NewOneTimeAccount(crypto.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewRelayMsgManager(nil, context.Background(), nil, config.ServerMode, nil, acc, nil)
+	sealed, err := SealCookie(acc.GetP2PPrivKey().GetPublic(), cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Contains(sealed, []byte(cookie)), false)
+	opened, err := manager.openCookie(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, opened, cookie)
+
+	other, _ := account.NewOneTimeAccount(crypto.Ed25519)
+	manager.Account = other
+	_, err = manager.openCookie(sealed)
+	assert.Equal(t, err != nil, true)
+}
@@ -1,10 +1,17 @@
 package relay
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Evanesco-Labs/WhiteNoise/common"
 	"github.com/Evanesco-Labs/WhiteNoise/secure"
 )
 
+var (
+	ErrSecureHandshake = errors.New("secure handshake failed")
+	ErrCircuitNotReady = errors.New("circuit closed before secure connection ready")
+)
+
 func (manager *RelayMsgManager) NewSecureConnCaller(conn *CircuitConn) error {
 	if _, ok := manager.secureConnMap.Load(conn.sessionId); ok {
 		return nil
@@ -16,11 +23,20 @@ func (manager *RelayMsgManager) NewSecureConnCaller(conn *CircuitConn) error {
 	if err != nil {
 		return err
 	}
-	secureConn, err := secure.NewSecureSession(manager.host.ID(), manager.privateKey, conn.ctx, conn, remotePeerID, true)
+	cellSize := 0
+	if conn.wantCells {
+		cellSize = common.CircuitCellSize
+	}
+	secureConn, err := secure.NewSecureSession(manager.host.ID(), manager.privateKey, conn.ctx, conn, remotePeerID, true, cellSize, conn.window, conn.coverKey, conn.protocol)
 	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrSecureHandshake, err)
+		manager.eb.Publish(common.NewSecureConnFailedTopic, conn.sessionId, err)
 		return err
 	}
+	conn.cellSize = secureConn.CellSize()
+	conn.setRemoteWindow(secureConn.RemoteWindow())
 	manager.secureConnMap.Store(conn.sessionId, secureConn)
+	manager.startCircuitCover(conn, secureConn)
 	manager.eb.Publish(common.NewSecureConnCallerTopic, conn.sessionId)
 	return nil
 }
@@ -29,11 +45,17 @@ func (manager *RelayMsgManager) NewSecureConnAnswer(conn *CircuitConn) error {
 	if _, ok := manager.secureConnMap.Load(conn.sessionId); ok {
 		return nil
 	}
-	secureConn, err := secure.NewSecureSession(manager.host.ID(), manager.privateKey, conn.ctx, conn, "", false)
+	//always offer cells, the caller decides
+	secureConn, err := secure.NewSecureSession(manager.host.ID(), manager.privateKey, conn.ctx, conn, "", false, common.CircuitCellSize, conn.window, conn.coverKey, "")
 	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrSecureHandshake, err)
+		manager.eb.Publish(common.NewSecureConnFailedTopic, conn.sessionId, err)
 		return err
 	}
+	conn.cellSize = secureConn.CellSize()
+	conn.setRemoteWindow(secureConn.RemoteWindow())
 	manager.secureConnMap.Store(conn.sessionId, secureConn)
+	manager.startCircuitCover(conn, secureConn)
 	manager.eb.Publish(common.NewSecureConnAnswerTopic, conn.sessionId)
 	return nil
 }
@@ -0,0 +1,410 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
+	"github.com/Evanesco-Labs/WhiteNoise/secure"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Network string = "whitenoise"
+
+var ErrConnClosed = errors.New("circuit connection closed")
+
+// DisconnectError is returned by reads and writes on a circuit that was torn down, Reason tells why.
+type DisconnectError struct {
+	Reason relay.DisconnectReason
+}
+
+func (e *DisconnectError) Error() string {
+	return "circuit disconnected: " + e.Reason.String()
+}
+
+// WhiteNoiseAddr is the net.Addr of a circuit endpoint, identified by its WhiteNoiseID.
+type WhiteNoiseAddr struct {
+	ID string
+}
+
+func (a WhiteNoiseAddr) Network() string {
+	return Network
+}
+
+func (a WhiteNoiseAddr) String() string {
+	return a.ID
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// deadline is a resettable deadline that can be waited on.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set arms the deadline at t. The zero time disarms it.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// Conn adapts a secure circuit to net.Conn. Reads are pumped from the secure session
+// in the background so that read deadlines never interrupt a Noise frame half way.
+type Conn struct {
+	//accessed atomically, kept first for 64-bit alignment
+	lastActive int64
+	rtt        int64
+
+	session   *secure.SecureSession
+	sessionID string
+	client    *WhiteNoiseClient
+
+	readCh   chan []byte
+	readErr  error
+	pending  []byte
+	readDone chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+	//writeMu is held by the session write in flight, writeErr is set once a write is abandoned half way
+	writeMu  chan struct{}
+	writeErr error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	errMut    sync.Mutex
+}
+
+func newConn(client *WhiteNoiseClient, sessionID string, session *secure.SecureSession) *Conn {
+	c := &Conn{
+		session:       session,
+		sessionID:     sessionID,
+		client:        client,
+		lastActive:    time.Now().UnixNano(),
+		readCh:        make(chan []byte),
+		readDone:      make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		writeMu:       make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	go c.readLoop()
+	if client.KeepaliveInterval > 0 {
+		go c.keepalive(client.KeepaliveInterval, client.PingTimeout, client.KeepaliveMaxMissed)
+	}
+	return c
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.readDone)
+	defer close(c.readCh)
+	buf := make([]byte, secure.MaxPlaintextLength)
+	for {
+		n, err := c.session.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			select {
+			case c.readCh <- data:
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			c.readErr = err
+			return
+		}
+	}
+}
+
+// setCloseErr keeps the first reason the circuit was torn down for.
+func (c *Conn) setCloseErr(err error) {
+	c.errMut.Lock()
+	defer c.errMut.Unlock()
+	if c.closeErr == nil {
+		c.closeErr = err
+	}
+}
+
+func (c *Conn) getCloseErr() error {
+	c.errMut.Lock()
+	defer c.errMut.Unlock()
+	return c.closeErr
+}
+
+// closedErr is the error of reads and writes after the connection is closed.
+func (c *Conn) closedErr() error {
+	if err := c.getCloseErr(); err != nil {
+		return err
+	}
+	return ErrConnClosed
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		select {
+		case <-c.done:
+			return 0, c.closedErr()
+		case <-c.readDeadline.wait():
+			return 0, timeoutError{}
+		default:
+		}
+
+		select {
+		case data, ok := <-c.readCh:
+			if !ok {
+				if err := c.getCloseErr(); err != nil {
+					return 0, err
+				}
+				if c.readErr == nil || c.readErr == io.ErrUnexpectedEOF {
+					return 0, io.EOF
+				}
+				return 0, c.readErr
+			}
+			c.pending = data
+		case <-c.done:
+			return 0, c.closedErr()
+		case <-c.readDeadline.wait():
+			return 0, timeoutError{}
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write writes b to the secure session. The session write runs in the background so that
+// the write deadline and Close interrupt a write blocked on the remote window. A write
+// interrupted by the deadline may already have sent part of a Noise frame, so every later
+// write fails with a timeout as well.
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, c.closedErr()
+	case <-c.writeDeadline.wait():
+		return 0, timeoutError{}
+	default:
+	}
+	select {
+	case <-c.done:
+		return 0, c.closedErr()
+	case <-c.writeDeadline.wait():
+		return 0, timeoutError{}
+	case c.writeMu <- struct{}{}:
+	}
+	c.errMut.Lock()
+	writeErr := c.writeErr
+	c.errMut.Unlock()
+	if writeErr != nil {
+		<-c.writeMu
+		return 0, writeErr
+	}
+	if err := c.getCloseErr(); err != nil {
+		<-c.writeMu
+		return 0, err
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	resCh := make(chan result, 1)
+	go func() {
+		n, err := c.session.Write(data)
+		resCh <- result{n, err}
+		<-c.writeMu
+	}()
+
+	var res result
+	select {
+	case res = <-resCh:
+	case <-c.done:
+		return 0, c.closedErr()
+	case <-c.writeDeadline.wait():
+		select {
+		case res = <-resCh:
+		default:
+			c.errMut.Lock()
+			c.writeErr = timeoutError{}
+			c.errMut.Unlock()
+			return 0, timeoutError{}
+		}
+	}
+	if res.err != nil {
+		if closeErr := c.getCloseErr(); closeErr != nil {
+			return res.n, closeErr
+		}
+	}
+	return res.n, res.err
+}
+
+// Close closes the circuit this connection runs on.
+func (c *Conn) Close() error {
+	return c.closeWith(relay.ReasonRemoteClosed)
+}
+
+// closeWith closes the circuit and tells the remote end the reason.
+func (c *Conn) closeWith(reason relay.DisconnectReason) error {
+	err := ErrConnClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.client.removeConn(c)
+		err = c.client.node.NoiseService.Relay().CloseCircuit(c.sessionID, reason)
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return WhiteNoiseAddr{ID: c.LocalWhiteNoiseID()}
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return WhiteNoiseAddr{ID: c.RemoteWhiteNoiseID()}
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) LocalWhiteNoiseID() string {
+	return c.session.LocalWhiteNoiseID()
+}
+
+func (c *Conn) RemoteWhiteNoiseID() string {
+	return c.session.RemoteWhiteNoiseID()
+}
+
+func (c *Conn) SessionID() string {
+	return c.sessionID
+}
+
+// Ping sends a ping over the circuit to the remote end and returns the round trip time.
+func (c *Conn) Ping(ctx context.Context) (time.Duration, error) {
+	select {
+	case <-c.done:
+		return 0, ErrConnClosed
+	default:
+	}
+	rtt, err := c.client.node.NoiseService.Relay().Ping(ctx, c.sessionID)
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&c.rtt, int64(rtt))
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	return rtt, nil
+}
+
+// RTT returns the round trip time measured by the last answered ping, zero if none was answered yet.
+func (c *Conn) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+// keepalive pings the circuit whenever nothing was received for an interval. After maxMissed
+// pings in a row go unanswered the circuit is declared dead and closed.
+func (c *Conn) keepalive(interval time.Duration, timeout time.Duration, maxMissed int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.readDone:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive))) < interval {
+			missed = 0
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err := c.Ping(ctx)
+		cancel()
+		if err == nil {
+			missed = 0
+			continue
+		}
+		missed++
+		log.Debugf("Circuit %v missed ping %v/%v: %v", c.sessionID, missed, maxMissed, err)
+		if missed >= maxMissed {
+			log.Warnf("Circuit %v is dead: %v", c.sessionID, err)
+			c.setCloseErr(&DisconnectError{Reason: relay.ReasonPingTimeout})
+			c.client.EventBus().Publish(CircuitDeadTopic, c.sessionID, err)
+			c.closeWith(relay.ReasonPingTimeout)
+			return
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSlowReader(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, answer := dialPair(t, a, l, b.GetWhiteNoiseID())
+	defer conn.Close()
+
+	data := make([]byte, 3*common.CircuitConnWindow)
+	rand.Read(data)
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		written <- err
+	}()
+
+	//the sender runs out of credit while nothing is read
+	select {
+	case err := <-written:
+		t.Fatalf("write of %v bytes returned before any read: %v", len(data), err)
+	case <-time.After(2 * time.Second):
+	}
+
+	//and resumes once the reader catches up
+	got := make([]byte, len(data))
+	answer.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.ReadFull(answer, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data corrupted")
+	}
+}
+
+func TestBlockedWrite(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	data := make([]byte, 3*common.CircuitConnWindow)
+	rand.Read(data)
+	blockedWrite := func(conn SecureConnection) chan error {
+		written := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(data)
+			written <- err
+		}()
+		select {
+		case err := <-written:
+			t.Fatalf("write of %v bytes returned before any read: %v", len(data), err)
+		case <-time.After(time.Second):
+		}
+		return written
+	}
+
+	//the write deadline interrupts a write blocked on credit, and later writes fail as well
+	conn, _ := dialPair(t, a, l, b.GetWhiteNoiseID())
+	defer conn.Close()
+	written := blockedWrite(conn)
+	conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	select {
+	case err := <-written:
+		if !isTimeout(err) {
+			t.Fatalf("expect timeout, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write not interrupted by deadline")
+	}
+	conn.SetWriteDeadline(time.Time{})
+	if _, err := conn.Write([]byte("after timeout")); !isTimeout(err) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+
+	//so does Close
+	conn, _ = dialPair(t, a, l, b.GetWhiteNoiseID())
+	written = blockedWrite(conn)
+	conn.Close()
+	select {
+	case err := <-written:
+		if !errors.Is(err, ErrConnClosed) {
+			t.Fatalf("expect %v, got %v", ErrConnClosed, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write not interrupted by close")
+	}
+}
+
+func TestCoverTraffic(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	echoServer(l)
+
+	cover := config.CoverConfig{Mode: config.CoverConstant, Rate: 200}
+	conn, _, err := a.Dial(b.GetWhiteNoiseID(), WithCoverTraffic(cover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//dummies flow both ways while nothing is read, they must not fill the window nor reach the reader
+	time.Sleep(time.Second)
+
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("dummy cells mixed into data")
+	}
+}
+
+func TestPing(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, answer := dialPair(t, a, l, b.GetWhiteNoiseID())
+	defer conn.Close()
+
+	for _, c := range []SecureConnection{conn, answer.(SecureConnection)} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		rtt, err := c.Ping(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtt <= 0 || c.(*Conn).RTT() != rtt {
+			t.Fatalf("rtt %v, last rtt %v", rtt, c.(*Conn).RTT())
+		}
+	}
+}
+
+func TestDeadCircuit(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	a.KeepaliveInterval = 200 * time.Millisecond
+	a.PingTimeout = 200 * time.Millisecond
+	a.KeepaliveMaxMissed = 2
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dead := make(chan string, 1)
+	a.EventBus().Subscribe(CircuitDeadTopic, func(sessionID string, err error) {
+		select {
+		case dead <- sessionID:
+		default:
+		}
+	})
+	conn, answer := dialPair(t, a, l, b.GetWhiteNoiseID())
+	defer answer.Close()
+
+	//the answer silently forgets the circuit, nothing tells the caller
+	b.node.NoiseService.Relay().SessionMap().Delete(answer.(*Conn).SessionID())
+
+	select {
+	case id := <-dead:
+		if id != conn.(*Conn).SessionID() {
+			t.Fatalf("circuit %v declared dead, expect %v", id, conn.(*Conn).SessionID())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("dead circuit not detected")
+	}
+	_, err = conn.Read(make([]byte, 1))
+	var dis *DisconnectError
+	if !errors.As(err, &dis) || dis.Reason != relay.ReasonPingTimeout {
+		t.Fatalf("expect ping timeout, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/network/noise"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
+)
+
+const CircuitFailedTopic string = common.NewSecureConnFailedTopic
+
+var (
+	ErrNoProxy             = errors.New("not registered at any proxy")
+	ErrProxyRejected       = errors.New("circuit rejected by proxy")
+	ErrInvalidDestination  = errors.New("invalid destination WhiteNoiseID")
+	ErrDestinationNotFound = errors.New("destination not found")
+	ErrHandshakeFailed     = errors.New("secure handshake failed")
+	ErrDialTimeout         = errors.New("new circuit timeout")
+	ErrCircuitClosed       = errors.New("circuit closed during setup")
+)
+
+// DialError tells why a circuit could not be built. Kind is one of the Err* values above
+// and can be matched with errors.Is.
+type DialError struct {
+	SessionID string
+	Kind      error
+	Err       error
+}
+
+func (e *DialError) Error() string {
+	if e.Err == nil || e.Err == e.Kind {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DialError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func newDialError(sessionID string, err error) error {
+	var kind error
+	var disconnect *DisconnectError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, noise.ErrNewCircuitTimeout):
+		kind = ErrDialTimeout
+	case errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, noise.ErrNoProxy):
+		kind = ErrNoProxy
+	case errors.Is(err, noise.ErrNewCircuitRejected):
+		kind = ErrProxyRejected
+	case errors.Is(err, noise.ErrInvalidDestination):
+		kind = ErrInvalidDestination
+	case errors.Is(err, noise.ErrDestinationRefused):
+		kind = ErrDestinationNotFound
+	case errors.Is(err, relay.ErrSecureHandshake):
+		kind = ErrHandshakeFailed
+	case errors.Is(err, relay.ErrCircuitNotReady):
+		kind = ErrCircuitClosed
+	case errors.As(err, &disconnect):
+		switch disconnect.Reason {
+		case relay.ReasonDestinationNotFound:
+			kind = ErrDestinationNotFound
+		case relay.ReasonSetupTimeout:
+			kind = ErrDialTimeout
+		default:
+			kind = ErrCircuitClosed
+		}
+	default:
+		return err
+	}
+	return &DialError{SessionID: sessionID, Kind: kind, Err: err}
+}
+
+type dialOptions struct {
+	hops    int
+	circuit relay.CircuitOptions
+}
+
+type DialOption func(*dialOptions)
+
+// WithRelayHops sets how many relay nodes the circuit passes between the joint and the exit node.
+// The default is common.DefaultRelayHops, at most common.MaxRelayHops are allowed.
+func WithRelayHops(hops int) DialOption {
+	return func(o *dialOptions) {
+		o.hops = hops
+	}
+}
+
+// WithCells pads and splits all circuit data into fixed size cells, hiding message lengths from relays and observers.
+// It falls back to plain frames if the answer does not support cells.
+func WithCells() DialOption {
+	return func(o *dialOptions) {
+		o.circuit.Cells = true
+	}
+}
+
+// WithCoverTraffic sends end-to-end dummy cells on the circuit, they are relayed like data and dropped by the answer.
+// It overrides the client's CircuitCover and implies WithCells, no dummies are sent if the answer does not support cells.
+func WithCoverTraffic(cover config.CoverConfig) DialOption {
+	return func(o *dialOptions) {
+		o.circuit.Cover = cover
+	}
+}
+
+// WithMixHops asks for the first mixHops relays from the exit to be nodes that mix the data they forward.
+// Dial fails if there are not enough mixing nodes, mixHops must not exceed the relay hops.
+func WithMixHops(mixHops int) DialOption {
+	return func(o *dialOptions) {
+		o.circuit.MixHops = mixHops
+	}
+}
+
+//withProtocol tells the answer which of its listeners gets the circuit.
+func withProtocol(protocol string) DialOption {
+	return func(o *dialOptions) {
+		o.circuit.Protocol = protocol
+	}
+}
+
+func newDialOptions(opts []DialOption) dialOptions {
+	o := dialOptions{hops: common.DefaultRelayHops}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (sdk *WhiteNoiseClient) subscribeCircuitEvents() error {
+	err := sdk.EventBus().Subscribe(GenCircuitSuccessTopic, sdk.onCircuitSuccess)
+	if err != nil {
+		return err
+	}
+	err = sdk.EventBus().Subscribe(CircuitFailedTopic, sdk.onCircuitFailed)
+	if err != nil {
+		return err
+	}
+	err = sdk.EventBus().Subscribe(GetCircuitTopic, sdk.onIncomingCircuit)
+	if err != nil {
+		return err
+	}
+	return sdk.EventBus().Subscribe(CircuitClosedTopic, sdk.onCircuitClosed)
+}
+
+func (sdk *WhiteNoiseClient) addDialWaiter(sessionID string) chan error {
+	sdk.dialMut.Lock()
+	defer sdk.dialMut.Unlock()
+	ch := make(chan error, 1)
+	sdk.dialWaiters[sessionID] = ch
+	return ch
+}
+
+func (sdk *WhiteNoiseClient) removeDialWaiter(sessionID string) {
+	sdk.dialMut.Lock()
+	defer sdk.dialMut.Unlock()
+	delete(sdk.dialWaiters, sessionID)
+}
+
+func (sdk *WhiteNoiseClient) notifyDialWaiter(sessionID string, err error) {
+	sdk.dialMut.Lock()
+	defer sdk.dialMut.Unlock()
+	if ch, ok := sdk.dialWaiters[sessionID]; ok {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+}
+
+func (sdk *WhiteNoiseClient) onCircuitSuccess(sessionID string) {
+	sdk.notifyDialWaiter(sessionID, nil)
+}
+
+func (sdk *WhiteNoiseClient) onCircuitFailed(sessionID string, err error) {
+	if err == nil {
+		err = relay.ErrCircuitNotReady
+	}
+	sdk.notifyDialWaiter(sessionID, err)
+}
+
+// DialContext builds a circuit to remoteID and returns once its secure connection is ready,
+// the setup fails, or ctx is done.
+func (sdk *WhiteNoiseClient) DialContext(ctx context.Context, remoteID string, opts ...DialOption) (SecureConnection, string, error) {
+	o := newDialOptions(opts)
+	sessionID := generateSessionID(remoteID, sdk.GetWhiteNoiseID())
+	done := sdk.addDialWaiter(sessionID)
+	defer sdk.removeDialWaiter(sessionID)
+
+	err := sdk.node.NoiseService.NewCircuitContext(ctx, remoteID, sessionID, o.hops, o.circuit)
+	if err != nil {
+		return nil, "", newDialError(sessionID, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		sdk.DisconnectCircuit(sessionID)
+		return nil, "", newDialError(sessionID, ctx.Err())
+	case err := <-done:
+		if err != nil {
+			sdk.DisconnectCircuit(sessionID)
+			return nil, "", newDialError(sessionID, err)
+		}
+	}
+
+	conn, ok := sdk.GetCircuit(sessionID)
+	if !ok {
+		return nil, "", newDialError(sessionID, relay.ErrCircuitNotReady)
+	}
+	return conn, sessionID, nil
+}
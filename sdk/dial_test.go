@@ -0,0 +1,144 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/account"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/network/noise"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNewDialError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{fmt.Errorf("%w: %s", noise.ErrNewCircuitRejected, "Session is full"), ErrProxyRejected},
+		{noise.ErrNoProxy, ErrNoProxy},
+		{fmt.Errorf("%w: %v", noise.ErrInvalidDestination, "not support key type"), ErrInvalidDestination},
+		{noise.ErrDestinationRefused, ErrDestinationNotFound},
+		{&DisconnectError{Reason: relay.ReasonDestinationNotFound}, ErrDestinationNotFound},
+		{&DisconnectError{Reason: relay.ReasonSetupTimeout}, ErrDialTimeout},
+		{&DisconnectError{Reason: relay.ReasonRelayFailure}, ErrCircuitClosed},
+		{fmt.Errorf("%w: %v", relay.ErrSecureHandshake, "peer id mismatch"), ErrHandshakeFailed},
+		{noise.ErrNewCircuitTimeout, ErrDialTimeout},
+		{context.DeadlineExceeded, ErrDialTimeout},
+		{relay.ErrCircuitNotReady, ErrCircuitClosed},
+	}
+	for _, c := range cases {
+		err := newDialError("session", c.err)
+		if !errors.Is(err, c.kind) {
+			t.Fatalf("%v: expect kind %v", err, c.kind)
+		}
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%v: cause %v lost", err, c.err)
+		}
+	}
+
+	if err := newDialError("session", context.Canceled); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+}
+
+func TestDialUnknownDestination(t *testing.T) {
+	n := getTestNetwork(t)
+	a := n.client(t, 0)
+	acc, err := account.NewOneTimeAccount(crypto.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteID := acc.GetPublicKey().GetWhiteNoiseID().String()
+
+	_, _, err = a.DialContext(context.Background(), remoteID)
+	if !errors.Is(err, ErrDestinationNotFound) {
+		t.Fatalf("expect %v, got %v", ErrDestinationNotFound, err)
+	}
+
+	//cancelling ctx stops the dial at once
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, err = a.DialContext(ctx, remoteID)
+	if err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("dial returned %v after cancel", time.Since(start))
+	}
+}
+
+func TestDialNoProxy(t *testing.T) {
+	getTestNetwork(t)
+	a, err := NewOneTimeClient(context.Background(), crypto.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.node.Host().Close()
+	_, _, err = a.Dial(a.GetWhiteNoiseID())
+	if !errors.Is(err, ErrNoProxy) {
+		t.Fatalf("expect %v, got %v", ErrNoProxy, err)
+	}
+}
+
+func TestDialMultiHop(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	echoServer(l)
+
+	hops := 2
+	before := n.relaySessions()
+	conn, _, err := a.Dial(b.GetWhiteNoiseID(), WithRelayHops(hops))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//relays know the circuit by a session id of their own
+	if relays := n.relaySessions() - before; relays < hops {
+		t.Fatalf("expect circuit through at least %v relays, got %v", hops, relays)
+	}
+
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data corrupted across relays")
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	if o := newDialOptions(nil); o.hops != common.DefaultRelayHops {
+		t.Fatalf("expect default hops %v, got %v", common.DefaultRelayHops, o.hops)
+	}
+	if o := newDialOptions([]DialOption{WithRelayHops(3)}); o.hops != 3 || o.circuit.Cells {
+		t.Fatalf("expect 3 hops without cells, got %v", o)
+	}
+	if o := newDialOptions([]DialOption{WithCells()}); !o.circuit.Cells {
+		t.Fatal("expect cells")
+	}
+	cover := config.CoverConfig{Mode: config.CoverPoisson, Rate: 2}
+	if o := newDialOptions([]DialOption{WithCoverTraffic(cover)}); o.circuit.Cover != cover {
+		t.Fatalf("expect cover %v, got %v", cover, o.circuit.Cover)
+	}
+	if o := newDialOptions([]DialOption{WithRelayHops(3), WithMixHops(2)}); o.circuit.MixHops != 2 {
+		t.Fatalf("expect 2 mix hops, got %v", o.circuit.MixHops)
+	}
+}
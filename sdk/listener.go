@@ -0,0 +1,107 @@
+package sdk
+
+import (
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
+	"net"
+	"sync"
+)
+
+const AcceptBacklog = 64
+
+var ErrListenerClosed = errors.New("whitenoise listener closed")
+var ErrAlreadyListening = errors.New("whitenoise client already listening")
+
+// Listener yields circuits dialed to this client as net.Conn.
+type Listener struct {
+	client    *WhiteNoiseClient
+	protocol  string
+	incoming  chan string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen returns a net.Listener whose Accept yields incoming circuits, so the client can be
+// handed to servers like http.Serve.
+func (sdk *WhiteNoiseClient) Listen() (net.Listener, error) {
+	return sdk.listen("")
+}
+
+//listen registers the listener of the circuits dialed for protocol, a client has one listener per protocol at a time.
+func (sdk *WhiteNoiseClient) listen(protocol string) (*Listener, error) {
+	sdk.listenMut.Lock()
+	defer sdk.listenMut.Unlock()
+	if _, ok := sdk.listeners[protocol]; ok {
+		return nil, ErrAlreadyListening
+	}
+	l := &Listener{
+		client:   sdk,
+		protocol: protocol,
+		incoming: make(chan string, AcceptBacklog),
+		done:     make(chan struct{}),
+	}
+	sdk.listeners[protocol] = l
+	return l, nil
+}
+
+//onIncomingCircuit hands a circuit dialed to this client to the listener of its protocol. The client subscribes it once,
+//EventBus tells method values apart by code pointer only and could not unsubscribe the handler of one listener.
+func (sdk *WhiteNoiseClient) onIncomingCircuit(sessionID string) {
+	protocol := ""
+	if secureConn, ok := sdk.node.NoiseService.Relay().GetSecureConn(sessionID); ok {
+		protocol = secureConn.Protocol()
+	}
+	sdk.listenMut.Lock()
+	l := sdk.listeners[protocol]
+	sdk.listenMut.Unlock()
+	if l != nil {
+		l.onCircuit(sessionID)
+	} else {
+		log.Debugf("no listener for circuit %v of protocol %q", sessionID, protocol)
+	}
+}
+
+func (l *Listener) onCircuit(sessionID string) {
+	select {
+	case l.incoming <- sessionID:
+	case <-l.done:
+	default:
+		log.Warnf("listener backlog full, reject circuit %v", sessionID)
+		if err := l.client.node.NoiseService.Relay().CloseCircuit(sessionID, relay.ReasonPolicyRejected); err != nil {
+			log.Debugf("close rejected circuit %v: %v", sessionID, err)
+		}
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		select {
+		case sessionID := <-l.incoming:
+			conn, ok := l.client.GetCircuit(sessionID)
+			if !ok {
+				log.Debugf("circuit %v closed before accept", sessionID)
+				continue
+			}
+			return conn, nil
+		case <-l.done:
+			return nil, ErrListenerClosed
+		}
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.client.listenMut.Lock()
+		defer l.client.listenMut.Unlock()
+		if l.client.listeners[l.protocol] == l {
+			delete(l.client.listeners, l.protocol)
+		}
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return WhiteNoiseAddr{ID: l.client.GetWhiteNoiseID()}
+}
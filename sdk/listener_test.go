@@ -0,0 +1,165 @@
+package sdk
+
+import (
+	"context"
+	"github.com/magiconair/properties/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+//dialPair dials a circuit from a to b and returns both ends.
+func dialPair(t *testing.T, a *WhiteNoiseClient, l net.Listener, remoteID string) (SecureConnection, net.Conn) {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, _, err := a.DialContext(ctx, remoteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case answer := <-accepted:
+		return conn, answer
+	case <-time.After(10 * time.Second):
+		t.Fatal("circuit not accepted")
+	}
+	return nil, nil
+}
+
+func TestListener(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Listen()
+	assert.Equal(t, err, ErrAlreadyListening)
+	//resilient connections have a listener of their own
+	rl, err := b.ListenResilient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.ListenResilient()
+	assert.Equal(t, err, ErrAlreadyListening)
+	rl.Close()
+	assert.Equal(t, l.Addr().Network(), Network)
+	assert.Equal(t, l.Addr().String(), b.GetWhiteNoiseID())
+
+	conn, answer := dialPair(t, a, l, b.GetWhiteNoiseID())
+	assert.Equal(t, answer.RemoteAddr().String(), a.GetWhiteNoiseID())
+	assert.Equal(t, answer.LocalAddr().String(), b.GetWhiteNoiseID())
+	if _, err := conn.Write([]byte("hello whitenoise")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	answer.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(answer, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(buf), "hello whitenoise")
+	conn.Close()
+
+	//a blocked Accept returns once the listener is closed
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		acceptErr <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	l.Close()
+	select {
+	case err := <-acceptErr:
+		assert.Equal(t, err, ErrListenerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("accept not interrupted by close")
+	}
+
+	//the client listens again after close
+	l, err = b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, _ = dialPair(t, a, l, b.GetWhiteNoiseID())
+	conn.Close()
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestConnDeadline(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, answer := dialPair(t, a, l, b.GetWhiteNoiseID())
+	defer conn.Close()
+
+	//the deadline expires while Read is blocked
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 10))
+	assert.Equal(t, isTimeout(err), true)
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("read returned after %v, before the deadline", time.Since(start))
+	}
+
+	//a deadline set from another goroutine interrupts a blocked Read
+	conn.SetReadDeadline(time.Time{})
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 10))
+		readErr <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn.SetReadDeadline(time.Now())
+	select {
+	case err := <-readErr:
+		assert.Equal(t, isTimeout(err), true)
+	case <-time.After(time.Second):
+		t.Fatal("read not interrupted by deadline")
+	}
+
+	//nothing is lost by a timed out Read
+	conn.SetReadDeadline(time.Time{})
+	if _, err := answer.Write([]byte("after timeout")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 13)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(buf), "after timeout")
+
+	conn.SetWriteDeadline(time.Now().Add(-time.Second))
+	_, err = conn.Write([]byte("late"))
+	assert.Equal(t, isTimeout(err), true)
+	conn.SetDeadline(time.Now().Add(-time.Second))
+	_, err = conn.Read(buf)
+	assert.Equal(t, isTimeout(err), true)
+	_, err = conn.Write([]byte("late"))
+	assert.Equal(t, isTimeout(err), true)
+
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("in time")); err != nil {
+		t.Fatal(err)
+	}
+	answer.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(answer, buf[:7]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(buf[:7]), "in time")
+}
@@ -0,0 +1,121 @@
+package sdk
+
+import (
+	"context"
+	"github.com/libp2p/go-yamux/v2"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const (
+	MuxAcceptBacklog     = 256
+	MuxKeepAliveInterval = 30 * time.Second
+	MuxWriteTimeout      = 10 * time.Second
+	MuxMaxStreamWindow   = 256 * 1024
+)
+
+// MuxStream is one bidirectional stream of a MuxSession. It has its own flow control window
+// and can be half-closed with CloseWrite.
+type MuxStream interface {
+	net.Conn
+	CloseWrite() error
+	CloseRead() error
+	Reset() error
+}
+
+// MuxSession multiplexes many streams over a single secure circuit, so that short requests
+// to the same peer do not each pay for a new circuit.
+type MuxSession struct {
+	session *yamux.Session
+	conn    SecureConnection
+}
+
+func DefaultMuxConfig() *yamux.Config {
+	cfg := yamux.DefaultConfig()
+	cfg.AcceptBacklog = MuxAcceptBacklog
+	cfg.KeepAliveInterval = MuxKeepAliveInterval
+	cfg.ConnectionWriteTimeout = MuxWriteTimeout
+	cfg.MaxStreamWindowSize = MuxMaxStreamWindow
+	cfg.LogOutput = ioutil.Discard
+	return cfg
+}
+
+// NewMuxSession starts multiplexing on conn. The dialing side of the circuit must pass
+// initiator true and the accepting side false, so that stream ids never collide.
+func NewMuxSession(conn SecureConnection, initiator bool) (*MuxSession, error) {
+	var session *yamux.Session
+	var err error
+	if initiator {
+		session, err = yamux.Client(conn, DefaultMuxConfig())
+	} else {
+		session, err = yamux.Server(conn, DefaultMuxConfig())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &MuxSession{
+		session: session,
+		conn:    conn,
+	}, nil
+}
+
+// DialMux builds a circuit to remoteID and starts multiplexing on it as initiator.
+func (sdk *WhiteNoiseClient) DialMux(ctx context.Context, remoteID string, opts ...DialOption) (*MuxSession, error) {
+	conn, _, err := sdk.DialContext(ctx, remoteID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	session, err := NewMuxSession(conn, true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *MuxSession) OpenStream(ctx context.Context) (MuxStream, error) {
+	stream, err := s.session.OpenStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *MuxSession) AcceptStream() (MuxStream, error) {
+	stream, err := s.session.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept and Addr let a MuxSession serve as a net.Listener for the streams of one circuit.
+func (s *MuxSession) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+func (s *MuxSession) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *MuxSession) NumStreams() int {
+	return s.session.NumStreams()
+}
+
+func (s *MuxSession) IsClosed() bool {
+	return s.session.IsClosed()
+}
+
+func (s *MuxSession) CloseChan() <-chan struct{} {
+	return s.session.CloseChan()
+}
+
+// Close resets all streams and closes the underlying circuit.
+func (s *MuxSession) Close() error {
+	return s.session.Close()
+}
+
+func (s *MuxSession) Conn() SecureConnection {
+	return s.conn
+}
@@ -0,0 +1,65 @@
+package sdk
+
+import (
+	"context"
+	"github.com/magiconair/properties/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type pipeConn struct {
+	net.Conn
+}
+
+func (p pipeConn) LocalWhiteNoiseID() string  { return "local" }
+func (p pipeConn) RemoteWhiteNoiseID() string { return "remote" }
+func (p pipeConn) Ping(ctx context.Context) (time.Duration, error) {
+	return 0, nil
+}
+
+func TestMuxSession(t *testing.T) {
+	a, b := net.Pipe()
+	caller, err := NewMuxSession(pipeConn{a}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+	answer, err := NewMuxSession(pipeConn{b}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer answer.Close()
+
+	const streams = 8
+	go func() {
+		for i := 0; i < streams; i++ {
+			s, err := answer.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				data, _ := ioutil.ReadAll(s)
+				s.Write(append([]byte("echo "), data...))
+				s.CloseWrite()
+			}()
+		}
+	}()
+
+	for i := 0; i < streams; i++ {
+		s, err := caller.OpenStream(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Write([]byte("hello whitenoise"))
+		//half-close: the remote sees EOF but can still answer
+		s.CloseWrite()
+		res, err := ioutil.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(res), "echo hello whitenoise")
+		s.Close()
+	}
+}
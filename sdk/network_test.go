@@ -0,0 +1,146 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	"github.com/Evanesco-Labs/WhiteNoise/common/account"
+	"github.com/Evanesco-Labs/WhiteNoise/common/config"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/Evanesco-Labs/WhiteNoise/network"
+	"github.com/Evanesco-Labs/WhiteNoise/network/session"
+	"github.com/multiformats/go-multiaddr"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testServers = 5
+
+//testNetwork is a WhiteNoise network in the test process: a bootstrap node and servers that relay and proxy.
+type testNetwork struct {
+	boot    *network.Node
+	servers []*network.Node
+}
+
+var (
+	sharedNet     *testNetwork
+	sharedNetErr  error
+	sharedNetOnce sync.Once
+)
+
+//getTestNetwork starts the network shared by the tests of the package on first use.
+func getTestNetwork(t *testing.T) *testNetwork {
+	if testing.Short() {
+		t.Skip("skip network test in short mode")
+	}
+	sharedNetOnce.Do(func() {
+		sharedNet, sharedNetErr = newTestNetwork(testServers)
+	})
+	if sharedNetErr != nil {
+		t.Fatal(sharedNetErr)
+	}
+	return sharedNet
+}
+
+func newTestNetwork(servers int) (*testNetwork, error) {
+	boot, err := newTestNode(config.BootMode, nil)
+	if err != nil {
+		return nil, err
+	}
+	bootAddr, err := localAddr(boot)
+	if err != nil {
+		return nil, err
+	}
+	BootStrapPeers = []string{bootAddr}
+	n := &testNetwork{boot: boot}
+	for i := 0; i < servers; i++ {
+		server, err := newTestNode(config.ServerMode, BootStrapPeers)
+		if err != nil {
+			return nil, err
+		}
+		n.servers = append(n.servers, server)
+	}
+	//let the servers find each other in the DHT
+	time.Sleep(3 * time.Second)
+	return n, nil
+}
+
+func newTestNode(mode config.ServiceMode, boots []string) (*network.Node, error) {
+	cfg := config.NetworkConfig{
+		RendezvousString: "whitenoise",
+		ListenHost:       "127.0.0.1",
+		BootStrapPeers:   boots,
+		Mode:             mode,
+	}
+	acc, err := account.NewOneTimeAccount(crypto.Ed25519)
+	if err != nil {
+		return nil, err
+	}
+	node, err := network.NewNode(context.Background(), &cfg, acc)
+	if err != nil {
+		return nil, err
+	}
+	node.Start(&cfg)
+	return node, nil
+}
+
+//localAddr is the loopback multiaddr of a node listening on a random port.
+func localAddr(node *network.Node) (string, error) {
+	for _, addr := range node.Host().Addrs() {
+		ip, err := addr.ValueForProtocol(multiaddr.P_IP4)
+		if err != nil || ip != "127.0.0.1" {
+			continue
+		}
+		return fmt.Sprintf("%v/p2p/%v", addr, node.Host().ID()), nil
+	}
+	return "", fmt.Errorf("node %v has no loopback address", node.Host().ID())
+}
+
+//client registers a new one-time client at the server with index proxy.
+func (n *testNetwork) client(t *testing.T, proxy int) *WhiteNoiseClient {
+	client, err := NewOneTimeClient(context.Background(), crypto.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.UnRegister()
+		client.node.Host().Close()
+	})
+	if _, err := client.GetMainNetPeers(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Register(n.servers[proxy].Host().ID()); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+//relaySessions counts the sessions the servers relay as a relay node.
+func (n *testNetwork) relaySessions() int {
+	count := 0
+	for _, server := range n.servers {
+		server.NoiseService.Relay().SessionMap().Range(func(_, v interface{}) bool {
+			if v.(session.Session).Role == common.RelayRole {
+				count++
+			}
+			return true
+		})
+	}
+	return count
+}
+
+//echoServer echoes every circuit accepted on l until it is closed.
+func echoServer(l net.Listener) {
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+}
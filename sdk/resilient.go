@@ -0,0 +1,768 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/Evanesco-Labs/WhiteNoise/common/log"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	ResumeTimeout       = 2 * time.Minute
+	ResilientWindow     = 1 << 20
+	ResilientAckBytes   = 64 << 10
+	ResilientAckDelay   = 200 * time.Millisecond
+	ResilientMaxFrame   = 32 << 10
+	ResilientMaxBackoff = 30 * time.Second
+	resumeIDLength      = 16
+	helloTimeout        = 10 * time.Second
+)
+
+// ResilientProtocol is the protocol of circuits dialed with DialResilient, the answer hands them
+// to its ListenResilient listener.
+const ResilientProtocol = "/whitenoise/resilient/1.0.0"
+
+var (
+	ErrResumeFailed   = errors.New("resilient connection could not resume in time")
+	ErrResumeMismatch = errors.New("resilient connection peers disagree on the stream offset")
+	ErrNoCircuit      = errors.New("resilient connection has no circuit now")
+	ErrUnacknowledged = errors.New("resilient connection finished before the remote got all data")
+	errStreamFinished = errors.New("resilient stream already finished at the remote")
+)
+
+// Frames of a resilient stream. The sequence field of data frames is the stream offset of the
+// first payload byte, of ack, hello and fin frames the offset of the next byte expected.
+const (
+	frameHello byte = iota + 1
+	frameData
+	frameAck
+	frameFin
+)
+
+const frameHeaderLength = 13
+
+// hello flags
+const helloResume byte = 1
+
+func encodeFrame(kind byte, seq uint64, payload []byte) []byte {
+	buf := make([]byte, frameHeaderLength+len(payload))
+	buf[0] = kind
+	binary.BigEndian.PutUint64(buf[1:9], seq)
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(payload)))
+	copy(buf[frameHeaderLength:], payload)
+	return buf
+}
+
+func writeFrame(w io.Writer, kind byte, seq uint64, payload []byte) error {
+	_, err := w.Write(encodeFrame(kind, seq, payload))
+	return err
+}
+
+func readFrame(r io.Reader) (byte, uint64, []byte, error) {
+	var head [frameHeaderLength]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head[9:13])
+	if n > ResilientMaxFrame {
+		return 0, 0, nil, errors.New("resilient frame too large")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return head[0], binary.BigEndian.Uint64(head[1:9]), payload, nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// ResilientConn is a logical stream to a WhiteNoiseID that outlives the circuits it runs on.
+// When its circuit drops the dialing side builds a new one and both sides resume where the
+// other left off: every byte has an offset in the stream, bytes are kept until the remote
+// acknowledges them and are sent again on the new circuit, and bytes already received are
+// dropped, so nothing is lost or duplicated across the switch.
+type ResilientConn struct {
+	client        *WhiteNoiseClient
+	id            [resumeIDLength]byte
+	dialer        bool
+	opts          []DialOption
+	localID       string
+	remoteID      string
+	ResumeTimeout time.Duration
+	onClose       func()
+
+	mu       sync.Mutex
+	cur      SecureConnection
+	gen      int
+	sendBase uint64 //stream offset of sendBuf[0]
+	sendBuf  []byte //written but not acknowledged yet
+	recvNext uint64
+	acked    uint64
+	ackTimer *time.Timer
+	recvBuf  []byte
+	readErr  error
+	closed   bool
+	closing  bool //Close waits for the remote to get the rest of the stream
+
+	//serializes frames on the circuit, so a new circuit gets the resent bytes before new ones
+	sendMu sync.Mutex
+
+	readable      chan struct{}
+	writable      chan struct{}
+	ackCh         chan struct{}
+	readDeadline  deadline
+	writeDeadline deadline
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+func newResilientConn(client *WhiteNoiseClient, localID string, remoteID string, dialer bool) *ResilientConn {
+	rc := &ResilientConn{
+		client:        client,
+		dialer:        dialer,
+		localID:       localID,
+		remoteID:      remoteID,
+		ResumeTimeout: ResumeTimeout,
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		ackCh:         make(chan struct{}, 1),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		done:          make(chan struct{}),
+	}
+	if client != nil {
+		rc.ResumeTimeout = client.ResumeTimeout
+	}
+	go rc.ackLoop()
+	return rc
+}
+
+// DialResilient dials remoteID like DialContext, but the connection survives the loss of its
+// circuit. The remote must accept it with ListenResilient.
+func (sdk *WhiteNoiseClient) DialResilient(ctx context.Context, remoteID string, opts ...DialOption) (SecureConnection, error) {
+	rc := newResilientConn(sdk, sdk.GetWhiteNoiseID(), remoteID, true)
+	rc.opts = append(append([]DialOption(nil), opts...), withProtocol(ResilientProtocol))
+	if _, err := rand.Read(rc.id[:]); err != nil {
+		rc.finish(err)
+		return nil, err
+	}
+	conn, ack, err := rc.dial(ctx, false)
+	if err == nil {
+		err = rc.attach(conn, ack, false)
+	}
+	if err != nil {
+		rc.finish(err)
+		return nil, err
+	}
+	return rc, nil
+}
+
+// dial builds a new circuit and trades stream offsets with the remote.
+func (rc *ResilientConn) dial(ctx context.Context, resume bool) (SecureConnection, uint64, error) {
+	conn, _, err := rc.client.DialContext(ctx, rc.remoteID, rc.opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc.mu.Lock()
+	recvNext := rc.recvNext
+	rc.mu.Unlock()
+	flags := byte(0)
+	if resume {
+		flags = helloResume
+	}
+
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	err = writeFrame(conn, frameHello, recvNext, append(rc.id[:], flags))
+	var kind byte
+	var ack uint64
+	var payload []byte
+	if err == nil {
+		kind, ack, payload, err = readFrame(conn)
+	}
+	conn.SetDeadline(time.Time{})
+	if err == nil && kind == frameFin {
+		err = errStreamFinished
+	} else if err == nil && (kind != frameHello || !bytes.Equal(payload, rc.id[:])) {
+		err = errors.New("unexpected resilient hello")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	return conn, ack, nil
+}
+
+// attach makes conn the circuit of the stream and sends again what the remote has not got,
+// peerAck is the offset the remote expects next.
+func (rc *ResilientConn) attach(conn SecureConnection, peerAck uint64, reply bool) error {
+	rc.sendMu.Lock()
+	defer rc.sendMu.Unlock()
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		conn.Close()
+		return ErrConnClosed
+	}
+	if peerAck < rc.sendBase || peerAck > rc.sendBase+uint64(len(rc.sendBuf)) {
+		rc.mu.Unlock()
+		conn.Close()
+		return ErrResumeMismatch
+	}
+	rc.ackUpTo(peerAck)
+	old := rc.cur
+	rc.cur = conn
+	rc.gen++
+	gen := rc.gen
+	rc.acked = rc.recvNext
+	recvNext := rc.recvNext
+	offset := rc.sendBase
+	pending := append([]byte(nil), rc.sendBuf...)
+	//a closing stream waits for a circuit to send its fin on
+	notify(rc.writable)
+	rc.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	go rc.readCircuit(conn, gen)
+	if reply {
+		if err := writeFrame(conn, frameHello, recvNext, rc.id[:]); err != nil {
+			rc.circuitDown(gen, err)
+			return nil
+		}
+	}
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > ResilientMaxFrame {
+			n = ResilientMaxFrame
+		}
+		if err := writeFrame(conn, frameData, offset, pending[:n]); err != nil {
+			rc.circuitDown(gen, err)
+			return nil
+		}
+		offset += uint64(n)
+		pending = pending[n:]
+	}
+	return nil
+}
+
+// ackUpTo drops the bytes the remote has got, rc.mu must be held.
+func (rc *ResilientConn) ackUpTo(ack uint64) {
+	if ack <= rc.sendBase || ack > rc.sendBase+uint64(len(rc.sendBuf)) {
+		return
+	}
+	rc.sendBuf = append([]byte(nil), rc.sendBuf[ack-rc.sendBase:]...)
+	rc.sendBase = ack
+	notify(rc.writable)
+}
+
+func (rc *ResilientConn) readCircuit(conn SecureConnection, gen int) {
+	for {
+		kind, seq, payload, err := readFrame(conn)
+		if err != nil {
+			rc.circuitDown(gen, err)
+			return
+		}
+		switch kind {
+		case frameData:
+			err = rc.receive(seq, payload)
+		case frameAck:
+			rc.mu.Lock()
+			rc.ackUpTo(seq)
+			rc.mu.Unlock()
+		case frameFin:
+			rc.mu.Lock()
+			rc.ackUpTo(seq)
+			rc.mu.Unlock()
+			rc.finish(rc.remoteFinished())
+			return
+		default:
+			err = errors.New("unexpected resilient frame")
+		}
+		if err != nil {
+			rc.circuitDown(gen, err)
+			return
+		}
+	}
+}
+
+func (rc *ResilientConn) receive(seq uint64, payload []byte) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if seq > rc.recvNext {
+		return errors.New("gap in resilient stream")
+	}
+	end := seq + uint64(len(payload))
+	if end <= rc.recvNext {
+		//sent again after a switch, the remote had not seen our ack yet
+		return nil
+	}
+	rc.recvBuf = append(rc.recvBuf, payload[rc.recvNext-seq:]...)
+	rc.recvNext = end
+	notify(rc.readable)
+	if rc.recvNext-rc.acked >= ResilientAckBytes {
+		notify(rc.ackCh)
+	} else if rc.ackTimer == nil {
+		rc.ackTimer = time.AfterFunc(ResilientAckDelay, func() {
+			notify(rc.ackCh)
+		})
+	}
+	return nil
+}
+
+// ackLoop acknowledges received bytes, but holds back while the application lags behind so the
+// remote stops sending.
+func (rc *ResilientConn) ackLoop() {
+	for {
+		select {
+		case <-rc.done:
+			return
+		case <-rc.ackCh:
+		}
+		rc.mu.Lock()
+		rc.ackTimer = nil
+		ack := rc.recvNext
+		cur, gen := rc.cur, rc.gen
+		if ack == rc.acked || cur == nil || len(rc.recvBuf) >= ResilientWindow {
+			rc.mu.Unlock()
+			continue
+		}
+		rc.acked = ack
+		rc.mu.Unlock()
+
+		rc.sendMu.Lock()
+		err := writeFrame(cur, frameAck, ack, nil)
+		rc.sendMu.Unlock()
+		if err != nil {
+			rc.circuitDown(gen, err)
+		}
+	}
+}
+
+// circuitDown drops the circuit of generation gen. The dialing side builds a new one, the
+// accepting side waits for the remote to come back.
+func (rc *ResilientConn) circuitDown(gen int, err error) {
+	rc.mu.Lock()
+	if gen != rc.gen || rc.cur == nil || rc.closed {
+		rc.mu.Unlock()
+		return
+	}
+	conn := rc.cur
+	rc.cur = nil
+	rc.mu.Unlock()
+	conn.Close()
+	log.Infof("Resilient connection to %v lost its circuit: %v", rc.remoteID, err)
+
+	if rc.dialer {
+		go rc.reconnect()
+		return
+	}
+	time.AfterFunc(rc.ResumeTimeout, func() {
+		rc.mu.Lock()
+		stale := rc.gen == gen && rc.cur == nil
+		rc.mu.Unlock()
+		if stale {
+			rc.finish(ErrResumeFailed)
+		}
+	})
+}
+
+func (rc *ResilientConn) reconnect() {
+	deadline := time.Now().Add(rc.ResumeTimeout)
+	backoff := time.Second
+	for {
+		select {
+		case <-rc.done:
+			return
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), rc.client.dialTimeout(rc.opts))
+		conn, ack, err := rc.dial(ctx, true)
+		cancel()
+		if err == nil {
+			err = rc.attach(conn, ack, false)
+			if err == nil {
+				log.Infof("Resilient connection to %v resumed on circuit %v", rc.remoteID, rc.SessionID())
+				return
+			}
+		}
+		switch {
+		case err == errStreamFinished:
+			rc.finish(rc.remoteFinished())
+			return
+		case err == ErrResumeMismatch || err == ErrConnClosed:
+			rc.finish(err)
+			return
+		case time.Now().After(deadline):
+			rc.finish(ErrResumeFailed)
+			return
+		}
+		log.Warnf("Resume connection to %v err: %v, retry in %v", rc.remoteID, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-rc.done:
+			return
+		}
+		backoff *= 2
+		if backoff > ResilientMaxBackoff {
+			backoff = ResilientMaxBackoff
+		}
+	}
+}
+
+// remoteFinished is the error a stream the remote finished ends with, io.EOF if it got all we wrote.
+func (rc *ResilientConn) remoteFinished() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.sendBuf) > 0 {
+		return ErrUnacknowledged
+	}
+	return io.EOF
+}
+
+// finish ends the stream, reads return what is buffered and then err.
+func (rc *ResilientConn) finish(err error) {
+	rc.closeOnce.Do(func() {
+		rc.mu.Lock()
+		rc.closed = true
+		rc.readErr = err
+		cur := rc.cur
+		rc.cur = nil
+		rc.gen++
+		rc.mu.Unlock()
+		close(rc.done)
+		if cur != nil {
+			cur.Close()
+		}
+		if rc.onClose != nil {
+			rc.onClose()
+		}
+	})
+}
+
+func (rc *ResilientConn) Read(b []byte) (int, error) {
+	for {
+		rc.mu.Lock()
+		if len(rc.recvBuf) > 0 {
+			held := len(rc.recvBuf) >= ResilientWindow
+			n := copy(b, rc.recvBuf)
+			rc.recvBuf = rc.recvBuf[n:]
+			if len(rc.recvBuf) == 0 {
+				rc.recvBuf = nil
+			}
+			rc.mu.Unlock()
+			if held {
+				notify(rc.ackCh)
+			}
+			return n, nil
+		}
+		if rc.closed {
+			err := rc.readErr
+			rc.mu.Unlock()
+			return 0, err
+		}
+		rc.mu.Unlock()
+
+		select {
+		case <-rc.readable:
+		case <-rc.done:
+		case <-rc.readDeadline.wait():
+			return 0, timeoutError{}
+		}
+	}
+}
+
+func (rc *ResilientConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		//wait for room in the window, the remote may be gone until the next circuit
+		rc.mu.Lock()
+		for !rc.closed && len(rc.sendBuf) >= ResilientWindow {
+			rc.mu.Unlock()
+			select {
+			case <-rc.writable:
+			case <-rc.done:
+			case <-rc.writeDeadline.wait():
+				return written, timeoutError{}
+			}
+			rc.mu.Lock()
+		}
+		n := ResilientWindow - len(rc.sendBuf)
+		rc.mu.Unlock()
+		if n > len(b) {
+			n = len(b)
+		}
+		if n > ResilientMaxFrame {
+			n = ResilientMaxFrame
+		}
+
+		rc.sendMu.Lock()
+		rc.mu.Lock()
+		if rc.closed || rc.closing {
+			rc.mu.Unlock()
+			rc.sendMu.Unlock()
+			return written, ErrConnClosed
+		}
+		seq := rc.sendBase + uint64(len(rc.sendBuf))
+		rc.sendBuf = append(rc.sendBuf, b[:n]...)
+		cur, gen := rc.cur, rc.gen
+		rc.mu.Unlock()
+		if cur != nil {
+			if err := writeFrame(cur, frameData, seq, b[:n]); err != nil {
+				//kept in the send buffer for the next circuit
+				rc.circuitDown(gen, err)
+			}
+		}
+		rc.sendMu.Unlock()
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Close ends the stream at both sides. It first waits, also across a switch of circuits, until the
+// remote has got every byte written, at most until the write deadline or ResumeTimeout.
+func (rc *ResilientConn) Close() error {
+	rc.mu.Lock()
+	if rc.closing {
+		rc.mu.Unlock()
+		return ErrConnClosed
+	}
+	rc.closing = true
+	if rc.closed {
+		err := rc.readErr
+		rc.mu.Unlock()
+		if err == io.EOF {
+			//the remote finished the stream first and got everything
+			return nil
+		}
+		return ErrConnClosed
+	}
+	rc.mu.Unlock()
+
+	timeout := time.NewTimer(rc.ResumeTimeout)
+	defer timeout.Stop()
+	for {
+		rc.mu.Lock()
+		if rc.closed {
+			err := rc.readErr
+			rc.mu.Unlock()
+			if err == io.EOF {
+				//the remote closed at the same time and got everything
+				return nil
+			}
+			return err
+		}
+		cur, gen := rc.cur, rc.gen
+		pending := len(rc.sendBuf)
+		seq := rc.sendBase
+		rc.mu.Unlock()
+		if cur != nil && pending == 0 {
+			rc.sendMu.Lock()
+			err := writeFrame(cur, frameFin, seq, nil)
+			rc.sendMu.Unlock()
+			if err == nil {
+				rc.finish(ErrConnClosed)
+				return nil
+			}
+			rc.circuitDown(gen, err)
+			continue
+		}
+
+		select {
+		case <-rc.writable:
+		case <-rc.done:
+		case <-rc.writeDeadline.wait():
+			rc.finish(ErrUnacknowledged)
+			return ErrUnacknowledged
+		case <-timeout.C:
+			rc.finish(ErrUnacknowledged)
+			return ErrUnacknowledged
+		}
+	}
+}
+
+// Ping pings the remote over the current circuit.
+func (rc *ResilientConn) Ping(ctx context.Context) (time.Duration, error) {
+	rc.mu.Lock()
+	cur := rc.cur
+	rc.mu.Unlock()
+	if cur == nil {
+		return 0, ErrNoCircuit
+	}
+	return cur.Ping(ctx)
+}
+
+// SessionID returns the session id of the current circuit, empty while there is none.
+func (rc *ResilientConn) SessionID() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if c, ok := rc.cur.(*Conn); ok {
+		return c.SessionID()
+	}
+	return ""
+}
+
+func (rc *ResilientConn) LocalAddr() net.Addr {
+	return WhiteNoiseAddr{ID: rc.localID}
+}
+
+func (rc *ResilientConn) RemoteAddr() net.Addr {
+	return WhiteNoiseAddr{ID: rc.remoteID}
+}
+
+func (rc *ResilientConn) SetDeadline(t time.Time) error {
+	rc.readDeadline.set(t)
+	rc.writeDeadline.set(t)
+	return nil
+}
+
+func (rc *ResilientConn) SetReadDeadline(t time.Time) error {
+	rc.readDeadline.set(t)
+	return nil
+}
+
+func (rc *ResilientConn) SetWriteDeadline(t time.Time) error {
+	rc.writeDeadline.set(t)
+	return nil
+}
+
+func (rc *ResilientConn) LocalWhiteNoiseID() string {
+	return rc.localID
+}
+
+func (rc *ResilientConn) RemoteWhiteNoiseID() string {
+	return rc.remoteID
+}
+
+// ResilientListener accepts connections dialed with DialResilient, and hands the circuits that
+// resume one of them to the connection instead of Accept.
+type ResilientListener struct {
+	inner     net.Listener
+	client    *WhiteNoiseClient
+	conns     map[[resumeIDLength]byte]*ResilientConn
+	mut       sync.Mutex
+	accepted  chan *ResilientConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenResilient listens for connections dialed with DialResilient, next to a Listen listener
+// that gets the other circuits.
+func (sdk *WhiteNoiseClient) ListenResilient() (net.Listener, error) {
+	inner, err := sdk.listen(ResilientProtocol)
+	if err != nil {
+		return nil, err
+	}
+	l := &ResilientListener{
+		inner:    inner,
+		client:   sdk,
+		conns:    make(map[[resumeIDLength]byte]*ResilientConn),
+		accepted: make(chan *ResilientConn, AcceptBacklog),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+func (l *ResilientListener) acceptLoop() {
+	for {
+		conn, err := l.inner.Accept()
+		if err != nil {
+			l.Close()
+			return
+		}
+		go l.handshake(conn.(SecureConnection))
+	}
+}
+
+func (l *ResilientListener) handshake(conn SecureConnection) {
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	kind, ack, payload, err := readFrame(conn)
+	conn.SetDeadline(time.Time{})
+	if err == nil && (kind != frameHello || len(payload) != resumeIDLength+1) {
+		err = errors.New("unexpected resilient hello")
+	}
+	if err != nil {
+		log.Debugf("Drop circuit from %v: %v", conn.RemoteWhiteNoiseID(), err)
+		conn.Close()
+		return
+	}
+	var id [resumeIDLength]byte
+	copy(id[:], payload)
+	resume := payload[resumeIDLength]&helloResume != 0
+
+	l.mut.Lock()
+	rc, ok := l.conns[id]
+	if !ok && !resume {
+		rc = newResilientConn(l.client, l.client.GetWhiteNoiseID(), conn.RemoteWhiteNoiseID(), false)
+		rc.id = id
+		rc.onClose = func() {
+			l.mut.Lock()
+			defer l.mut.Unlock()
+			if l.conns[id] == rc {
+				delete(l.conns, id)
+			}
+		}
+		l.conns[id] = rc
+	}
+	l.mut.Unlock()
+	if !ok && resume {
+		//the stream was closed here, its fin may have been lost with the circuit
+		writeFrame(conn, frameFin, 0, nil)
+		conn.Close()
+		return
+	}
+	if rc.remoteID != conn.RemoteWhiteNoiseID() {
+		log.Warnf("Circuit from %v tries to resume a connection of %v", conn.RemoteWhiteNoiseID(), rc.remoteID)
+		conn.Close()
+		return
+	}
+
+	err = rc.attach(conn, ack, true)
+	if err != nil {
+		log.Warnf("Resume connection from %v err: %v", rc.remoteID, err)
+		rc.finish(err)
+		return
+	}
+	if !ok {
+		select {
+		case l.accepted <- rc:
+		case <-l.done:
+			rc.Close()
+		}
+	}
+}
+
+func (l *ResilientListener) Accept() (net.Conn, error) {
+	select {
+	case rc := <-l.accepted:
+		return rc, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops accepting, accepted connections can no longer resume after it.
+func (l *ResilientListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.inner.Close()
+	})
+	return nil
+}
+
+func (l *ResilientListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
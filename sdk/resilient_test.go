@@ -0,0 +1,274 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"github.com/magiconair/properties/assert"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func waitNoCircuit(t *testing.T, rc *ResilientConn) {
+	for i := 0; i < 100; i++ {
+		rc.mu.Lock()
+		cur := rc.cur
+		rc.mu.Unlock()
+		if cur == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("circuit not dropped")
+}
+
+func TestResilientConnResume(t *testing.T) {
+	a := newResilientConn(nil, "a", "b", false)
+	b := newResilientConn(nil, "b", "a", false)
+	p1, p2 := net.Pipe()
+	go a.attach(pipeConn{p1}, 0, false)
+	if err := b.attach(pipeConn{p2}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 300000)
+	rand.Read(data)
+	go func() {
+		for rest := data; len(rest) > 0; {
+			n := rand.Intn(5000) + 1
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := a.Write(rest[:n]); err != nil {
+				return
+			}
+			rest = rest[n:]
+		}
+	}()
+
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(b, got[:100000]); err != nil {
+		t.Fatal(err)
+	}
+	//the circuit drops in the middle of the stream
+	p1.Close()
+	p2.Close()
+	waitNoCircuit(t, a)
+	waitNoCircuit(t, b)
+
+	//resume from the last ack, so bytes b already has are sent again and must be dropped
+	b.mu.Lock()
+	bAcked := b.acked
+	b.mu.Unlock()
+	a.mu.Lock()
+	aNext := a.recvNext
+	a.mu.Unlock()
+	q1, q2 := net.Pipe()
+	attached := make(chan error, 1)
+	go func() {
+		attached <- a.attach(pipeConn{q1}, bAcked, false)
+	}()
+	if err := b.attach(pipeConn{q2}, aNext, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-attached; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(b, got[100000:]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(got, data), true)
+
+	a.Close()
+	_, err := b.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+	_, err = a.Write([]byte("closed"))
+	assert.Equal(t, err, ErrConnClosed)
+}
+
+func TestResilientConnMismatch(t *testing.T) {
+	a := newResilientConn(nil, "a", "b", false)
+	defer a.finish(ErrConnClosed)
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	//the remote claims bytes that were never sent
+	err := a.attach(pipeConn{p1}, 5, false)
+	assert.Equal(t, err, ErrResumeMismatch)
+}
+
+func TestResilientConnFinUnacknowledged(t *testing.T) {
+	a := newResilientConn(nil, "a", "b", false)
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	go io.Copy(ioutil.Discard, p2)
+	if err := a.attach(pipeConn{p1}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	//the remote finishes the stream without having got the bytes
+	if err := writeFrame(p2, frameFin, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := a.Read(make([]byte, 1))
+	assert.Equal(t, err, ErrUnacknowledged)
+}
+
+func TestResilientConnRemoteFin(t *testing.T) {
+	a := newResilientConn(nil, "a", "b", false)
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	go io.Copy(ioutil.Discard, p2)
+	if err := a.attach(pipeConn{p1}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFrame(p2, frameFin, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := a.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+	//closing after the remote finished the stream is no error, closing twice is
+	assert.Equal(t, a.Close(), nil)
+	assert.Equal(t, a.Close(), ErrConnClosed)
+}
+
+//breakCircuit tears down the current circuit of rc, as a relay going away would.
+func breakCircuit(t *testing.T, client *WhiteNoiseClient, rc *ResilientConn) {
+	sessionID := rc.SessionID()
+	if sessionID == "" {
+		t.Fatal("no circuit to break")
+	}
+	if err := client.DisconnectCircuit(sessionID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dialResilientPair(t *testing.T, a *WhiteNoiseClient, b *WhiteNoiseClient) (*ResilientConn, *ResilientConn) {
+	l, err := b.ListenResilient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := a.DialResilient(ctx, b.GetWhiteNoiseID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case answer := <-accepted:
+		return conn.(*ResilientConn), answer.(*ResilientConn)
+	case <-time.After(10 * time.Second):
+		t.Fatal("resilient connection not accepted")
+	}
+	return nil, nil
+}
+
+func TestDialResilientBreak(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	//a plain listener works next to the resilient one
+	if _, err := b.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	conn, answer := dialResilientPair(t, a, b)
+
+	data := make([]byte, 2<<20)
+	rand.Read(data)
+	go func() {
+		for rest := data; len(rest) > 0; {
+			n := rand.Intn(16<<10) + 1
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := conn.Write(rest[:n]); err != nil {
+				return
+			}
+			rest = rest[n:]
+		}
+	}()
+
+	got := make([]byte, len(data))
+	answer.SetReadDeadline(time.Now().Add(60 * time.Second))
+	read := 0
+	//the circuit breaks twice while data is in flight, once torn down by each end
+	for i, breaker := range []struct {
+		client *WhiteNoiseClient
+		rc     *ResilientConn
+	}{{a, conn}, {b, answer}} {
+		end := (i + 1) * len(data) / 3
+		if _, err := io.ReadFull(answer, got[read:end]); err != nil {
+			t.Fatal(err)
+		}
+		read = end
+		old := conn.SessionID()
+		breakCircuit(t, breaker.client, breaker.rc)
+		waitResumed(t, conn, old)
+	}
+	if _, err := io.ReadFull(answer, got[read:]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(got, data), true)
+
+	//and the other way round on the last circuit
+	go answer.Write([]byte("done"))
+	reply := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "done" {
+		t.Fatalf("reply %q: %v", reply, err)
+	}
+}
+
+func TestResilientCloseWithoutCircuit(t *testing.T) {
+	n := getTestNetwork(t)
+	a, b := n.client(t, 0), n.client(t, 1)
+	conn, answer := dialResilientPair(t, a, b)
+
+	//the accepting end waits for the dialer to build a new circuit, it writes and closes without one
+	data := make([]byte, 256<<10)
+	rand.Read(data)
+	breakCircuit(t, b, answer)
+	waitNoCircuit(t, answer)
+	if _, err := answer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- answer.Close()
+	}()
+
+	got := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(got, data), true)
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect io.EOF after the data, got %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+//waitResumed waits until conn runs on a circuit other than old.
+func waitResumed(t *testing.T, conn *ResilientConn, old string) {
+	for i := 0; i < 300; i++ {
+		if id := conn.SessionID(); id != "" && id != old {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("connection not resumed")
+}
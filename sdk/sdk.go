@@ -10,32 +10,64 @@ import (
 	"github.com/Evanesco-Labs/WhiteNoise/common/config"
 	"github.com/Evanesco-Labs/WhiteNoise/common/log"
 	"github.com/Evanesco-Labs/WhiteNoise/network"
+	"github.com/Evanesco-Labs/WhiteNoise/protocol/relay"
 	"github.com/asaskevich/EventBus"
 	core "github.com/libp2p/go-libp2p-core"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/mr-tron/base58"
+	"net"
+	"sync"
 	"time"
 )
 
-var BootStrapPeers string = ""
+//BootStrapPeers are the multiaddrs new clients bootstrap from, MainNet peers are asked from them in random order.
+var BootStrapPeers []string
+
+//PeerStorePath is the leveldb directory clients made by NewClient keep their proxies in, so they can find the network
+//again without a bootstrap. Empty turns it off, one-time clients never keep them.
+var PeerStorePath string
+
+//CircuitCover is the default end-to-end cover traffic of new clients, on circuits they dial or answer.
+var CircuitCover = config.CoverConfig{}
 
 const NewCircuitTimeout = 10 * time.Second
 const GetCircuitTopic string = common.NewSecureConnAnswerTopic
 const GenCircuitSuccessTopic string = common.NewSecureConnCallerTopic
 
+//ProxyChangedTopic is published with the old and the new primary proxy when the client fails over, the new one is
+//empty if no proxy is left.
+const ProxyChangedTopic string = common.ProxyChangedTopic
+
+//CircuitClosedTopic is published with the session id and the relay.DisconnectReason when a circuit of this client is torn down.
+const CircuitClosedTopic string = common.CircuitClosedTopic
+
+//CircuitDeadTopic is published with the session id and the last ping error when a circuit misses too many keepalive pings.
+const CircuitDeadTopic string = common.CircuitDeadTopic
+
+//Circuits that stay idle for KeepaliveInterval are pinged, and closed after KeepaliveMaxMissed pings in a row are not answered in PingTimeout.
+const KeepaliveInterval = 30 * time.Second
+const PingTimeout = 10 * time.Second
+const KeepaliveMaxMissed = 3
+
 type SecureConnection interface {
-	Read(b []byte) (n int, err error)
-	Write(b []byte) (n int, err error)
-	Close() error
+	net.Conn
 	LocalWhiteNoiseID() string
 	RemoteWhiteNoiseID() string
+	Ping(ctx context.Context) (time.Duration, error)
 }
 
 type Client interface {
 	GetMainNetPeers(cnt int) ([]peer.ID, error)
 	Register(proxy core.PeerID) error
-	Dial(remoteID string) (SecureConnection, string, error)
+	RegisterProxies(proxies []core.PeerID, count int) error
+	Proxies() []core.PeerID
+	RenewLease() (time.Duration, error)
+	Dial(remoteID string, opts ...DialOption) (SecureConnection, string, error)
+	DialContext(ctx context.Context, remoteID string, opts ...DialOption) (SecureConnection, string, error)
+	DialResilient(ctx context.Context, remoteID string, opts ...DialOption) (SecureConnection, error)
+	Listen() (net.Listener, error)
+	ListenResilient() (net.Listener, error)
 	GetCircuit(sessionID string) (SecureConnection, bool)
 	SendMessage(data []byte, sessionID string) error
 	DisconnectCircuit(sessionID string) error
@@ -45,25 +77,53 @@ type Client interface {
 }
 
 type WhiteNoiseClient struct {
-	node              *network.Node
-	NewCircuitTimeout time.Duration
+	node               *network.Node
+	NewCircuitTimeout  time.Duration
+	KeepaliveInterval  time.Duration //zero turns keepalive off
+	PingTimeout        time.Duration
+	KeepaliveMaxMissed int
+	ResumeTimeout      time.Duration //how long resilient connections try to resume before they give up
+	conns              map[string]*Conn
+	connMut            sync.Mutex
+	listeners          map[string]*Listener //by the protocol of the circuits they accept
+	listenMut          sync.Mutex
+	dialWaiters        map[string]chan error
+	dialMut            sync.Mutex
+}
+
+func newWhiteNoiseClient(node *network.Node) (*WhiteNoiseClient, error) {
+	client := &WhiteNoiseClient{
+		node:               node,
+		NewCircuitTimeout:  NewCircuitTimeout,
+		KeepaliveInterval:  KeepaliveInterval,
+		PingTimeout:        PingTimeout,
+		KeepaliveMaxMissed: KeepaliveMaxMissed,
+		ResumeTimeout:      ResumeTimeout,
+		conns:              make(map[string]*Conn),
+		listeners:          make(map[string]*Listener),
+		dialWaiters:        make(map[string]chan error),
+	}
+	err := client.subscribeCircuitEvents()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
 }
 
 func NewClient(ctx context.Context, acc *account.Account) (*WhiteNoiseClient, error) {
 	cfg := config.NetworkConfig{
 		RendezvousString: "whitenoise",
 		BootStrapPeers:   BootStrapPeers,
+		PeerStorePath:    PeerStorePath,
 		Mode:             config.ClientMode,
+		CircuitCover:     CircuitCover,
 	}
 	node, err := network.NewNode(ctx, &cfg, acc)
 	if err != nil {
 		return nil, err
 	}
 	node.Start(&cfg)
-	return &WhiteNoiseClient{
-		node,
-		NewCircuitTimeout,
-	}, nil
+	return newWhiteNoiseClient(node)
 }
 
 func NewOneTimeClient(ctx context.Context, keyType int) (*WhiteNoiseClient, error) {
@@ -71,6 +131,7 @@ func NewOneTimeClient(ctx context.Context, keyType int) (*WhiteNoiseClient, erro
 		RendezvousString: "whitenoise",
 		BootStrapPeers:   BootStrapPeers,
 		Mode:             config.ClientMode,
+		CircuitCover:     CircuitCover,
 	}
 	acc, err := account.NewOneTimeAccount(keyType)
 	if err != nil {
@@ -81,10 +142,7 @@ func NewOneTimeClient(ctx context.Context, keyType int) (*WhiteNoiseClient, erro
 		return nil, err
 	}
 	node.Start(&cfg)
-	return &WhiteNoiseClient{
-		node,
-		NewCircuitTimeout,
-	}, nil
+	return newWhiteNoiseClient(node)
 }
 
 func (sdk *WhiteNoiseClient) GetMainNetPeers(cnt int) ([]peer.ID, error) {
@@ -105,31 +163,73 @@ func (sdk *WhiteNoiseClient) Register(proxy core.PeerID) error {
 	return sdk.node.NoiseService.RegisterProxy(proxy)
 }
 
-func (sdk *WhiteNoiseClient) Dial(remoteID string) (SecureConnection, string, error) {
-	sessionID := generateSessionID(remoteID, sdk.GetWhiteNoiseID())
-	err := sdk.node.NoiseService.NewCircuit(remoteID, sessionID)
-	if err != nil {
-		return nil, "", err
-	}
-	timeout := time.After(sdk.NewCircuitTimeout)
-	for {
-		time.Sleep(time.Millisecond * 10)
-		if conn, ok := sdk.GetCircuit(sessionID); ok {
-			return conn, sessionID, nil
-		}
+// RegisterProxies registers to the count proxies with the lowest round trip time among proxies, usually
+// from GetMainNetPeers, so the client can be reached through any of them. When a registered proxy goes
+// away the client registers to the next best one in the background.
+func (sdk *WhiteNoiseClient) RegisterProxies(proxies []core.PeerID, count int) error {
+	return sdk.node.NoiseService.RegisterProxies(proxies, count)
+}
 
-		select {
-		case <-timeout:
-			return nil, "", errors.New("new circuit failed timeout")
-		default:
-			continue
-		}
+// Proxies returns the proxies the client is registered at, the one new circuits are dialed through first.
+func (sdk *WhiteNoiseClient) Proxies() []core.PeerID {
+	return sdk.node.NoiseService.Proxies()
+}
+
+// RenewLease renews the registration at the proxy at once and returns how long it lasts.
+// Register already renews it in the background before it expires.
+func (sdk *WhiteNoiseClient) RenewLease() (time.Duration, error) {
+	return sdk.node.NoiseService.RenewProxy()
+}
+
+func (sdk *WhiteNoiseClient) Dial(remoteID string, opts ...DialOption) (SecureConnection, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sdk.dialTimeout(opts))
+	defer cancel()
+	return sdk.DialContext(ctx, remoteID, opts...)
+}
+
+func (sdk *WhiteNoiseClient) dialTimeout(opts []DialOption) time.Duration {
+	//every extra relay hop is extended one after another
+	timeout := sdk.NewCircuitTimeout
+	if o := newDialOptions(opts); o.hops > common.DefaultRelayHops {
+		timeout += common.ExpendSessionTimeout * time.Duration(o.hops-common.DefaultRelayHops)
 	}
+	return timeout
 }
 
 func (sdk *WhiteNoiseClient) GetCircuit(sessionID string) (SecureConnection, bool) {
-	conn, ok := sdk.node.NoiseService.Relay().GetSecureConn(sessionID)
-	return conn, ok
+	sdk.connMut.Lock()
+	defer sdk.connMut.Unlock()
+	secureConn, ok := sdk.node.NoiseService.Relay().GetSecureConn(sessionID)
+	if !ok {
+		delete(sdk.conns, sessionID)
+		return nil, false
+	}
+	if conn, ok := sdk.conns[sessionID]; ok && conn.session == secureConn {
+		return conn, true
+	}
+	conn := newConn(sdk, sessionID, secureConn)
+	sdk.conns[sessionID] = conn
+	return conn, true
+}
+
+func (sdk *WhiteNoiseClient) removeConn(conn *Conn) {
+	sdk.connMut.Lock()
+	defer sdk.connMut.Unlock()
+	if sdk.conns[conn.sessionID] == conn {
+		delete(sdk.conns, conn.sessionID)
+	}
+}
+
+//onCircuitClosed keeps the reason of the teardown on the connection, it is returned by reads and writes from now on.
+//A circuit torn down during setup fails its dial with the reason.
+func (sdk *WhiteNoiseClient) onCircuitClosed(sessionID string, reason relay.DisconnectReason) {
+	sdk.notifyDialWaiter(sessionID, &DisconnectError{Reason: reason})
+	sdk.connMut.Lock()
+	conn, ok := sdk.conns[sessionID]
+	sdk.connMut.Unlock()
+	if ok {
+		conn.setCloseErr(&DisconnectError{Reason: reason})
+	}
 }
 
 func (sdk *WhiteNoiseClient) SendMessage(data []byte, sessionID string) error {
@@ -145,7 +245,7 @@ func (sdk *WhiteNoiseClient) SendMessage(data []byte, sessionID string) error {
 }
 
 func (sdk *WhiteNoiseClient) DisconnectCircuit(sessionID string) error {
-	return sdk.node.NoiseService.Relay().CloseCircuit(sessionID)
+	return sdk.node.NoiseService.Relay().CloseCircuit(sessionID, relay.ReasonRemoteClosed)
 }
 
 func (sdk *WhiteNoiseClient) GetWhiteNoiseID() string {
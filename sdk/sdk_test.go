@@ -0,0 +1,142 @@
+package sdk
+
+import (
+	"context"
+	"github.com/Evanesco-Labs/WhiteNoise/crypto"
+	core "github.com/libp2p/go-libp2p-core"
+	"github.com/magiconair/properties/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+//redundantClient registers a new one-time client to count of the servers with the given indexes.
+func (n *testNetwork) redundantClient(t *testing.T, servers []int, count int) *WhiteNoiseClient {
+	client, err := NewOneTimeClient(context.Background(), crypto.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.UnRegister()
+		client.node.Host().Close()
+	})
+	if _, err := client.GetMainNetPeers(10); err != nil {
+		t.Fatal(err)
+	}
+	var candidates []core.PeerID
+	for _, i := range servers {
+		candidates = append(candidates, n.servers[i].Host().ID())
+	}
+	if err := client.RegisterProxies(candidates, count); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(client.Proxies()), count)
+	return client
+}
+
+//loseProxy drops the connection to proxy, as a proxy going away would.
+func loseProxy(t *testing.T, client *WhiteNoiseClient, proxy core.PeerID) {
+	if err := client.node.Host().Network().ClosePeer(proxy); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//waitProxies waits until client is registered at the proxies in want, in this order.
+func waitProxies(t *testing.T, client *WhiteNoiseClient, want []core.PeerID) {
+	var got []core.PeerID
+	for i := 0; i < 100; i++ {
+		got = client.Proxies()
+		if len(got) == len(want) {
+			same := true
+			for j := range got {
+				same = same && got[j] == want[j]
+			}
+			if same {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("proxies %v, expect %v", got, want)
+}
+
+func TestProxyFailoverPrimary(t *testing.T) {
+	n := getTestNetwork(t)
+	a := n.redundantClient(t, []int{1, 2, 3}, 2)
+	b := n.client(t, 4)
+	l, err := b.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	proxies := a.Proxies()
+	primary, second := proxies[0], proxies[1]
+	var backup core.PeerID
+	for _, i := range []int{1, 2, 3} {
+		if id := n.servers[i].Host().ID(); id != primary && id != second {
+			backup = id
+		}
+	}
+	changed := make(chan [2]core.PeerID, 1)
+	a.EventBus().Subscribe(ProxyChangedTopic, func(old core.PeerID, primary core.PeerID) {
+		select {
+		case changed <- [2]core.PeerID{old, primary}:
+		default:
+		}
+	})
+
+	//the second proxy is promoted, and the backup takes its place rather than the lost proxy
+	loseProxy(t, a, primary)
+	select {
+	case change := <-changed:
+		assert.Equal(t, change, [2]core.PeerID{primary, second})
+	case <-time.After(10 * time.Second):
+		t.Fatal("primary proxy not changed")
+	}
+	waitProxies(t, a, []core.PeerID{second, backup})
+
+	//new circuits go through the promoted proxy
+	conn, answer := dialPair(t, a, l, b.GetWhiteNoiseID())
+	defer conn.Close()
+	go answer.Write([]byte("failover"))
+	reply := make([]byte, 8)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "failover" {
+		t.Fatalf("reply %q: %v", reply, err)
+	}
+}
+
+func TestProxyFailoverRefill(t *testing.T) {
+	n := getTestNetwork(t)
+	servers := []int{1, 2, 3}
+	a := n.redundantClient(t, servers, 2)
+	proxies := a.Proxies()
+	var backup core.PeerID
+	for _, i := range servers {
+		if id := n.servers[i].Host().ID(); id != proxies[0] && id != proxies[1] {
+			backup = id
+		}
+	}
+	changed := make(chan [2]core.PeerID, 2)
+	a.EventBus().Subscribe(ProxyChangedTopic, func(old core.PeerID, primary core.PeerID) {
+		select {
+		case changed <- [2]core.PeerID{old, primary}:
+		default:
+		}
+	})
+
+	//losing the other proxy refills from the backup and keeps the primary
+	loseProxy(t, a, proxies[1])
+	waitProxies(t, a, []core.PeerID{proxies[0], backup})
+	select {
+	case change := <-changed:
+		t.Fatalf("primary proxy changed %v", change)
+	default:
+	}
+
+	//the proxy lost before is a backup again, ranked before the primary lost now
+	loseProxy(t, a, proxies[0])
+	waitProxies(t, a, []core.PeerID{backup, proxies[1]})
+	assert.Equal(t, <-changed, [2]core.PeerID{proxies[0], backup})
+}
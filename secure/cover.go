@@ -0,0 +1,76 @@
+package secure
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+)
+
+//CoverKeySize is the size of the key an end recognises dummy cells with, it sends the key in its handshake payload.
+const CoverKeySize = 32
+
+const coverTagSize = 16
+
+var ErrCoverNotSupported = errors.New("remote end does not drop dummy cells")
+
+func NewCoverKey() []byte {
+	key := make([]byte, CoverKeySize)
+	rand.Read(key)
+	return key
+}
+
+func dummyTag(key []byte, seq uint64) []byte {
+	mac := hmac.New(sha256.New, key)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	mac.Write(b[:])
+	return mac.Sum(nil)[:coverTagSize]
+}
+
+//CoverSupported reports if dummy cells can be sent, they need cells and a remote end that advertised a cover key.
+func (s *SecureSession) CoverSupported() bool {
+	return s.cellSize != 0 && len(s.remoteCoverKey) == CoverKeySize
+}
+
+//NewDummy returns a dummy cell for the insecure connection. It has the size of any other cell and is random but
+//for a tag only the remote end can compute, so the remote end drops it before it reaches the session.
+func (s *SecureSession) NewDummy() ([]byte, error) {
+	if !s.CoverSupported() {
+		return nil, ErrCoverNotSupported
+	}
+	seq := atomic.AddUint64(&s.dummySeq, 1) - 1
+	cell := make([]byte, s.cellSize)
+	binary.BigEndian.PutUint16(cell, uint16(s.cellSize-LengthPrefixLength))
+	copy(cell[LengthPrefixLength:], dummyTag(s.remoteCoverKey, seq))
+	rand.Read(cell[LengthPrefixLength+coverTagSize:])
+	return cell, nil
+}
+
+//DummyFilter recognises the dummy cells tagged with key. Dummies are sent and relayed in order,
+//so each one carries the tag of the next sequence number.
+type DummyFilter struct {
+	key []byte
+	seq uint64
+}
+
+func NewDummyFilter(key []byte) *DummyFilter {
+	return &DummyFilter{key: key}
+}
+
+//Drop reports if msg is the next dummy cell. It is not safe for concurrent use.
+func (f *DummyFilter) Drop(msg []byte) bool {
+	if f == nil || len(msg) < LengthPrefixLength+coverTagSize {
+		return false
+	}
+	if int(binary.BigEndian.Uint16(msg)) != len(msg)-LengthPrefixLength {
+		return false
+	}
+	if !hmac.Equal(msg[LengthPrefixLength:LengthPrefixLength+coverTagSize], dummyTag(f.key, f.seq)) {
+		return false
+	}
+	f.seq++
+	return true
+}
@@ -37,10 +37,13 @@ func (s *SecureSession) runHandshake(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("error initializing handshake state: %w", err)
 	}
-	payload, err := s.generateHandshakePayload(kp)
+	//the answer offers its cell size in stage 1, the caller confirms it in stage 2 if it wants cells
+	payload, err := s.generateHandshakePayload(kp, s.cellSize)
 	if err != nil {
 		return err
 	}
+	wantCells := s.cellSize
+	s.cellSize = 0
 
 	maxMsgSize := 2*noise.DH25519.DHLen() + len(payload) + 2*poly1305.TagSize
 	hbuf := pool.Get(maxMsgSize + LengthPrefixLength)
@@ -57,16 +60,25 @@ func (s *SecureSession) runHandshake(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("error reading handshake message: %w", err)
 		}
-		err = s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
+		offer, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
 		if err != nil {
 			return err
 		}
+		if wantCells != 0 && offer != wantCells {
+			//old or different answer, fall back to plain frames
+			payload, err = s.generateHandshakePayload(kp, 0)
+			if err != nil {
+				return err
+			}
+			wantCells = 0
+		}
 
 		log.Debug("caller stage 2")
 		err = s.sendHandshakeMessage(hs, payload, hbuf)
 		if err != nil {
 			return fmt.Errorf("error sending handshake message: %w", err)
 		}
+		s.cellSize = wantCells
 	} else {
 		log.Debug("answer stage 0")
 		plaintext, err := s.readHandshakeMessage(hs)
@@ -85,10 +97,13 @@ func (s *SecureSession) runHandshake(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("error reading handshake message: %w", err)
 		}
-		err = s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
+		confirm, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
 		if err != nil {
 			return err
 		}
+		if wantCells != 0 && confirm == wantCells {
+			s.cellSize = wantCells
+		}
 	}
 
 	return nil
@@ -144,7 +159,7 @@ func (s *SecureSession) readHandshakeMessage(hs *noise.HandshakeState) ([]byte,
 	return msg, nil
 }
 
-func (s *SecureSession) generateHandshakePayload(localStatic noise.DHKey) ([]byte, error) {
+func (s *SecureSession) generateHandshakePayload(localStatic noise.DHKey, cellSize int) ([]byte, error) {
 	localKeyRaw, err := s.LocalPublicKey().Bytes()
 	if err != nil {
 		return nil, fmt.Errorf("error serializing libp2p identity key: %w", err)
@@ -158,6 +173,10 @@ func (s *SecureSession) generateHandshakePayload(localStatic noise.DHKey) ([]byt
 	payload := new(pb.NoiseHandshakePayload)
 	payload.IdentityKey = localKeyRaw
 	payload.IdentitySig = signedPayload
+	payload.CellSize = uint32(cellSize)
+	payload.CreditWindow = uint32(s.window)
+	payload.CoverKey = s.coverKey
+	payload.Protocol = s.protocol
 	payloadEnc, err := proto.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling handshake payload: %w", err)
@@ -165,36 +184,41 @@ func (s *SecureSession) generateHandshakePayload(localStatic noise.DHKey) ([]byt
 	return payloadEnc, nil
 }
 
-func (s *SecureSession) handleRemoteHandshakePayload(payload []byte, remoteStatic []byte) error {
+func (s *SecureSession) handleRemoteHandshakePayload(payload []byte, remoteStatic []byte) (int, error) {
 	nhp := new(pb.NoiseHandshakePayload)
 	err := proto.Unmarshal(payload, nhp)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling remote handshake payload: %w", err)
+		return 0, fmt.Errorf("error unmarshaling remote handshake payload: %w", err)
 	}
 
 	remotePubKey, err := crypto.UnmarshalPublicKey(nhp.GetIdentityKey())
 	if err != nil {
-		return err
+		return 0, err
 	}
 	id, err := peer.IDFromPublicKey(remotePubKey)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if s.initiator && s.remoteID != id {
-		return fmt.Errorf("peer id mismatch: expected %s, but remote key matches %s", s.remoteID.Pretty(), id.Pretty())
+		return 0, fmt.Errorf("peer id mismatch: expected %s, but remote key matches %s", s.remoteID.Pretty(), id.Pretty())
 	}
 
 	sig := nhp.GetIdentitySig()
 	msg := append([]byte(payloadSigPrefix), remoteStatic...)
 	ok, err := remotePubKey.Verify(msg, sig)
 	if err != nil {
-		return fmt.Errorf("error verifying signature: %w", err)
+		return 0, fmt.Errorf("error verifying signature: %w", err)
 	} else if !ok {
-		return fmt.Errorf("handshake signature invalid")
+		return 0, fmt.Errorf("handshake signature invalid")
 	}
 
 	s.remoteID = id
 	s.remoteKey = remotePubKey
-	return nil
+	s.remoteWindow = int(nhp.GetCreditWindow())
+	s.remoteCoverKey = nhp.GetCoverKey()
+	if !s.initiator {
+		s.protocol = nhp.GetProtocol()
+	}
+	return int(nhp.GetCellSize()), nil
 }
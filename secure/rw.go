@@ -2,6 +2,7 @@ package secure
 
 import (
 	"encoding/binary"
+	"errors"
 	pool "github.com/libp2p/go-buffer-pool"
 	"io"
 
@@ -12,6 +13,12 @@ const MaxTransportMsgLength = 0xffff
 const MaxPlaintextLength = MaxTransportMsgLength - poly1305.TagSize
 const LengthPrefixLength = 2
 
+//in cell mode every plaintext starts with the length of the data in the cell, the rest is padding
+const CellLengthLength = 2
+const MinCellSize = LengthPrefixLength + poly1305.TagSize + CellLengthLength
+
+var ErrInvalidCell = errors.New("invalid cell")
+
 func (s *SecureSession) Read(buf []byte) (int, error) {
 	s.readLock.Lock()
 	defer s.readLock.Unlock()
@@ -26,11 +33,19 @@ func (s *SecureSession) Read(buf []byte) (int, error) {
 		return copied, nil
 	}
 
+	return s.readNext(buf)
+}
+
+func (s *SecureSession) readNext(buf []byte) (int, error) {
 	nextMsgLen, err := s.readNextInsecureMsgLen()
 	if err != nil {
 		return 0, err
 	}
 
+	if s.cellSize != 0 {
+		return s.readCell(buf, nextMsgLen)
+	}
+
 	if len(buf) >= nextMsgLen {
 		if err := s.readNextMsgInsecure(buf[:nextMsgLen]); err != nil {
 			return 0, err
@@ -53,15 +68,24 @@ func (s *SecureSession) Read(buf []byte) (int, error) {
 		return 0, err
 	}
 
-	s.qseek = copy(buf, s.qbuf)
+	n := copy(buf, s.qbuf)
+	s.qseek = n
+	if n == len(s.qbuf) {
+		pool.Put(cbuf)
+		s.qseek, s.qbuf = 0, nil
+	}
 
-	return s.qseek, nil
+	return n, nil
 }
 
 func (s *SecureSession) Write(data []byte) (int, error) {
 	s.writeLock.Lock()
 	defer s.writeLock.Unlock()
 
+	if s.cellSize != 0 {
+		return s.writeCells(data)
+	}
+
 	var (
 		written int
 		cbuf    []byte
@@ -98,6 +122,76 @@ func (s *SecureSession) Write(data []byte) (int, error) {
 	return written, nil
 }
 
+//readCell decrypts one cell and strips its padding, data that does not fit in buf is queued.
+func (s *SecureSession) readCell(buf []byte, msgLen int) (int, error) {
+	if msgLen != s.cellSize-LengthPrefixLength {
+		return 0, ErrInvalidCell
+	}
+	cbuf := pool.Get(msgLen)
+	if err := s.readNextMsgInsecure(cbuf); err != nil {
+		pool.Put(cbuf)
+		return 0, err
+	}
+	plain, err := s.decrypt(cbuf[:0], cbuf)
+	if err != nil {
+		pool.Put(cbuf)
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(plain))
+	if n > len(plain)-CellLengthLength {
+		pool.Put(cbuf)
+		return 0, ErrInvalidCell
+	}
+	data := plain[CellLengthLength : CellLengthLength+n]
+	copied := copy(buf, data)
+	if copied == n {
+		pool.Put(cbuf)
+		return copied, nil
+	}
+	s.qbuf = cbuf[:copy(cbuf, data[copied:])]
+	s.qseek = 0
+	return copied, nil
+}
+
+//writeCells splits data into cells of the same size, so relays and observers only see the number of cells.
+func (s *SecureSession) writeCells(data []byte) (int, error) {
+	plain := pool.Get(s.cellSize - LengthPrefixLength - poly1305.TagSize)
+	defer pool.Put(plain)
+	cbuf := pool.Get(s.cellSize)
+	defer pool.Put(cbuf)
+
+	written := 0
+	for written < len(data) {
+		n, err := s.writeCell(plain, cbuf, data[written:])
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+//writeCell writes as much of data as fits into one cell, plain and cbuf are work buffers of the cell size.
+func (s *SecureSession) writeCell(plain []byte, cbuf []byte, data []byte) (int, error) {
+	n := copy(plain[CellLengthLength:], data)
+	binary.BigEndian.PutUint16(plain, uint16(n))
+	for i := CellLengthLength + n; i < len(plain); i++ {
+		plain[i] = 0
+	}
+
+	b, err := s.encrypt(cbuf[:LengthPrefixLength], plain)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint16(b, uint16(len(b)-LengthPrefixLength))
+
+	_, err = s.writeMsgInsecure(b)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (s *SecureSession) readNextInsecureMsgLen() (int, error) {
 	_, err := io.ReadFull(s.insecure, s.rlen[:])
 	if err != nil {
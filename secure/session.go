@@ -2,6 +2,7 @@ package secure
 
 import (
 	"context"
+	"fmt"
 	"github.com/flynn/noise"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -47,16 +48,45 @@ type SecureSession struct {
 	enc *noise.CipherState
 	dec *noise.CipherState
 
+	cellSize int // fixed size of every transport message, 0 if cells are off.
+
+	window       int // receive window advertised to the remote end, 0 if it gets no credit.
+	remoteWindow int // receive window of the remote end, 0 if it is an old end that sends no credit.
+
+	coverKey       []byte // key dummy cells to this end are tagged with, nil if it does not drop them.
+	remoteCoverKey []byte // key of the remote end for the dummy cells we send.
+	dummySeq       uint64
+
+	protocol string // application protocol the caller opened the session for.
+
 	readHandshakeMsgTimeout time.Duration
 }
 
-func NewSecureSession(localID peer.ID, privateKey crypto.PrivKey, ctx context.Context, insecure InsecureConn, remote peer.ID, initiator bool) (*SecureSession, error) {
+//NewSecureSession runs the handshake over insecure. A non zero cellSize asks for fixed size cells as initiator,
+//or offers them as answer, cells are only used if both sides use the same size.
+//window is the receive window this end grants credit for, both ends advertise theirs.
+//coverKey is the key of the DummyFilter on insecure, nil if this end does not drop dummy cells.
+//protocol is sent by the initiator and ignored for the answer, which learns it in the handshake.
+func NewSecureSession(localID peer.ID, privateKey crypto.PrivKey, ctx context.Context, insecure InsecureConn, remote peer.ID, initiator bool, cellSize int, window int, coverKey []byte, protocol string) (*SecureSession, error) {
+	if cellSize != 0 && cellSize <= MinCellSize {
+		return nil, fmt.Errorf("cell size %d too small", cellSize)
+	}
+	if cellSize > MaxTransportMsgLength+LengthPrefixLength {
+		return nil, fmt.Errorf("cell size %d too large", cellSize)
+	}
+	if !initiator {
+		protocol = ""
+	}
 	s := &SecureSession{
 		insecure:                insecure,
 		initiator:               initiator,
 		localID:                 localID,
 		localKey:                privateKey,
 		remoteID:                remote,
+		cellSize:                cellSize,
+		window:                  window,
+		coverKey:                coverKey,
+		protocol:                protocol,
 		readHandshakeMsgTimeout: common.ReadHandShakeMsgTimeout,
 	}
 
@@ -109,6 +139,21 @@ func (s *SecureSession) RemoteWhiteNoiseID() string {
 	return crypto2.WhiteNoiseIDFromP2PPK(s.remoteKey)
 }
 
+//CellSize returns the negotiated cell size, 0 if the session does not use cells.
+func (s *SecureSession) CellSize() int {
+	return s.cellSize
+}
+
+//RemoteWindow returns the receive window the remote end advertised, 0 if it does not use credit.
+func (s *SecureSession) RemoteWindow() int {
+	return s.remoteWindow
+}
+
+//Protocol returns the application protocol the caller opened the session for, empty for plain sessions.
+func (s *SecureSession) Protocol() string {
+	return s.protocol
+}
+
 func (s *SecureSession) Close() error {
 	return s.insecure.Close()
 }
@@ -0,0 +1,202 @@
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Evanesco-Labs/WhiteNoise/common"
+	crypto2 "github.com/Evanesco-Labs/WhiteNoise/crypto"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+//pipeConn records the size of every write, like relay messages seen by a relay node
+type pipeConn struct {
+	net.Conn
+	mut    sync.Mutex
+	writes []int
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	c.mut.Lock()
+	c.writes = append(c.writes, len(b))
+	c.mut.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *pipeConn) LocalID() crypto2.WhiteNoiseID {
+	return crypto2.WhiteNoiseID{}
+}
+
+func (c *pipeConn) RemoteID() crypto2.WhiteNoiseID {
+	return crypto2.WhiteNoiseID{}
+}
+
+func newSessionPair(t *testing.T, callerCell int, answerCell int) (*SecureSession, *SecureSession, *pipeConn) {
+	return newSessionPairWindow(t, callerCell, answerCell, common.CircuitConnWindow, common.CircuitConnWindow)
+}
+
+func newSessionPairWindow(t *testing.T, callerCell int, answerCell int, callerWindow int, answerWindow int) (*SecureSession, *SecureSession, *pipeConn) {
+	return newSessionPairCover(t, callerCell, answerCell, callerWindow, answerWindow, NewCoverKey(), NewCoverKey())
+}
+
+func newSessionPairCover(t *testing.T, callerCell int, answerCell int, callerWindow int, answerWindow int, callerCover []byte, answerCover []byte) (*SecureSession, *SecureSession, *pipeConn) {
+	callerKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callerID, _ := peer.IDFromPrivateKey(callerKey)
+	answerID, _ := peer.IDFromPrivateKey(answerKey)
+	c1, c2 := net.Pipe()
+	callerConn, answerConn := &pipeConn{Conn: c1}, &pipeConn{Conn: c2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	answerCh := make(chan *SecureSession, 1)
+	go func() {
+		s, err := NewSecureSession(answerID, answerKey, ctx, answerConn, "", false, answerCell, answerWindow, answerCover, "")
+		if err != nil {
+			t.Error(err)
+		}
+		answerCh <- s
+	}()
+	caller, err := NewSecureSession(callerID, callerKey, ctx, callerConn, answerID, true, callerCell, callerWindow, callerCover, "/test/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := <-answerCh
+	callerConn.mut.Lock()
+	callerConn.writes = nil
+	callerConn.mut.Unlock()
+	return caller, answer, callerConn
+}
+
+func TestSecureSessionCells(t *testing.T) {
+	caller, answer, conn := newSessionPair(t, 256, 256)
+	if caller.CellSize() != 256 || answer.CellSize() != 256 {
+		t.Fatalf("cells not negotiated: %v %v", caller.CellSize(), answer.CellSize())
+	}
+
+	msg := make([]byte, 1000)
+	rand.Read(msg)
+	go caller.Write(msg)
+	rec := make([]byte, len(msg))
+	//small reads exercise the queued rest of a cell
+	for read := 0; read < len(rec); {
+		end := read + 100
+		if end > len(rec) {
+			end = len(rec)
+		}
+		n, err := answer.Read(rec[read:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+	if string(rec) != string(msg) {
+		t.Fatal("data mismatch")
+	}
+
+	go caller.Write([]byte("hi"))
+	short := make([]byte, 2)
+	if _, err := io.ReadFull(answer, short); err != nil || string(short) != "hi" {
+		t.Fatalf("read short cell: %v %v", string(short), err)
+	}
+
+	conn.mut.Lock()
+	defer conn.mut.Unlock()
+	for _, l := range conn.writes {
+		if l != 256 {
+			t.Fatalf("expect cell size 256 on the wire, got %v", l)
+		}
+	}
+}
+
+func TestSecureSessionWindow(t *testing.T) {
+	caller, answer, _ := newSessionPairWindow(t, 0, 0, 1024, 2048)
+	if caller.Protocol() != "/test/1" || answer.Protocol() != "/test/1" {
+		t.Fatalf("protocol not sent: %v %v", caller.Protocol(), answer.Protocol())
+	}
+	if caller.RemoteWindow() != 2048 || answer.RemoteWindow() != 1024 {
+		t.Fatalf("windows not exchanged: %v %v", caller.RemoteWindow(), answer.RemoteWindow())
+	}
+	//an old answer advertises no window, the caller must not wait for its credit
+	caller, answer, _ = newSessionPairWindow(t, 256, 0, 1024, 0)
+	if caller.RemoteWindow() != 0 || answer.RemoteWindow() != 1024 {
+		t.Fatalf("expect no remote window for the old answer: %v %v", caller.RemoteWindow(), answer.RemoteWindow())
+	}
+}
+
+func TestSecureSessionCellsFallback(t *testing.T) {
+	//old answer without cell support
+	caller, answer, _ := newSessionPair(t, 256, 0)
+	if caller.CellSize() != 0 || answer.CellSize() != 0 {
+		t.Fatalf("expect plain frames: %v %v", caller.CellSize(), answer.CellSize())
+	}
+	//caller without cells
+	caller, answer, _ = newSessionPair(t, 0, 256)
+	if caller.CellSize() != 0 || answer.CellSize() != 0 {
+		t.Fatalf("expect plain frames: %v %v", caller.CellSize(), answer.CellSize())
+	}
+
+	go caller.Write([]byte("hello"))
+	rec := make([]byte, 5)
+	if _, err := io.ReadFull(answer, rec); err != nil || string(rec) != "hello" {
+		t.Fatalf("read plain frame: %v %v", string(rec), err)
+	}
+}
+
+func TestSecureSessionDummy(t *testing.T) {
+	answerCover := NewCoverKey()
+	caller, answer, _ := newSessionPairCover(t, 256, 256, common.CircuitConnWindow, common.CircuitConnWindow, NewCoverKey(), answerCover)
+	if !caller.CoverSupported() || !answer.CoverSupported() {
+		t.Fatal("expect cover traffic with cells")
+	}
+	filter := NewDummyFilter(answerCover)
+	for i := 0; i < 3; i++ {
+		dummy, err := caller.NewDummy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		//a dummy looks like any other cell on the wire
+		if len(dummy) != 256 || int(dummy[0])<<8|int(dummy[1]) != 256-LengthPrefixLength {
+			t.Fatalf("dummy of %v bytes is not a cell", len(dummy))
+		}
+		if !filter.Drop(dummy) {
+			t.Fatalf("dummy %v not dropped", i)
+		}
+	}
+	//dummies for another end, out of order or replayed are data
+	dummy, _ := answer.NewDummy()
+	if filter.Drop(dummy) {
+		t.Fatal("dropped a dummy for the caller")
+	}
+	dummy, _ = caller.NewDummy()
+	if NewDummyFilter(answerCover).Drop(dummy) {
+		t.Fatal("dropped a dummy out of order")
+	}
+	var nilFilter *DummyFilter
+	if nilFilter.Drop(dummy) {
+		t.Fatal("nil filter dropped a cell")
+	}
+
+	//an old answer does not drop dummies, they would reach its session as data
+	caller, _, _ = newSessionPairCover(t, 256, 256, common.CircuitConnWindow, common.CircuitConnWindow, NewCoverKey(), nil)
+	if _, err := caller.NewDummy(); err != ErrCoverNotSupported {
+		t.Fatalf("expect %v, got %v", ErrCoverNotSupported, err)
+	}
+	//plain frames have all kinds of sizes, a dummy would stand out
+	caller, _, _ = newSessionPair(t, 0, 256)
+	if caller.CoverSupported() {
+		t.Fatal("expect no cover traffic without cells")
+	}
+}